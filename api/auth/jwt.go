@@ -0,0 +1,191 @@
+// Package auth implements the token formats and protocols used to log in
+// commenters with external identity providers
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Errors returned when validating tokens
+var (
+	ErrMalformedToken   = errors.New("Token is malformed")
+	ErrUnsupportedAlg   = errors.New("Token signing algorithm is not supported")
+	ErrInvalidSignature = errors.New("Token signature is not valid")
+	ErrTokenExpired     = errors.New("Token has expired")
+	ErrInvalidIssuer    = errors.New("Token issuer is not trusted")
+	ErrInvalidAudience  = errors.New("Token audience is not valid")
+	ErrInvalidNonce     = errors.New("Token nonce does not match")
+)
+
+// clockSkew is the leeway given to time based claims
+const clockSkew = time.Minute
+
+// jwtHeader is the decoded header of a json web token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwt is a json web token split into its parts
+type jwt struct {
+	header       jwtHeader
+	payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+// parseJWT splits and decodes a compact serialized json web token without
+// verifying it
+func parseJWT(raw string) (*jwt, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	token := &jwt{signingInput: parts[0] + "." + parts[1]}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(header, &token.header) != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if token.payload, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if token.signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	return token, nil
+}
+
+// verifyRS256 checks the RS256 signature of token against key
+func (token *jwt) verifyRS256(key *rsa.PublicKey) error {
+	if token.header.Alg != "RS256" {
+		return ErrUnsupportedAlg
+	}
+
+	hash := sha256.Sum256([]byte(token.signingInput))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], token.signature) != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// audience is the aud claim, which may be a single string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the identity claims of a verified token
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	NotBefore         int64    `json:"nbf"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Picture           string   `json:"picture"`
+}
+
+// validate checks the registered claims of a token at time now
+func (c *Claims) validate(issuer string, clientID string, now time.Time) error {
+	if c.Subject == "" {
+		return ErrMalformedToken
+	} else if issuer != "" && c.Issuer != issuer {
+		return ErrInvalidIssuer
+	} else if clientID != "" && !c.Audience.contains(clientID) {
+		return ErrInvalidAudience
+	} else if c.Expiry == 0 || now.Add(-clockSkew).Unix() >= c.Expiry {
+		return ErrTokenExpired
+	} else if c.NotBefore != 0 && now.Add(clockSkew).Unix() < c.NotBefore {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// jwks is a json web key set
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// rsaKeys returns the RSA signing keys of the set by key id
+func (set *jwks) rsaKeys() (map[string]*rsa.PublicKey, error) {
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid modulus for key %q", jwk.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("Invalid exponent for key %q", jwk.Kid)
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// lookupKey finds the key with id kid, tokens without key id may use the
+// only key of a set
+func lookupKey(keys map[string]*rsa.PublicKey, kid string) (*rsa.PublicKey, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	} else if len(keys) == 1 && kid == "" {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
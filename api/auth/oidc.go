@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig configures an OpenID Connect relying party
+type OIDCConfig struct {
+	// Issuer is the issuer url of the provider, used for discovery
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback url registered with the provider
+	RedirectURL string
+	// Scopes requested in addition to openid
+	Scopes []string
+}
+
+// OIDCProvider logs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE
+type OIDCProvider struct {
+	Config                OIDCConfig
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	// Client is used for all requests to the provider
+	Client *http.Client
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// getJSON fetches url and decodes its json body into v
+func getJSON(client *http.Client, url string, v interface{}) error {
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Request to %v failed with status %v", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// DiscoverOIDC fetches the configuration of the provider at config.Issuer
+func DiscoverOIDC(config OIDCConfig, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	issuer := strings.TrimSuffix(config.Issuer, "/")
+	doc := discoveryDocument{}
+	if err := getJSON(client, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("Provider reported issuer %v, expected %v", doc.Issuer, config.Issuer)
+	} else if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("Provider configuration is missing endpoints")
+	}
+
+	config.Issuer = doc.Issuer
+	return &OIDCProvider{
+		Config:                config,
+		AuthorizationEndpoint: doc.AuthorizationEndpoint,
+		TokenEndpoint:         doc.TokenEndpoint,
+		JWKSURI:               doc.JWKSURI,
+		Client:                client,
+	}, nil
+}
+
+// RandomToken returns a random url safe token for states, nonces, and
+// PKCE code verifiers
+func RandomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// codeChallenge derives the S256 PKCE code challenge from verifier
+func codeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// AuthCodeURL returns the url users are sent to for logging in
+func (p *OIDCProvider) AuthCodeURL(state string, nonce string, verifier string) string {
+	scopes := append([]string{"openid"}, p.Config.Scopes...)
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Authenticate exchanges the authorization code for an id token and returns
+// its claims once verified
+func (p *OIDCProvider) Authenticate(code string, verifier string, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body := struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Token request failed with status %v %v", res.Status, body.Error)
+	} else if body.IDToken == "" {
+		return nil, errors.New("Token response is missing id token")
+	}
+
+	return p.Verify(body.IDToken, nonce)
+}
+
+// Verify checks the signature and claims of an id token issued to this
+// client for the login with nonce
+func (p *OIDCProvider) Verify(rawIDToken string, nonce string) (*Claims, error) {
+	token, err := parseJWT(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.key(token.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := token.verifyRS256(key); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := json.Unmarshal(token.payload, claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if err := claims.validate(p.Config.Issuer, p.Config.ClientID, time.Now()); err != nil {
+		return nil, err
+	} else if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrInvalidNonce
+	}
+
+	return claims, nil
+}
+
+// key returns the provider signing key with id kid, refreshing the key set
+// when kid is unknown so providers can rotate keys
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := lookupKey(p.keys, kid); ok {
+		return key, nil
+	}
+
+	keys, err := fetchJWKS(p.Client, p.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	if key, ok := lookupKey(p.keys, kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key %q", kid)
+}
+
+// fetchJWKS fetches the RSA keys of a json web key set by key id
+func fetchJWKS(client *http.Client, url string) (map[string]*rsa.PublicKey, error) {
+	set := jwks{}
+	if err := getJSON(client, url, &set); err != nil {
+		return nil, err
+	}
+	return set.rsaKeys()
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// stubProvider is a minimal OpenID Connect provider issuing id tokens for
+// a single authorization code
+type stubProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	code      string
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &stubProvider{key: key, code: "code"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 stub.server.URL,
+			"authorization_endpoint": stub.server.URL + "/authorize",
+			"token_endpoint":         stub.server.URL + "/token",
+			"jwks_uri":               stub.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != stub.code || codeChallenge(r.Form.Get("code_verifier")) != stub.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": stub.sign(t, "key-1", stub.claims)})
+	})
+	stub.server = httptest.NewServer(mux)
+	return stub
+}
+
+func (stub *stubProvider) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, stub.key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCProvider_Authenticate(t *testing.T) {
+	stub := newStubProvider(t)
+	defer stub.server.Close()
+
+	provider, err := DiscoverOIDC(OIDCConfig{
+		Issuer:      stub.server.URL,
+		ClientID:    "gocomment",
+		RedirectURL: "http://localhost:8080/auth/oidc/callback",
+		Scopes:      []string{"email"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("DiscoverOIDC() error = %v", err)
+	}
+
+	verifier, _ := RandomToken()
+	authURL, _ := url.Parse(provider.AuthCodeURL("state", "nonce", verifier))
+	query := authURL.Query()
+	stub.challenge = query.Get("code_challenge")
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("scope") != "openid email" || query.Get("nonce") != "nonce" {
+		t.Errorf("AuthCodeURL() returned unexpected url %v", authURL)
+	}
+
+	now := time.Now().Unix()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            stub.server.URL,
+			"sub":            "alice-id",
+			"aud":            "gocomment",
+			"exp":            now + 300,
+			"iat":            now,
+			"nonce":          "nonce",
+			"email":          "alice@example.com",
+			"email_verified": true,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		verifier string
+		claims   func(map[string]interface{})
+		err      error
+	}{
+		{"Authenticate", "code", verifier, func(map[string]interface{}) {}, nil},
+		{"Authenticate with audience list", "code", verifier, func(c map[string]interface{}) { c["aud"] = []string{"other", "gocomment"} }, nil},
+		{"Authenticate with wrong code verifier", "code", "wrong", func(map[string]interface{}) {}, nil},
+		{"Authenticate with wrong code", "wrong", verifier, func(map[string]interface{}) {}, nil},
+		{"Authenticate with expired token", "code", verifier, func(c map[string]interface{}) { c["exp"] = now - 300 }, ErrTokenExpired},
+		{"Authenticate with other audience", "code", verifier, func(c map[string]interface{}) { c["aud"] = "other" }, ErrInvalidAudience},
+		{"Authenticate with other issuer", "code", verifier, func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, ErrInvalidIssuer},
+		{"Authenticate with replayed token", "code", verifier, func(c map[string]interface{}) { c["nonce"] = "other" }, ErrInvalidNonce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.claims = valid()
+			tt.claims(stub.claims)
+
+			claims, err := provider.Authenticate(tt.code, tt.verifier, "nonce")
+			if tt.code != "code" || tt.verifier != verifier {
+				if err == nil {
+					t.Errorf("Authenticate() expected token request to fail")
+				}
+				return
+			}
+
+			if err != tt.err {
+				t.Fatalf("Authenticate() error = %v, wanted %v", err, tt.err)
+			} else if err == nil && (claims.Subject != "alice-id" || !claims.EmailVerified) {
+				t.Errorf("Authenticate() returned unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_Verify(t *testing.T) {
+	stub := newStubProvider(t)
+	defer stub.server.Close()
+
+	provider, err := DiscoverOIDC(OIDCConfig{Issuer: stub.server.URL, ClientID: "gocomment"}, nil)
+	if err != nil {
+		t.Fatalf("DiscoverOIDC() error = %v", err)
+	}
+
+	claims := map[string]interface{}{
+		"iss": stub.server.URL,
+		"sub": "alice-id",
+		"aud": "gocomment",
+		"exp": time.Now().Unix() + 300,
+	}
+	token := stub.sign(t, "key-1", claims)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"Verify token", token, false},
+		{"Verify token signed with unknown key", stub.sign(t, "key-2", claims), true},
+		{"Verify token with tampered signature", token[:len(token)-4] + "AAAA", true},
+		{"Verify malformed token", "not.a.token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.Verify(tt.token, ""); (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
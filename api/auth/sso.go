@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// ErrTokenLifetime is returned for single sign-on tokens valid for longer
+// than allowed
+var ErrTokenLifetime = errors.New("Token is valid for too long")
+
+// defaultSSOMaxLifetime is used when SSOVerifier.MaxLifetime is not set
+const defaultSSOMaxLifetime = 15 * time.Minute
+
+// SSOVerifier verifies short lived tokens minted by the backend of a host
+// site for its logged in readers. Tokens are signed with HS256 using Secret,
+// or with RS256 using one of Keys.
+type SSOVerifier struct {
+	Secret []byte
+	Keys   map[string]*rsa.PublicKey
+
+	// Issuer and Audience are checked against the iss and aud claims if set
+	Issuer   string
+	Audience string
+
+	// MaxLifetime limits how far in the future tokens may expire, defaults to
+	// 15 minutes
+	MaxLifetime time.Duration
+}
+
+// LoadJWKSFile reads the RSA keys of the json web key set in file
+func LoadJWKSFile(file string) (map[string]*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	set := jwks{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	return set.rsaKeys()
+}
+
+// Verify checks the signature and claims of a single sign-on token
+func (v *SSOVerifier) Verify(raw string) (*Claims, error) {
+	token, err := parseJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only algorithms with configured keys are accepted, so tokens cannot
+	// pick a weaker check
+	switch {
+	case token.header.Alg == "HS256" && len(v.Secret) > 0:
+		mac := hmac.New(sha256.New, v.Secret)
+		mac.Write([]byte(token.signingInput))
+		if !hmac.Equal(mac.Sum(nil), token.signature) {
+			return nil, ErrInvalidSignature
+		}
+	case token.header.Alg == "RS256" && len(v.Keys) > 0:
+		key, ok := lookupKey(v.Keys, token.header.Kid)
+		if !ok {
+			return nil, ErrInvalidSignature
+		}
+		if err := token.verifyRS256(key); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+
+	claims := &Claims{}
+	if err := json.Unmarshal(token.payload, claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	now := time.Now()
+	if err := claims.validate(v.Issuer, v.Audience, now); err != nil {
+		return nil, err
+	}
+
+	maxLifetime := v.MaxLifetime
+	if maxLifetime == 0 {
+		maxLifetime = defaultSSOMaxLifetime
+	}
+	if claims.Expiry > now.Add(maxLifetime+clockSkew).Unix() {
+		return nil, ErrTokenLifetime
+	}
+
+	return claims, nil
+}
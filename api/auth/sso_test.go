@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func signHS256(secret string, header map[string]string, claims map[string]interface{}) string {
+	h, _ := json.Marshal(header)
+	p, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestSSOVerifier_Verify(t *testing.T) {
+	stub := &stubProvider{}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.key = key
+
+	now := time.Now().Unix()
+	claims := func(exp int64) map[string]interface{} {
+		return map[string]interface{}{
+			"sub":     "alice-id",
+			"aud":     "gocomment",
+			"exp":     exp,
+			"name":    "Alice",
+			"email":   "alice@example.com",
+			"picture": "https://example.com/alice.png",
+		}
+	}
+	hs256 := map[string]string{"alg": "HS256"}
+
+	secretVerifier := &SSOVerifier{Secret: []byte("secret"), Audience: "gocomment"}
+	keyVerifier := &SSOVerifier{Keys: map[string]*rsa.PublicKey{"key-1": &key.PublicKey}}
+
+	tests := []struct {
+		name     string
+		verifier *SSOVerifier
+		token    string
+		err      error
+	}{
+		{"Verify HS256 token", secretVerifier, signHS256("secret", hs256, claims(now+300)), nil},
+		{"Verify HS256 token signed with other secret", secretVerifier, signHS256("other", hs256, claims(now+300)), ErrInvalidSignature},
+		{"Verify unsigned token", secretVerifier, signHS256("secret", map[string]string{"alg": "none"}, claims(now+300)), ErrUnsupportedAlg},
+		{"Verify expired token", secretVerifier, signHS256("secret", hs256, claims(now-300)), ErrTokenExpired},
+		{"Verify long lived token", secretVerifier, signHS256("secret", hs256, claims(now+86400)), ErrTokenLifetime},
+		{"Verify RS256 token", keyVerifier, stub.sign(t, "key-1", claims(now+300)), nil},
+		{"Verify RS256 token signed with unknown key", keyVerifier, stub.sign(t, "key-2", claims(now+300)), ErrInvalidSignature},
+		{"Verify RS256 token without configured keys", secretVerifier, stub.sign(t, "key-1", claims(now+300)), ErrUnsupportedAlg},
+		{"Verify HS256 token without configured secret", keyVerifier, signHS256("", hs256, claims(now+300)), ErrUnsupportedAlg},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.verifier.Verify(tt.token)
+			if err != tt.err {
+				t.Fatalf("Verify() error = %v, wanted %v", err, tt.err)
+			} else if err == nil && (claims.Name != "Alice" || claims.Picture != "https://example.com/alice.png") {
+				t.Errorf("Verify() returned unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestLoadJWKSFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			},
+			{"kty": "EC", "kid": "key-2"},
+		},
+	})
+
+	file, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(data)
+	file.Close()
+
+	keys, err := LoadJWKSFile(file.Name())
+	if err != nil {
+		t.Fatalf("LoadJWKSFile() error = %v", err)
+	}
+
+	if len(keys) != 1 || keys["key-1"].N.Cmp(key.N) != 0 || keys["key-1"].E != key.E {
+		t.Errorf("LoadJWKSFile() returned unexpected keys %v", keys)
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"text/tabwriter"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+	"github.com/spf13/cobra"
+)
+
+// auditPollInterval controls how often audit tail --follow checks for new
+// entries
+const auditPollInterval = 2 * time.Second
+
+// recordAudit records an action taken from the command line in the audit
+// log of the site store is scoped to, naming the system user as the actor
+func recordAudit(store *model.SqliteCommentStore, action string, targetType string, id uint, before interface{}, after interface{}) {
+	actor := "cli"
+	if current, err := user.Current(); err == nil {
+		actor = "cli:" + current.Username
+	}
+
+	entry := &model.AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   id,
+		Before:     model.Snapshot(before),
+		After:      model.Snapshot(after),
+	}
+	if err := (model.SqliteAuditStore{DB: store.DB, SiteID: store.SiteID}).RecordAudit(entry); err != nil {
+		log.Println("Could not record action in audit log: ", err)
+	}
+}
+
+// auditCmd groups commands used to inspect the audit log
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the moderation audit log",
+	Long: `Inspect the audit log recording every action moderators take, like
+editing, deleting or pinning comments and adding bans. Readers editing or
+deleting comments are recorded by their user account or the hash of their ip
+address.`,
+}
+
+// auditTailCmd prints the latest audit entries
+var auditTailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Prints the latest audit entries",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		lines, _ := cmd.Flags().GetInt("lines")
+		follow, _ := cmd.Flags().GetBool("follow")
+		actor, _ := cmd.Flags().GetString("actor")
+		action, _ := cmd.Flags().GetString("action")
+
+		if lines < 1 {
+			log.Fatal("Number of lines must be at least 1")
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		audit := model.SqliteAuditStore{DB: store.DB, SiteID: store.SiteID}
+		query := model.AuditQuery{Actor: actor, Action: action, Limit: lines}
+
+		entries, err := audit.GetAuditEntries(query)
+
+		if err != nil {
+			log.Fatal("Could not read audit log: ", err)
+		}
+
+		// Entries are returned newest first, but printed oldest first
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tACTOR\tACTION\tTARGET\tREASON")
+		printAuditEntries(w, entries)
+
+		for follow {
+			if len(entries) > 0 {
+				query.AfterID = entries[len(entries)-1].ID
+			}
+
+			time.Sleep(auditPollInterval)
+
+			next, err := audit.GetAuditEntries(query)
+			if err != nil {
+				log.Fatal("Could not read audit log: ", err)
+			} else if len(next) > 0 {
+				entries = next
+				printAuditEntries(w, entries)
+			}
+		}
+	},
+}
+
+func printAuditEntries(w *tabwriter.Writer, entries []*model.AuditEntry) {
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%d\t%v\t%v\t%v\t%v %d\t%v\n", entry.ID, entry.CreatedAt.Format(time.RFC3339),
+			entry.Actor, entry.Action, entry.TargetType, entry.TargetID, entry.Reason)
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditTailCmd)
+
+	auditCmd.PersistentFlags().String("site", "", "name of the site to inspect, defaults to the default site")
+	auditTailCmd.Flags().IntP("lines", "n", 20, "number of entries to print")
+	auditTailCmd.Flags().BoolP("follow", "f", false, "keep printing new entries as they are recorded")
+	auditTailCmd.Flags().String("actor", "", "only print entries of this actor, e.g. admin, site:example, or user:7")
+	auditTailCmd.Flags().String("action", "", "only print entries of this action, e.g. delete or ban")
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+	"github.com/spf13/cobra"
+)
+
+// banCmd groups commands used to manage bans
+var banCmd = &cobra.Command{
+	Use:   "ban",
+	Short: "Manage bans of posters",
+	Long: `Manage the bans stopping posters from commenting and reacting on a site.
+
+Bans match an exact email address, every email address of a domain, an ip
+address or CIDR network, or usernames matching a pattern where * matches any
+characters. Shadow bans accept comments but only show them to their poster.`,
+}
+
+// banAddCmd adds a ban
+var banAddCmd = &cobra.Command{
+	Use:   "add <email|domain|ip|username> <value>",
+	Short: "Adds a ban",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("requires the kind and value of the ban")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		reason, _ := cmd.Flags().GetString("reason")
+		expires, _ := cmd.Flags().GetDuration("expires")
+		shadow, _ := cmd.Flags().GetBool("shadow")
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		ban := &model.Ban{
+			Kind:      args[0],
+			Value:     args[1],
+			Reason:    reason,
+			Shadow:    shadow,
+			CreatedBy: "cli",
+		}
+		if expires > 0 {
+			expiresAt := time.Now().Add(expires)
+			ban.ExpiresAt = &expiresAt
+		}
+
+		ban, err = model.SqliteBanStore{DB: store.DB, SiteID: store.SiteID}.CreateBan(ban)
+
+		if err != nil {
+			log.Fatal("Could not add ban: ", err)
+		}
+
+		recordAudit(store, model.AuditBan, model.AuditTargetBan, ban.ID, nil, ban)
+
+		fmt.Printf("Added ban %d of %v %v\n", ban.ID, ban.Kind, ban.Value)
+	},
+}
+
+// banListCmd lists bans
+var banListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists bans",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		bans, err := model.SqliteBanStore{DB: store.DB, SiteID: store.SiteID}.GetBans()
+
+		if err != nil {
+			log.Fatal("Could not list bans: ", err)
+		}
+
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tKIND\tVALUE\tSHADOW\tEXPIRES\tREASON")
+		for _, ban := range bans {
+			expires := "never"
+			if !ban.Active(now) {
+				expires = "expired"
+			} else if ban.ExpiresAt != nil {
+				expires = ban.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%v\t%v\t%v\t%v\t%v\n", ban.ID, ban.Kind, ban.Value, ban.Shadow, expires, ban.Reason)
+		}
+		w.Flush()
+	},
+}
+
+// banRemoveCmd removes a ban
+var banRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Removes a ban",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires the id of the ban to remove")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.ParseUint(args[0], 10, 32)
+
+		if err != nil {
+			log.Fatalf("Invalid ban id %v", args[0])
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		ban, err := (model.SqliteBanStore{DB: store.DB, SiteID: store.SiteID}).DeleteBan(uint(id))
+		if err != nil {
+			log.Fatalf("Could not remove ban %v: %v", id, err)
+		}
+
+		recordAudit(store, model.AuditUnban, model.AuditTargetBan, uint(id), ban, nil)
+
+		fmt.Printf("Removed ban %d\n", id)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(banCmd)
+	banCmd.AddCommand(banAddCmd, banListCmd, banRemoveCmd)
+
+	banCmd.PersistentFlags().String("site", "", "name of the site the bans apply to, defaults to the default site")
+	banAddCmd.Flags().String("reason", "", "reason for the ban, shown to moderators")
+	banAddCmd.Flags().Duration("expires", 0, "lift the ban after this duration, e.g. 720h, never if 0")
+	banAddCmd.Flags().Bool("shadow", false, "accept comments of the poster but only show them to the poster")
+}
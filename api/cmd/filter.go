@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/snorremd/gocomment/api/model"
+	"github.com/spf13/cobra"
+)
+
+// filterCmd groups commands used to manage word filters
+var filterCmd = &cobra.Command{
+	Use:   "filter",
+	Short: "Manage the word filter of a site",
+	Long: `Manage the word filter applied to comments posted or edited on a site.
+
+Rules match whole words or phrases regardless of case, or any word starting or
+ending with the pattern when it ends or starts with *. Matching comments are
+rejected, have the matching words masked, or are held for moderation.`,
+}
+
+// filterAddCmd adds a filter rule
+var filterAddCmd = &cobra.Command{
+	Use:   "add <reject|mask|hold> <pattern>",
+	Short: "Adds a filter rule",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("requires the action and pattern of the rule")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		rule, err := model.SqliteFilterStore{DB: store.DB, SiteID: store.SiteID}.CreateFilterRule(&model.FilterRule{
+			Action:  args[0],
+			Pattern: args[1],
+		})
+
+		if err != nil {
+			log.Fatal("Could not add filter rule: ", err)
+		}
+
+		recordAudit(store, model.AuditAddFilter, model.AuditTargetFilter, rule.ID, nil, rule)
+
+		fmt.Printf("Added filter rule %d to %v %v\n", rule.ID, rule.Action, rule.Pattern)
+	},
+}
+
+// filterListCmd lists filter rules
+var filterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists filter rules",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		rules, err := model.SqliteFilterStore{DB: store.DB, SiteID: store.SiteID}.GetFilterRules()
+
+		if err != nil {
+			log.Fatal("Could not list filter rules: ", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tACTION\tPATTERN")
+		for _, rule := range rules {
+			fmt.Fprintf(w, "%d\t%v\t%v\n", rule.ID, rule.Action, rule.Pattern)
+		}
+		w.Flush()
+	},
+}
+
+// filterRemoveCmd removes a filter rule
+var filterRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Removes a filter rule",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires the id of the rule to remove")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.ParseUint(args[0], 10, 32)
+
+		if err != nil {
+			log.Fatalf("Invalid filter rule id %v", args[0])
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := (model.SqliteFilterStore{DB: store.DB, SiteID: store.SiteID}).DeleteFilterRule(uint(id)); err != nil {
+			log.Fatalf("Could not remove filter rule %v: %v", id, err)
+		}
+
+		recordAudit(store, model.AuditRemoveFilter, model.AuditTargetFilter, uint(id), nil, nil)
+
+		fmt.Printf("Removed filter rule %d\n", id)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(filterCmd)
+	filterCmd.AddCommand(filterAddCmd, filterListCmd, filterRemoveCmd)
+
+	filterCmd.PersistentFlags().String("site", "", "name of the site the rules apply to, defaults to the default site")
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/snorremd/gocomment/api/model"
+	"github.com/spf13/cobra"
+)
+
+// gdprCmd groups commands answering data subject requests
+var gdprCmd = &cobra.Command{
+	Use:   "gdpr",
+	Short: "Export or erase the data of a person",
+	Long: `Answer data subject requests by exporting or erasing all data stored about a
+person, found by their email address.
+
+Comments of all sites posted with the email address, or by the user registered
+with it, are included. Reactions are stored by the user account or the hash of
+the ip address of the voter. Reactions of the user are included, others only
+for the voters given with --voter, like ip:<hash>.`,
+}
+
+// gdprExportCmd prints the data stored about a person as json
+var gdprExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Prints all data stored about an email address as json",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		email, _ := cmd.Flags().GetString("email")
+		voters, _ := cmd.Flags().GetStringSlice("voter")
+
+		if email == "" {
+			log.Fatal("Requires the --email address of the person")
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		export, err := model.SqliteGDPRStore{DB: store.DB}.ExportData(email, voters)
+		if err != nil {
+			log.Fatal("Could not export data: ", err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(export); err != nil {
+			log.Fatal("Could not encode data: ", err)
+		}
+	},
+}
+
+// gdprEraseCmd erases the data stored about a person
+var gdprEraseCmd = &cobra.Command{
+	Use:   "erase",
+	Short: "Erases all data stored about an email address",
+	Long: `Erases all data stored about an email address, including their user account.
+
+Comments without replies are removed, while comments with replies are stripped
+of their content and author and kept as tombstones so the discussion around
+them stays intact.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		email, _ := cmd.Flags().GetString("email")
+		voters, _ := cmd.Flags().GetStringSlice("voter")
+
+		if email == "" {
+			log.Fatal("Requires the --email address of the person")
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		erased, err := model.SqliteGDPRStore{DB: store.DB}.EraseData(email, voters)
+		if err != nil {
+			log.Fatal("Could not erase data: ", err)
+		}
+
+		user := "no user account"
+		if erased.User != nil {
+			user = "user " + erased.User.Username
+		}
+		fmt.Printf("Erased %d comments, %d reactions and %v of %v\n", len(erased.Comments), len(erased.Reactions), user, erased.Email)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gdprCmd)
+	gdprCmd.AddCommand(gdprExportCmd, gdprEraseCmd)
+
+	gdprCmd.PersistentFlags().String("email", "", "email address of the person")
+	gdprCmd.PersistentFlags().StringSlice("voter", []string{}, "voters of the person, like ip:<hash>, whose reactions are included")
+}
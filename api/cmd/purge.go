@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// purgeCmd permanently removes deleted comments
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently removes comments deleted some time ago",
+	Long: `Permanently removes comments of all sites that were deleted longer ago than
+the --older-than duration, along with their reactions.
+
+Deleted comments are kept in the database, and shown as tombstones while they
+have replies. Run purge regularly, e.g. from cron, to enforce a retention
+period for deleted comments. Deleted comments that still have replies are
+stripped of their content and author instead of being removed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+
+		if olderThan <= 0 {
+			log.Fatal("Purging requires a positive --older-than duration, e.g. 720h")
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		purged, err := store.PurgeDeleted(time.Now().Add(-olderThan))
+		if err != nil {
+			log.Fatal("Could not purge comments: ", err)
+		}
+
+		fmt.Printf("Purged %d comments deleted more than %v ago\n", purged, olderThan)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+
+	purgeCmd.Flags().Duration("older-than", 0, "purge comments deleted longer ago than this, e.g. 720h")
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/snorremd/gocomment/api/router"
+	"github.com/spf13/cobra"
+)
+
+// renderStateFile is kept in the output directory to remember when threads
+// were last rendered
+const renderStateFile = ".gocomment-render.json"
+
+// renderState records the last run of render, incremental runs render the
+// threads changed since if the format, sort, and site match
+type renderState struct {
+	RenderedAt time.Time `json:"renderedAt"`
+	Format     string    `json:"format"`
+	Sort       string    `json:"sort"`
+	Site       string    `json:"site"`
+}
+
+// threadFile returns the path of the file the thread of threadURL is rendered
+// to relative to the output directory, like example.com/docs/intro.html.
+// Paths ending in a slash are rendered to index files, and urls with a query
+// get a hash of the query appended.
+func threadFile(threadURL string, format string) string {
+	u, err := url.Parse(threadURL)
+	if err != nil {
+		return "_" + shortHash(threadURL) + "." + format
+	}
+
+	host := strings.ReplaceAll(u.Host, ":", "_")
+	if strings.Trim(host, ".") == "" {
+		host = "_"
+	}
+
+	file := path.Clean("/" + u.Path)
+	if file == "/" || strings.HasSuffix(u.Path, "/") {
+		file = path.Join(file, "index")
+	}
+	if u.RawQuery != "" {
+		file += "_" + shortHash(u.RawQuery)
+	}
+
+	return filepath.Join(host, filepath.FromSlash(file)) + "." + format
+}
+
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// writeFile replaces the file at name with content, so readers of the output
+// directory never see half written files
+func writeFile(name string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".gocomment-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	} else if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// renderCmd renders threads to files for static sites
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Renders the comments of every thread to files",
+	Long: `Renders the approved comments of every thread to one file per thread, for
+static sites baking comments into their pages at build time.
+
+Threads are written as html fragments or json documents, with replies nested
+under their parent. Files are named after the url of the thread, e.g. the
+thread of https://example.com/docs/intro is written to
+example.com/docs/intro.html in the output directory. Comments held for
+moderation are left out, as are the email addresses of posters.
+
+Threads merged into another thread are rendered with the comments of the
+thread they were merged into, as their urls show those comments.
+
+With --incremental only threads with comments posted, changed, deleted or
+moved away since the last run are rendered again. The time of the last run is
+kept in ` + renderStateFile + ` in the output directory.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		out, _ := cmd.Flags().GetString("out")
+		format, _ := cmd.Flags().GetString("format")
+		sort, _ := cmd.Flags().GetString("sort")
+		incremental, _ := cmd.Flags().GetBool("incremental")
+		site, _ := cmd.Flags().GetString("site")
+
+		if out == "" {
+			log.Fatal("Output directory must be set with --out")
+		} else if format != router.StaticHTML && format != router.StaticJSON {
+			log.Fatalf("Unknown format %v, must be html or json", format)
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		stateFile := filepath.Join(out, renderStateFile)
+		state := renderState{}
+		if incremental {
+			if content, err := ioutil.ReadFile(stateFile); err == nil {
+				if err := json.Unmarshal(content, &state); err != nil {
+					log.Fatalf("Could not read %v: %v", stateFile, err)
+				}
+			} else if !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+		}
+
+		since := time.Time{}
+		if state.Format == format && state.Sort == sort && state.Site == site {
+			since = state.RenderedAt
+		} else if incremental && !state.RenderedAt.IsZero() {
+			fmt.Println("Format, sort or site changed since the last run, rendering every thread")
+		}
+
+		// Comments changed while rendering are rendered again by the next run
+		renderedAt := time.Now()
+
+		threads, err := store.GetChangedThreads(since)
+		if err != nil {
+			log.Fatal("Could not get threads: ", err)
+		}
+
+		for _, thread := range threads {
+			comments, err := store.GetComments(thread.URL, sort)
+			if err != nil {
+				log.Fatalf("Could not get comments of %v: %v", thread.URL, err)
+			}
+
+			content := &bytes.Buffer{}
+			if err := router.WriteStaticThread(content, thread.URL, comments, format); err != nil {
+				log.Fatalf("Could not render %v: %v", thread.URL, err)
+			}
+
+			file := filepath.Join(out, threadFile(thread.URL, format))
+			if err := writeFile(file, content.Bytes()); err != nil {
+				log.Fatalf("Could not write %v: %v", file, err)
+			}
+		}
+
+		state = renderState{RenderedAt: renderedAt, Format: format, Sort: sort, Site: site}
+		content, _ := json.Marshal(&state)
+		if err := writeFile(stateFile, content); err != nil {
+			log.Fatalf("Could not write %v: %v", stateFile, err)
+		}
+
+		fmt.Printf("Rendered %d threads to %v\n", len(threads), out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+
+	renderCmd.Flags().String("out", "", "directory to write the rendered threads to")
+	renderCmd.Flags().String("format", router.StaticHTML, "format of the rendered threads, html or json")
+	renderCmd.Flags().String("sort", "", "sort order of the comments, oldest, newest or top, defaults to oldest")
+	renderCmd.Flags().Bool("incremental", false, "only render threads changed since the last run")
+	renderCmd.Flags().String("site", "", "name of the site to render the threads of, defaults to the default site")
+}
@@ -5,8 +5,12 @@ import (
 	"os"
 
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/snorremd/gocomment/api/db"
+	"github.com/snorremd/gocomment/api/model"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
 var cfgFile string
@@ -43,6 +47,15 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gocomment.yaml)")
 	rootCmd.PersistentFlags().String("db", "", "path to database file")
 	viper.SetDefault("db", "./comments.db")
+	rootCmd.PersistentFlags().Uint("max-depth", 0, "maximum nesting depth of replies, deeper replies are flattened, 0 allows any depth")
+	rootCmd.PersistentFlags().Uint("flag-threshold", 0, "number of reader flags holding a comment for moderation, defaults to 5, 0 disables")
+	viper.SetDefault("flag-threshold", 5)
+
+	defaults := model.DefaultURLNormalizer()
+	viper.SetDefault("normalize.keep-scheme", defaults.KeepScheme)
+	viper.SetDefault("normalize.strip-www", defaults.StripWWW)
+	viper.SetDefault("normalize.strip-trailing-slash", defaults.StripTrailingSlash)
+	viper.SetDefault("normalize.strip-params", defaults.StripParams)
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -76,3 +89,47 @@ func initConfig() {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// urlNormalizer returns the url normalization rules from the configuration
+func urlNormalizer() *model.URLNormalizer {
+	return &model.URLNormalizer{
+		KeepScheme:         viper.GetBool("normalize.keep-scheme"),
+		StripWWW:           viper.GetBool("normalize.strip-www"),
+		StripTrailingSlash: viper.GetBool("normalize.strip-trailing-slash"),
+		HostAliases:        viper.GetStringMapString("normalize.host-aliases"),
+		StripParams:        viper.GetStringSlice("normalize.strip-params"),
+	}
+}
+
+// openStore connects to and migrates the configured database and returns a
+// comment store using it. The caller is responsible for closing store.DB.
+func openStore() (*model.SqliteCommentStore, error) {
+	db, err := db.DB(viper.GetString("db"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to database: %v", err)
+	}
+
+	if err := model.Migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not migrate database: %v", err)
+	}
+
+	store := &model.SqliteCommentStore{
+		DB:            db,
+		Normalizer:    urlNormalizer(),
+		MaxDepth:      viper.GetUint("max-depth"),
+		FlagThreshold: viper.GetUint("flag-threshold"),
+	}
+
+	if _, err := store.AssignThreads(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not assign comments to threads: %v", err)
+	}
+
+	if _, err := store.AssignPaths(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not assign paths to replies: %v", err)
+	}
+
+	return store, nil
+}
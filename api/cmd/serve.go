@@ -2,29 +2,210 @@ package cmd
 
 import (
 	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"time"
 
-	"github.com/gorilla/handlers"
-	"github.com/snorremd/gocomment/api/db"
+	"github.com/fsnotify/fsnotify"
+	"github.com/snorremd/gocomment/api/auth"
 	"github.com/snorremd/gocomment/api/model"
 	"github.com/snorremd/gocomment/api/router"
+	"github.com/snorremd/gocomment/resources"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
+// corsPolicy returns the cors policy from the configuration
+func corsPolicy() *router.CORSPolicy {
+	return &router.CORSPolicy{
+		AllowedOrigins:   viper.GetStringSlice("cors.allowed-origins"),
+		AllowedMethods:   viper.GetStringSlice("cors.allowed-methods"),
+		AllowedHeaders:   viper.GetStringSlice("cors.allowed-headers"),
+		MaxAge:           viper.GetDuration("cors.max-age"),
+		AllowCredentials: viper.GetBool("cors.allow-credentials"),
+	}
+}
+
+// oidcProvider discovers the configured OpenID Connect provider, returning
+// nil if none is configured
+func oidcProvider() (*auth.OIDCProvider, error) {
+	if viper.GetString("oidc.issuer") == "" {
+		return nil, nil
+	}
+
+	provider, err := auth.DiscoverOIDC(auth.OIDCConfig{
+		Issuer:       viper.GetString("oidc.issuer"),
+		ClientID:     viper.GetString("oidc.client-id"),
+		ClientSecret: viper.GetString("oidc.client-secret"),
+		RedirectURL:  viper.GetString("oidc.redirect-url"),
+		Scopes:       viper.GetStringSlice("oidc.scopes"),
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not discover oidc provider: %v", err)
+	}
+	return provider, nil
+}
+
+// ssoVerifier returns the verifier of single sign-on tokens for the default
+// site from the configuration, nil if neither a secret nor a key set is
+// configured. Other sites verify tokens with their own secret, see site
+// sso-secret.
+func ssoVerifier() (*auth.SSOVerifier, error) {
+	secret := viper.GetString("sso.secret")
+	jwksFile := viper.GetString("sso.jwks-file")
+	if secret == "" && jwksFile == "" {
+		return nil, nil
+	}
+
+	verifier := &auth.SSOVerifier{
+		Secret:      []byte(secret),
+		Issuer:      viper.GetString("sso.issuer"),
+		Audience:    viper.GetString("sso.audience"),
+		MaxLifetime: viper.GetDuration("sso.max-lifetime"),
+	}
+
+	if jwksFile != "" {
+		keys, err := auth.LoadJWKSFile(jwksFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load sso key set: %v", err)
+		}
+		verifier.Keys = keys
+	}
+	return verifier, nil
+}
+
+// clientPolicy returns how client ip addresses and user agents are stored
+// from the configuration. Without a configured salt a random one is used,
+// so hashes only match comments posted since the server started.
+func clientPolicy() (model.ClientPolicy, error) {
+	policy := model.ClientPolicy{
+		IPMode:    viper.GetString("privacy.ip-mode"),
+		Salt:      viper.GetString("privacy.ip-salt"),
+		Retention: viper.GetDuration("privacy.ip-retention"),
+	}
+
+	if err := model.ValidateIPMode(policy.IPMode); err != nil {
+		return policy, err
+	} else if policy.Retention <= 0 {
+		policy.Retention = model.DefaultClientRetention
+	}
+
+	if policy.Salt == "" {
+		salt, err := model.NewAPIKey()
+		if err != nil {
+			return policy, err
+		}
+		policy.Salt = salt
+		log.Println("No privacy.ip-salt configured, ip hashes will change when the server restarts")
+	}
+	return policy, nil
+}
+
+// dashboardSecret returns the secret signing moderator sessions of the
+// dashboard, or nil if the dashboard is disabled
+func dashboardSecret() ([]byte, error) {
+	if !viper.GetBool("dashboard.enabled") {
+		return nil, nil
+	}
+
+	secret := viper.GetString("dashboard.secret")
+	if secret == "" {
+		key, err := model.NewAPIKey()
+		if err != nil {
+			return nil, err
+		}
+		secret = key
+		log.Println("No dashboard.secret configured, moderators are logged out of the dashboard when the server restarts")
+	}
+	return []byte(secret), nil
+}
+
+// widget collects the compiled comment widget embedded in the binary,
+// returning nil if the client was not built
+func widget() *router.Widget {
+	public, err := fs.Sub(resources.Public, "public")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	widget, err := router.NewWidget(public)
+	if err != nil {
+		log.Println("Not serving the comment widget: ", err)
+		return nil
+	}
+	return widget
+}
+
+// trustedProxies parses the networks of the configured trusted proxies,
+// single addresses are accepted as well
+func trustedProxies() ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, value := range viper.GetStringSlice("trusted-proxies") {
+		if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+			value += "/32"
+		} else if ip != nil {
+			value += "/128"
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %v", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// expireClientInfo periodically clears client ip addresses and user agents
+// older than the retention period
+func expireClientInfo(store *model.SqliteCommentStore, retention time.Duration) {
+	for {
+		if expired, err := store.ExpireClientInfo(time.Now().Add(-retention)); err != nil {
+			log.Println("Could not expire client info:", err)
+		} else if expired > 0 {
+			log.Printf("Expired client info of %d comments", expired)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// logVerifier logs email verification and data request tokens, as no
+// mailer is configured
+type logVerifier struct{}
+
+func (logVerifier) SendVerification(user *model.User) error {
+	log.Printf("Email verification token for %v <%v>: %v", user.Username, user.Email, user.VerifyToken)
+	return nil
+}
+
+func (logVerifier) SendDataRequest(request *model.DataRequest, token string) error {
+	log.Printf("Data %v request token for <%v>: %v", request.Action, request.Email, token)
+	return nil
+}
+
 // server starts a go http server and returns any error encountered
-func server(hostAddress string, router *router.Router) error {
-	muxRouter := router.Router()
+func server(hostAddress string, commentRouter *router.Router) error {
+	muxRouter := commentRouter.Router()
+
+	cors, err := router.NewCORS(corsPolicy(), commentRouter.Sites)
+	if err != nil {
+		return fmt.Errorf("Invalid cors configuration: %v", err)
+	}
 
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type"})
-	originsOk := handlers.AllowedOrigins([]string{"*"})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
-	credentialsOk := handlers.AllowCredentials()
+	if viper.ConfigFileUsed() != "" {
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			if err := cors.Update(corsPolicy()); err != nil {
+				log.Println("Ignoring invalid cors configuration:", err)
+				return
+			}
+			log.Println("Reloaded cors configuration from", e.Name)
+		})
+		viper.WatchConfig()
+	}
 
-	return http.ListenAndServe(hostAddress, handlers.CORS(originsOk, headersOk, methodsOk, credentialsOk)(muxRouter))
+	return http.ListenAndServe(hostAddress, cors.Handler(muxRouter))
 }
 
 // serveCmd represents the serve command which starts the api server
@@ -39,24 +220,67 @@ If the specified database does not exist it will automatically create it.`,
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		db, err := db.DB(viper.GetString("db"))
+		store, err := openStore()
 
 		if err != nil {
-			log.Fatal("Could not connect to database", err)
+			log.Fatal(err)
 		}
 
-		defer db.Close()
+		defer store.DB.Close()
 
-		if err := model.Migrate(db); err != nil {
-			log.Fatal("Could not migrate database", err)
+		router := &router.Router{
+			Commenter:       store,
+			Sites:           model.SqliteSiteStore{DB: store.DB},
+			AdminKey:        viper.GetString("admin-key"),
+			Reactions:       viper.GetStringSlice("reactions"),
+			Users:           model.SqliteUserStore{DB: store.DB},
+			Verifier:        logVerifier{},
+			Bans:            model.SqliteBanStore{DB: store.DB},
+			Filters:         model.SqliteFilterStore{DB: store.DB},
+			Audit:           model.SqliteAuditStore{DB: store.DB},
+			GDPR:            model.SqliteGDPRStore{DB: store.DB},
+			DataRequests:    logVerifier{},
+			SessionTTL:      viper.GetDuration("session-ttl"),
+			InsecureCookies: viper.GetBool("insecure-cookies"),
+			// Widgets on other sites only send the session if the cors
+			// policy allows credentials
+			CrossSiteCookies: viper.GetBool("cors.allow-credentials"),
+			CountCacheTTL:    viper.GetDuration("count-cache-ttl"),
+			Widget:           widget(),
 		}
 
-		router := &router.Router{
-			Commenter: model.SqliteCommentStore{
-				DB: db,
-			},
+		if provider, err := oidcProvider(); err != nil {
+			log.Fatal(err)
+		} else if provider != nil {
+			router.OIDC = provider
 		}
 
+		if verifier, err := ssoVerifier(); err != nil {
+			log.Fatal(err)
+		} else {
+			router.SSO = verifier
+		}
+
+		if policy, err := clientPolicy(); err != nil {
+			log.Fatal(err)
+		} else {
+			router.Clients = policy
+		}
+
+		if secret, err := dashboardSecret(); err != nil {
+			log.Fatal(err)
+		} else {
+			router.DashboardSecret = secret
+		}
+
+		if proxies, err := trustedProxies(); err != nil {
+			log.Fatal(err)
+		} else {
+			router.TrustedProxies = proxies
+		}
+
+		go expireClientInfo(store, router.Clients.Retention)
+
 		listen := fmt.Sprintf("%s:%d", viper.GetString("host"), viper.GetInt("port"))
 
 		if err := server(listen, router); err != nil {
@@ -74,7 +298,32 @@ func init() {
 	serveCmd.PersistentFlags().String("host", "", "host to listen to, defaults to localhost")
 	serveCmd.PersistentFlags().Uint("port", 0, "port to bind to, defaults to 8080")
 	viper.SetDefault("host", "localhost")
+	serveCmd.PersistentFlags().Duration("count-cache-ttl", 0, "how long comment counts are cached, defaults to 1m")
+	serveCmd.PersistentFlags().String("admin-key", "", "bearer token authenticating moderators on admin routes")
+	serveCmd.PersistentFlags().Duration("session-ttl", 0, "how long users stay logged in, defaults to 720h")
+	serveCmd.PersistentFlags().Bool("insecure-cookies", false, "allow session cookies over plain http during development")
+	viper.BindPFlags(serveCmd.PersistentFlags())
 	viper.SetDefault("port", "8080")
+	viper.SetDefault("count-cache-ttl", "1m")
+	viper.SetDefault("reactions", model.DefaultReactions)
+	viper.SetDefault("session-ttl", "720h")
+
+	viper.SetDefault("oidc.scopes", []string{"email", "profile"})
+	viper.SetDefault("sso.max-lifetime", "15m")
+
+	viper.SetDefault("privacy.ip-mode", model.IPModeHash)
+	viper.SetDefault("privacy.ip-retention", model.DefaultClientRetention)
+	viper.SetDefault("trusted-proxies", []string{})
+
+	viper.SetDefault("dashboard.enabled", true)
+
+	// Cors settings are reloaded when the config file changes
+	cors := router.DefaultCORSPolicy()
+	viper.SetDefault("cors.allowed-origins", cors.AllowedOrigins)
+	viper.SetDefault("cors.allowed-methods", cors.AllowedMethods)
+	viper.SetDefault("cors.allowed-headers", cors.AllowedHeaders)
+	viper.SetDefault("cors.max-age", cors.MaxAge)
+	viper.SetDefault("cors.allow-credentials", cors.AllowCredentials)
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
 	// serveCmd.PersistentFlags().String("foo", "", "A help for foo")
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/snorremd/gocomment/api/model"
+	"github.com/spf13/cobra"
+)
+
+// siteCmd groups commands used to manage sites
+var siteCmd = &cobra.Command{
+	Use:   "site",
+	Short: "Manage sites hosting comments",
+	Long: `Manage the sites hosting comments on this gocomment instance.
+
+Requests are assigned to a site by their Origin header, or by the site api key
+in the X-Site-Key header. Until the first site is added every request uses the
+default site.`,
+}
+
+// siteAddCmd adds a site
+var siteAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Adds a site and prints its api key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		origins, _ := cmd.Flags().GetStringSlice("origin")
+		moderation, _ := cmd.Flags().GetString("moderation")
+
+		if moderation != model.ModerationNone && moderation != model.ModerationPre {
+			log.Fatalf("Unknown moderation setting %v, use %v or %v", moderation, model.ModerationNone, model.ModerationPre)
+		}
+
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, err := model.SqliteSiteStore{DB: store.DB}.CreateSite(&model.Site{
+			Name:       args[0],
+			Origins:    strings.Join(origins, ","),
+			Moderation: moderation,
+		})
+
+		if err != nil {
+			log.Fatal("Could not add site: ", err)
+		}
+
+		fmt.Printf("Added site %v with api key %v\n", site.Name, site.APIKey)
+	},
+}
+
+// siteListCmd lists sites
+var siteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists sites",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		sites, err := model.SqliteSiteStore{DB: store.DB}.GetSites()
+
+		if err != nil {
+			log.Fatal("Could not list sites: ", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tMODERATION\tORIGINS")
+		for _, site := range sites {
+			fmt.Fprintf(w, "%d\t%v\t%v\t%v\n", site.ID, site.Name, site.Moderation, site.Origins)
+		}
+		w.Flush()
+	},
+}
+
+// siteRemoveCmd removes a site
+var siteRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Removes a site",
+	Long: `Removes a site. Comments posted to the site are kept in the database but
+can no longer be reached through the api.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires the name of the site to remove")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		sites := model.SqliteSiteStore{DB: store.DB}
+
+		site, err := sites.GetSite(args[0])
+		if err != nil {
+			log.Fatalf("Could not find site %v: %v", args[0], err)
+		}
+
+		if _, err := sites.DeleteSite(site); err != nil {
+			log.Fatal("Could not remove site: ", err)
+		}
+
+		fmt.Printf("Removed site %v\n", site.Name)
+	},
+}
+
+// siteSSOSecretCmd generates the single sign-on secret of a site
+var siteSSOSecretCmd = &cobra.Command{
+	Use:   "sso-secret <name>",
+	Short: "Generates a new single sign-on secret for a site and prints it",
+	Long: `Generates a new single sign-on secret for a site and prints it. The backend
+of the site signs single sign-on tokens for its readers with the secret, tokens
+signed with the previous secret are no longer accepted.
+
+Every site has its own secret, sites without one do not accept single sign-on
+tokens. The sso.secret and sso.jwks-file settings only apply to the default
+site.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		sites := model.SqliteSiteStore{DB: store.DB}
+
+		site, err := sites.GetSite(args[0])
+		if err != nil {
+			log.Fatalf("Could not find site %v: %v", args[0], err)
+		}
+
+		if site, err = sites.RotateSSOSecret(site); err != nil {
+			log.Fatal("Could not generate single sign-on secret: ", err)
+		}
+
+		fmt.Printf("Single sign-on secret of site %v is %v\n", site.Name, site.SSOSecret)
+	},
+}
+
+// scopeToSite restricts store to the site with the given name, leaving it on
+// the default site if name is empty
+func scopeToSite(store *model.SqliteCommentStore, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	site, err := model.SqliteSiteStore{DB: store.DB}.GetSite(name)
+	if err != nil {
+		return fmt.Errorf("Could not find site %v: %v", name, err)
+	}
+
+	store.SiteID = site.ID
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(siteCmd)
+	siteCmd.AddCommand(siteAddCmd, siteListCmd, siteRemoveCmd, siteSSOSecretCmd)
+
+	siteAddCmd.Flags().StringSlice("origin", []string{}, "origin allowed to use the site, e.g. https://example.com")
+	siteAddCmd.Flags().String("moderation", model.ModerationNone, "moderation of new comments, none or pre")
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+	"github.com/spf13/cobra"
+)
+
+// threadsCmd groups commands used to manage comment threads
+var threadsCmd = &cobra.Command{
+	Use:   "threads",
+	Short: "Manage comment threads",
+	Long: `Manage the threads comments are grouped into.
+
+Comments are grouped into threads by normalizing the url they were posted to.
+The normalization rules are configured with the normalize.* settings.`,
+}
+
+// threadsMergeCmd merges comment threads split across several urls
+var threadsMergeCmd = &cobra.Command{
+	Use:   "merge <url> <other-url>...",
+	Short: "Merges the threads of other urls into the thread of url",
+	Long: `Merges the threads of one or more urls into the thread of the first url.
+
+Use this to fix discussions split across several urls, e.g. by an article
+changing address or normalization rules changing after comments were posted.
+Comments on the merged urls, and any url resolving to them, are moved to the
+thread of the first url.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return errors.New("requires a url and at least one url to merge into it")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		// Urls resolving to the same thread, e.g. urls merged before, are
+		// merged once
+		threads := make([]*model.Thread, 0, len(args))
+		seen := map[uint]bool{}
+		for _, url := range args {
+			thread, err := store.GetThread(url)
+			if err != nil {
+				log.Fatalf("Could not find thread for url %v: %v", url, err)
+			} else if !seen[thread.ID] {
+				seen[thread.ID] = true
+				threads = append(threads, thread)
+			}
+		}
+
+		if err := store.MergeThreads(threads[0], threads[1:]...); err != nil {
+			log.Fatal("Could not merge threads: ", err)
+		}
+
+		fmt.Printf("Merged %d threads into %v\n", len(threads)-1, threads[0].Key)
+	},
+}
+
+// threadsShowCmd prints the settings of a thread
+var threadsShowCmd = &cobra.Command{
+	Use:   "show <url>",
+	Short: "Shows the settings of the thread of url",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		thread, err := store.GetThread(args[0])
+		if err != nil {
+			log.Fatalf("Could not find thread for url %v: %v", args[0], err)
+		}
+
+		printThread(thread)
+	},
+}
+
+// threadsSetCmd changes the settings of a thread
+var threadsSetCmd = &cobra.Command{
+	Use:   "set <url>",
+	Short: "Changes the settings of the thread of url",
+	Long: `Changes the settings of the thread of url, creating the thread if no
+comments have been posted to it yet. Settings without a flag are kept.
+
+States:
+  open       accepts new comments and edits
+  closed     accepts edits to existing comments only
+  read-only  accepts neither new comments nor edits`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer store.DB.Close()
+
+		site, _ := cmd.Flags().GetString("site")
+		if err := scopeToSite(store, site); err != nil {
+			log.Fatal(err)
+		}
+
+		thread, err := store.GetOrCreateThread(args[0])
+		if err != nil {
+			log.Fatalf("Could not get thread for url %v: %v", args[0], err)
+		}
+
+		if cmd.Flags().Changed("state") {
+			thread.State, _ = cmd.Flags().GetString("state")
+		}
+		if cmd.Flags().Changed("auto-close-days") {
+			thread.AutoCloseDays, _ = cmd.Flags().GetInt("auto-close-days")
+		}
+		if cmd.Flags().Changed("require-moderation") {
+			thread.RequireModeration, _ = cmd.Flags().GetBool("require-moderation")
+		}
+
+		if thread, err = store.UpdateThread(thread); err != nil {
+			log.Fatal("Could not update thread: ", err)
+		}
+
+		printThread(thread)
+	},
+}
+
+func printThread(thread *model.Thread) {
+	state := thread.State
+	if state == "" {
+		state = model.ThreadOpen
+	}
+
+	fmt.Printf("Thread:             %v\n", thread.Key)
+	fmt.Printf("State:              %v (currently %v)\n", state, thread.EffectiveState(time.Now()))
+	fmt.Printf("Auto close days:    %v\n", thread.AutoCloseDays)
+	fmt.Printf("Require moderation: %v\n", thread.RequireModeration)
+}
+
+func init() {
+	rootCmd.AddCommand(threadsCmd)
+	threadsCmd.AddCommand(threadsMergeCmd, threadsShowCmd, threadsSetCmd)
+
+	threadsSetCmd.Flags().String("state", model.ThreadOpen, "thread state, open, closed or read-only")
+	threadsSetCmd.Flags().Int("auto-close-days", 0, "close the thread this many days after the first comment, 0 disables")
+	threadsSetCmd.Flags().Bool("require-moderation", false, "hold new comments for moderation")
+
+	threadsCmd.PersistentFlags().String("site", "", "name of the site the threads belong to, defaults to the default site")
+}
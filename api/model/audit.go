@@ -0,0 +1,182 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AuditStore exposes methods to record moderator actions and look them up.
+// Recorded entries cannot be changed or removed, except for redacting purged
+// and erased personal data, see redactAudit.
+type AuditStore interface {
+	RecordAudit(*AuditEntry) error
+	GetAuditEntries(AuditQuery) ([]*AuditEntry, error)
+	ForSite(uint) AuditStore
+}
+
+// Audited moderator actions
+const (
+	AuditEdit         = "edit"
+	AuditStatus       = "status"
+	AuditDelete       = "delete"
+	AuditPurge        = "purge"
+	AuditPin          = "pin"
+	AuditUnpin        = "unpin"
+	AuditFeature      = "feature"
+	AuditUnfeature    = "unfeature"
+	AuditClearFlags   = "clear-flags"
+	AuditThread       = "thread"
+	AuditBan          = "ban"
+	AuditUnban        = "unban"
+	AuditAddFilter    = "add-filter"
+	AuditRemoveFilter = "remove-filter"
+)
+
+// Kinds of audit targets
+const (
+	AuditTargetComment = "comment"
+	AuditTargetThread  = "thread"
+	AuditTargetBan     = "ban"
+	AuditTargetFilter  = "filter"
+)
+
+// ErrAuditAppendOnly is returned when changing or removing audit entries
+var ErrAuditAppendOnly = errors.New("Audit entries cannot be changed or removed")
+
+// DefaultAuditLimit is the number of entries returned if no limit is given
+const DefaultAuditLimit = 100
+
+// AuditEntry records a moderator action, with snapshots of the target
+// before and after the action where available
+type AuditEntry struct {
+	ID         uint            `json:"id" gorm:"primary_key"`
+	CreatedAt  time.Time       `json:"createdAt" sql:"index"`
+	SiteID     uint            `json:"siteId" sql:"index"`
+	Actor      string          `json:"actor" sql:"index"`
+	Action     string          `json:"action" sql:"index"`
+	TargetType string          `json:"targetType"`
+	TargetID   uint            `json:"targetId" sql:"index"`
+	Reason     string          `json:"reason"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+}
+
+// BeforeUpdate keeps audit entries from being changed
+func (e *AuditEntry) BeforeUpdate() error {
+	return ErrAuditAppendOnly
+}
+
+// BeforeDelete keeps audit entries from being removed
+func (e *AuditEntry) BeforeDelete() error {
+	return ErrAuditAppendOnly
+}
+
+// ErasedActor replaces the actor of audit entries recorded for a person whose
+// data was erased
+const ErasedActor = "erased"
+
+// redactAudit clears the snapshots of the audit entries matching where. It is
+// the one change allowed to recorded entries, made when purging comments or
+// erasing personal data on request, and skips the BeforeUpdate hook on
+// purpose. Which action was
+// taken on which target is kept, and so is the actor unless it is one of the
+// erased actors, which are replaced by ErasedActor.
+func redactAudit(db *gorm.DB, erased []string, where string, args ...interface{}) error {
+	err := db.Model(&AuditEntry{}).Where(where, args...).
+		UpdateColumns(map[string]interface{}{"before": nil, "after": nil}).Error
+	if err != nil || len(erased) == 0 {
+		return err
+	}
+
+	return db.Model(&AuditEntry{}).Where(where, args...).Where("actor IN (?)", erased).
+		UpdateColumn("actor", ErasedActor).Error
+}
+
+// Snapshot encodes value for an audit entry, nil values encode to null
+func Snapshot(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+
+	snapshot, err := json.Marshal(value)
+	if err != nil || string(snapshot) == "null" {
+		return nil
+	}
+	return snapshot
+}
+
+// AuditQuery filters audit entries, zero values match any entry
+type AuditQuery struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   uint
+	Since      time.Time
+	Until      time.Time
+	// AfterID only matches entries recorded after the entry with this id,
+	// returning the oldest entries first to follow the log
+	AfterID uint
+	// Limit caps the number of entries, DefaultAuditLimit is used if 0
+	Limit int
+}
+
+// SqliteAuditStore implements a gorm based audit store
+type SqliteAuditStore struct {
+	DB *gorm.DB
+	// SiteID scopes all entries to a single site, 0 being the default site
+	SiteID uint
+}
+
+// ForSite returns a copy of the store scoped to the site with id siteID
+func (s SqliteAuditStore) ForSite(siteID uint) AuditStore {
+	s.SiteID = siteID
+	return s
+}
+
+// RecordAudit appends entry to the audit log of the site
+func (s SqliteAuditStore) RecordAudit(entry *AuditEntry) error {
+	entry.ID = 0
+	entry.SiteID = s.SiteID
+	return s.DB.Create(entry).Error
+}
+
+// GetAuditEntries fetches the audit entries of the site matching query, the
+// newest first unless following the log with query.AfterID
+func (s SqliteAuditStore) GetAuditEntries(query AuditQuery) ([]*AuditEntry, error) {
+	db := s.DB.Where("site_id = ?", s.SiteID)
+
+	if query.Actor != "" {
+		db = db.Where("actor = ?", query.Actor)
+	}
+	if query.Action != "" {
+		db = db.Where("action = ?", query.Action)
+	}
+	if query.TargetType != "" {
+		db = db.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID != 0 {
+		db = db.Where("target_id = ?", query.TargetID)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("created_at < ?", query.Until)
+	}
+
+	if query.AfterID != 0 {
+		db = db.Where("id > ?", query.AfterID).Order("id")
+	} else {
+		db = db.Order("id desc")
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = DefaultAuditLimit
+	}
+
+	entries := []*AuditEntry{}
+	return entries, db.Limit(query.Limit).Find(&entries).Error
+}
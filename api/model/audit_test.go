@@ -0,0 +1,99 @@
+package model
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestAudit(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	audit := SqliteAuditStore{DB: db}
+
+	before := &Comment{Content: "Some content"}
+	first := &AuditEntry{Actor: "admin", Action: AuditEdit, TargetType: AuditTargetComment, TargetID: 1,
+		Reason: "Typo", Before: Snapshot(before), After: Snapshot(&Comment{Content: "Edited content"})}
+	if err := audit.RecordAudit(first); err != nil {
+		t.Fatalf("RecordAudit() error = %v", err)
+	}
+	audit.RecordAudit(&AuditEntry{Actor: "site:example", Action: AuditDelete, TargetType: AuditTargetComment, TargetID: 1})
+	audit.RecordAudit(&AuditEntry{Actor: "admin", Action: AuditBan, TargetType: AuditTargetBan, TargetID: 2})
+	audit.ForSite(1).RecordAudit(&AuditEntry{Actor: "admin", Action: AuditPin, TargetType: AuditTargetComment, TargetID: 3})
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  []uint
+	}{
+		{"All entries newest first", AuditQuery{}, []uint{3, 2, 1}},
+		{"Actor", AuditQuery{Actor: "admin"}, []uint{3, 1}},
+		{"Action", AuditQuery{Action: AuditDelete}, []uint{2}},
+		{"Target", AuditQuery{TargetType: AuditTargetComment, TargetID: 1}, []uint{2, 1}},
+		{"Limit", AuditQuery{Limit: 1}, []uint{3}},
+		{"Following oldest first", AuditQuery{AfterID: 1}, []uint{2, 3}},
+		{"Since", AuditQuery{Since: time.Now().Add(time.Hour)}, []uint{}},
+		{"Until", AuditQuery{Until: time.Now().Add(time.Hour)}, []uint{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := audit.GetAuditEntries(tt.query)
+			if err != nil {
+				t.Fatalf("GetAuditEntries() error = %v", err)
+			}
+
+			ids := []uint{}
+			for _, entry := range entries {
+				ids = append(ids, entry.ID)
+			}
+			if len(ids) != len(tt.want) {
+				t.Fatalf("GetAuditEntries() = %v, want %v", ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Errorf("GetAuditEntries() = %v, want %v", ids, tt.want)
+				}
+			}
+		})
+	}
+
+	if entries, _ := audit.ForSite(1).GetAuditEntries(AuditQuery{}); len(entries) != 1 || entries[0].Action != AuditPin {
+		t.Errorf("GetAuditEntries() expected only the entry of site 1, got %v", entries)
+	}
+
+	entry := &AuditEntry{}
+	db.First(entry, first.ID)
+	if string(entry.Before) != string(Snapshot(before)) || entry.Reason != "Typo" {
+		t.Errorf("Expected snapshots and reason to be stored, got %+v", entry)
+	}
+
+	if err := db.Model(entry).Update("reason", "Changed").Error; err != ErrAuditAppendOnly {
+		t.Errorf("Expected changing audit entry to fail with %v, got %v", ErrAuditAppendOnly, err)
+	}
+	if err := db.Delete(entry).Error; err != ErrAuditAppendOnly {
+		t.Errorf("Expected removing audit entry to fail with %v, got %v", ErrAuditAppendOnly, err)
+	}
+	if db.First(&AuditEntry{}, first.ID).Error != nil || db.First(&AuditEntry{}, first.ID).Value.(*AuditEntry).Reason != "Typo" {
+		t.Errorf("Expected audit entry to be kept unchanged")
+	}
+
+	// Erasing personal data is the one change allowed
+	if err := redactAudit(db, nil, "id = ?", first.ID); err != nil {
+		t.Fatalf("redactAudit() error = %v", err)
+	}
+	redacted := &AuditEntry{}
+	db.First(redacted, first.ID)
+	if redacted.Before != nil || redacted.After != nil || redacted.Actor != "admin" || redacted.Action != AuditEdit || redacted.Reason != "Typo" {
+		t.Errorf("redactAudit() expected only the snapshots to be cleared, got %+v", redacted)
+	}
+}
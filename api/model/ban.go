@@ -0,0 +1,196 @@
+package model
+
+import (
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// BanStore exposes methods to manage the bans of a site and check posters
+// against them
+type BanStore interface {
+	GetBans() ([]*Ban, error)
+	CreateBan(*Ban) (*Ban, error)
+	DeleteBan(uint) (*Ban, error)
+	FindBan(email, username string, ip net.IP) (*Ban, error)
+	ForSite(uint) BanStore
+}
+
+// Ban kinds
+const (
+	// BanEmail bans an exact email address
+	BanEmail = "email"
+	// BanDomain bans every email address of a domain and its subdomains
+	BanDomain = "domain"
+	// BanIP bans an ip address or a CIDR network
+	BanIP = "ip"
+	// BanUsername bans usernames matching a pattern, where * matches any
+	// characters and ? a single character
+	BanUsername = "username"
+)
+
+// Ban stops a repeat offender from posting comments and reacting. Shadow
+// bans accept comments but hide them from everyone except their poster.
+type Ban struct {
+	ID        uint       `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time  `json:"createdAt"`
+	SiteID    uint       `json:"siteId" sql:"index"`
+	Kind      string     `json:"kind"`
+	Value     string     `json:"value"`
+	Reason    string     `json:"reason"`
+	Shadow    bool       `json:"shadow"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// CreatedBy names the moderator who added the ban
+	CreatedBy string `json:"createdBy"`
+}
+
+// normalize lowercases the value of b and checks that it fits its kind
+func (b *Ban) normalize() error {
+	b.Value = strings.ToLower(strings.TrimSpace(b.Value))
+	errs := ValidationError{}
+
+	switch b.Kind {
+	case BanEmail:
+		if !validEmail(b.Value) {
+			errs = append(errs, FieldError{"value", CodeInvalid, ErrInvalidEmail.Error()})
+		}
+	case BanDomain:
+		b.Value = strings.TrimPrefix(b.Value, "@")
+		if b.Value == "" || strings.ContainsAny(b.Value, "@ /") {
+			errs = append(errs, FieldError{"value", CodeInvalid, "Domain must be a domain name like example.com"})
+		}
+	case BanIP:
+		if ip := net.ParseIP(b.Value); ip != nil && ip.To4() != nil {
+			b.Value = ip.String() + "/32"
+		} else if ip != nil {
+			b.Value = ip.String() + "/128"
+		}
+		if _, network, err := net.ParseCIDR(b.Value); err != nil {
+			errs = append(errs, FieldError{"value", CodeInvalid, "IP must be an ip address or a CIDR network"})
+		} else {
+			b.Value = network.String()
+		}
+	case BanUsername:
+		if _, err := path.Match(b.Value, ""); b.Value == "" || err != nil {
+			errs = append(errs, FieldError{"value", CodeInvalid, "Username pattern is invalid"})
+		}
+	default:
+		errs = append(errs, FieldError{"kind", CodeInvalid, "Kind must be email, domain, ip or username"})
+	}
+
+	if b.ExpiresAt != nil && !b.ExpiresAt.After(time.Now()) {
+		errs = append(errs, FieldError{"expiresAt", CodeInvalid, "Expiry must be in the future"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Active tells if the ban is in effect at time now
+func (b *Ban) Active(now time.Time) bool {
+	return b.ExpiresAt == nil || b.ExpiresAt.After(now)
+}
+
+// Matches tells if a poster with email, username, and ip address is banned
+// by b. Empty values and nil addresses never match.
+func (b *Ban) Matches(email, username string, ip net.IP) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	switch b.Kind {
+	case BanEmail:
+		return email != "" && email == b.Value
+	case BanDomain:
+		i := strings.LastIndex(email, "@")
+		if i < 0 {
+			return false
+		}
+		domain := email[i+1:]
+		return domain == b.Value || strings.HasSuffix(domain, "."+b.Value)
+	case BanIP:
+		_, network, err := net.ParseCIDR(b.Value)
+		return err == nil && ip != nil && network.Contains(ip)
+	case BanUsername:
+		matched, _ := path.Match(b.Value, strings.ToLower(strings.TrimSpace(username)))
+		return username != "" && matched
+	}
+	return false
+}
+
+// SqliteBanStore implements a gorm based ban store
+type SqliteBanStore struct {
+	DB *gorm.DB
+	// SiteID scopes all bans to a single site, 0 being the default site
+	SiteID uint
+}
+
+// ForSite returns a copy of the store scoped to the site with id siteID
+func (s SqliteBanStore) ForSite(siteID uint) BanStore {
+	s.SiteID = siteID
+	return s
+}
+
+// GetBans fetches all bans of the site from database, including expired
+// bans
+func (s SqliteBanStore) GetBans() ([]*Ban, error) {
+	bans := []*Ban{}
+	return bans, s.DB.Where("site_id = ?", s.SiteID).Order("id").Find(&bans).Error
+}
+
+// CreateBan inserts ban into database after checking its value fits its
+// kind, returning a ValidationError if not
+func (s SqliteBanStore) CreateBan(ban *Ban) (*Ban, error) {
+	if err := ban.normalize(); err != nil {
+		return nil, err
+	}
+
+	ban.ID = 0
+	ban.SiteID = s.SiteID
+	return ban, s.DB.Create(ban).Error
+}
+
+// DeleteBan deletes the ban with id from database, returning the deleted ban
+func (s SqliteBanStore) DeleteBan(id uint) (*Ban, error) {
+	if id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	ban := Ban{}
+	if err := s.DB.Where("site_id = ?", s.SiteID).First(&ban, id).Error; err != nil {
+		return nil, err
+	}
+
+	db := s.DB.Where("site_id = ?", s.SiteID).Delete(&Ban{ID: id})
+
+	if db.Error != nil {
+		return nil, db.Error
+	} else if db.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &ban, nil
+}
+
+// FindBan returns the active ban matching a poster with email, username,
+// and ip address, preferring bans that are not shadow bans. Returns
+// gorm.ErrRecordNotFound if the poster is not banned.
+func (s SqliteBanStore) FindBan(email, username string, ip net.IP) (*Ban, error) {
+	bans := []*Ban{}
+	err := s.DB.Where("site_id = ? AND (expires_at IS NULL OR expires_at > ?)", s.SiteID, time.Now()).
+		Order("shadow").
+		Order("id").
+		Find(&bans).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ban := range bans {
+		if ban.Matches(email, username, ip) {
+			return ban, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/db"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestBanMatches(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+
+	tests := []struct {
+		name     string
+		ban      Ban
+		email    string
+		username string
+		ip       net.IP
+		want     bool
+	}{
+		{"Email", Ban{Kind: BanEmail, Value: "troll@example.com"}, "Troll@Example.com", "", nil, true},
+		{"Other email", Ban{Kind: BanEmail, Value: "troll@example.com"}, "alice@example.com", "", nil, false},
+		{"Domain", Ban{Kind: BanDomain, Value: "example.com"}, "troll@example.com", "", nil, true},
+		{"Subdomain", Ban{Kind: BanDomain, Value: "example.com"}, "troll@mail.example.com", "", nil, true},
+		{"Domain suffix", Ban{Kind: BanDomain, Value: "example.com"}, "troll@badexample.com", "", nil, false},
+		{"Domain without email", Ban{Kind: BanDomain, Value: "example.com"}, "", "", nil, false},
+		{"IP", Ban{Kind: BanIP, Value: "192.0.2.10/32"}, "", "", ip, true},
+		{"Network", Ban{Kind: BanIP, Value: "192.0.2.0/24"}, "", "", ip, true},
+		{"Other network", Ban{Kind: BanIP, Value: "198.51.100.0/24"}, "", "", ip, false},
+		{"IP without client", Ban{Kind: BanIP, Value: "192.0.2.0/24"}, "", "", nil, false},
+		{"Username", Ban{Kind: BanUsername, Value: "troll"}, "", "Troll", nil, true},
+		{"Username pattern", Ban{Kind: BanUsername, Value: "troll*"}, "", "troll42", nil, true},
+		{"Other username", Ban{Kind: BanUsername, Value: "troll*"}, "", "alice", nil, false},
+		{"Pattern without username", Ban{Kind: BanUsername, Value: "*"}, "", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ban.Matches(tt.email, tt.username, tt.ip); got != tt.want {
+				t.Errorf("Ban.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBans(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	bans := SqliteBanStore{DB: db}
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	invalid := []*Ban{
+		{Kind: "country", Value: "xx"},
+		{Kind: BanEmail, Value: "troll"},
+		{Kind: BanDomain, Value: "troll@example.com"},
+		{Kind: BanIP, Value: "192.0.2.0/33"},
+		{Kind: BanUsername, Value: "troll["},
+		{Kind: BanEmail, Value: "troll@example.com", ExpiresAt: &past},
+	}
+	for _, ban := range invalid {
+		if _, err := bans.CreateBan(ban); err == nil {
+			t.Errorf("CreateBan() expected validation error for %v %v", ban.Kind, ban.Value)
+		} else if _, ok := err.(ValidationError); !ok {
+			t.Errorf("CreateBan() expected ValidationError, got %v", err)
+		}
+	}
+
+	ipBan, err := bans.CreateBan(&Ban{Kind: BanIP, Value: "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("CreateBan() error = %v", err)
+	} else if ipBan.Value != "192.0.2.10/32" {
+		t.Errorf("CreateBan() expected address to be stored as network, got %v", ipBan.Value)
+	}
+
+	domainBan, _ := bans.CreateBan(&Ban{Kind: BanDomain, Value: "@Example.com", Shadow: true})
+	if domainBan.Value != "example.com" {
+		t.Errorf("CreateBan() expected normalized domain, got %v", domainBan.Value)
+	}
+
+	bans.CreateBan(&Ban{Kind: BanEmail, Value: "troll@example.com", ExpiresAt: &future})
+	bans.ForSite(1).CreateBan(&Ban{Kind: BanUsername, Value: "troll"})
+
+	if ban, err := bans.FindBan("troll@example.com", "troll", nil); err != nil || ban.Shadow {
+		t.Errorf("FindBan() expected the email ban to be preferred over the shadow ban, got %+v, %v", ban, err)
+	}
+	if ban, err := bans.FindBan("alice@example.com", "", nil); err != nil || ban.ID != domainBan.ID {
+		t.Errorf("FindBan() expected the domain ban, got %+v, %v", ban, err)
+	}
+	if _, err := bans.FindBan("", "troll", net.ParseIP("198.51.100.1")); err != gorm.ErrRecordNotFound {
+		t.Errorf("FindBan() expected bans of other sites to be ignored, got %v", err)
+	}
+
+	db.Model(&Ban{}).Where("kind = ?", BanEmail).UpdateColumn("expires_at", past)
+	if ban, err := bans.FindBan("troll@example.com", "", nil); err != nil || !ban.Shadow {
+		t.Errorf("FindBan() expected expired ban to be ignored, got %+v, %v", ban, err)
+	}
+
+	if list, _ := bans.GetBans(); len(list) != 3 {
+		t.Errorf("GetBans() expected 3 bans of the default site, got %v", len(list))
+	}
+
+	if _, err := bans.ForSite(1).DeleteBan(ipBan.ID); err != gorm.ErrRecordNotFound {
+		t.Errorf("DeleteBan() expected bans of other sites to be kept, got %v", err)
+	}
+	if deleted, err := bans.DeleteBan(ipBan.ID); err != nil {
+		t.Errorf("DeleteBan() error = %v", err)
+	} else if deleted.ID != ipBan.ID || deleted.Value != ipBan.Value {
+		t.Errorf("DeleteBan() expected the deleted ban %+v, got %+v", ipBan, deleted)
+	}
+	if _, err := bans.FindBan("", "", net.ParseIP("192.0.2.10")); err != gorm.ErrRecordNotFound {
+		t.Errorf("FindBan() expected removed ban to be ignored, got %v", err)
+	}
+}
+
+func TestCountShadowedComments(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	url := "http://example.com/post/1"
+
+	commenter.CreateComment(&Comment{Content: "Some content", URL: url, Status: StatusApproved})
+	commenter.CreateComment(&Comment{Content: "Troll content", URL: url, Status: StatusApproved, Shadowed: true})
+
+	counts, err := commenter.CountComments([]string{url})
+	if err != nil {
+		t.Fatalf("CountComments() error = %v", err)
+	} else if counts[url] != 1 {
+		t.Errorf("CountComments() expected shadowed comments to not be counted, got %v", counts[url])
+	}
+}
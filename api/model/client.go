@@ -0,0 +1,139 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Ways of storing the ip address comments are posted from
+const (
+	// IPModeHash only stores a salted hash of the ip address
+	IPModeHash = "hash"
+	// IPModeTruncate stores the /24 or /48 network of the ip address
+	IPModeTruncate = "truncate"
+	// IPModeFull stores the full ip address
+	IPModeFull = "full"
+)
+
+// DefaultClientRetention is how long ip addresses and user agents are kept
+// if no retention is configured
+const DefaultClientRetention = 30 * 24 * time.Hour
+
+// ClientPolicy controls how the ip address and user agent of the client
+// posting a comment are stored, balancing abuse handling against privacy
+type ClientPolicy struct {
+	// IPMode is one of IPModeHash, IPModeTruncate, or IPModeFull,
+	// IPModeHash is used if empty
+	IPMode string
+	// Salt is mixed into hashes of ip addresses and user agents, so they
+	// cannot be reversed by hashing every address
+	Salt string
+	// Retention controls how long ip addresses and user agents are kept
+	// before being expired, hashes of ip addresses are kept for matching
+	Retention time.Duration
+}
+
+// ValidateIPMode checks that mode is a known ip mode or empty
+func ValidateIPMode(mode string) error {
+	switch mode {
+	case "", IPModeHash, IPModeTruncate, IPModeFull:
+		return nil
+	}
+	return fmt.Errorf("IP mode must be %v, %v or %v", IPModeHash, IPModeTruncate, IPModeFull)
+}
+
+// HashIP returns the salted hash of ip, which is the same for every comment
+// posted from ip
+func (p ClientPolicy) HashIP(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return p.hash(ip.String())
+}
+
+func (p ClientPolicy) hash(value string) string {
+	mac := hmac.New(sha256.New, []byte(p.Salt))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TruncateIP returns the /24 network of IPv4 addresses and the /48 network
+// of IPv6 addresses
+func TruncateIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	} else if ip != nil {
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+	return ""
+}
+
+// Record sets the client fields of comment from the ip address and user
+// agent of the client posting it
+func (p ClientPolicy) Record(comment *Comment, ip net.IP, userAgent string) {
+	comment.ClientIPHash = p.HashIP(ip)
+	comment.ClientIP, comment.UserAgent = "", ""
+
+	switch p.IPMode {
+	case IPModeFull:
+		if ip != nil {
+			comment.ClientIP = ip.String()
+		}
+		comment.UserAgent = userAgent
+	case IPModeTruncate:
+		comment.ClientIP = TruncateIP(ip)
+		comment.UserAgent = userAgent
+	default:
+		if userAgent != "" {
+			comment.UserAgent = p.hash(userAgent)
+		}
+	}
+}
+
+// ClientInfo is what is known about the client that posted a comment, only
+// shown to moderators
+type ClientInfo struct {
+	CommentID    uint       `json:"commentId"`
+	CreatedAt    *time.Time `json:"createdAt"`
+	ClientIP     string     `json:"clientIp"`
+	ClientIPHash string     `json:"clientIpHash"`
+	UserAgent    string     `json:"userAgent"`
+}
+
+// GetClientInfo returns what is known about the client that posted the
+// comment with id
+func (c SqliteCommentStore) GetClientInfo(id uint) (*ClientInfo, error) {
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return comment.clientInfo(), nil
+}
+
+func (comment *Comment) clientInfo() *ClientInfo {
+	return &ClientInfo{
+		CommentID:    *comment.ID,
+		CreatedAt:    comment.CreatedAt,
+		ClientIP:     comment.ClientIP,
+		ClientIPHash: comment.ClientIPHash,
+		UserAgent:    comment.UserAgent,
+	}
+}
+
+// ExpireClientInfo clears the ip addresses and user agents of comments of
+// all sites posted before cutoff, and returns the number of comments
+// cleared. Hashes of ip addresses are kept.
+func (c SqliteCommentStore) ExpireClientInfo(cutoff time.Time) (int, error) {
+	db := c.DB.Unscoped().Model(&Comment{}).
+		Where("created_at < ? AND (client_ip <> '' OR user_agent <> '')", cutoff).
+		UpdateColumns(map[string]interface{}{
+			"client_ip":  "",
+			"user_agent": "",
+		})
+	return int(db.RowsAffected), db.Error
+}
@@ -0,0 +1,133 @@
+package model
+
+import (
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestClientPolicyRecord(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+	userAgent := "Mozilla/5.0"
+
+	tests := []struct {
+		name      string
+		mode      string
+		clientIP  string
+		userAgent string
+	}{
+		{name: "Hash", mode: IPModeHash},
+		{name: "Default", mode: ""},
+		{name: "Truncate", mode: IPModeTruncate, clientIP: "192.0.2.0", userAgent: userAgent},
+		{name: "Full", mode: IPModeFull, clientIP: "192.0.2.10", userAgent: userAgent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := ClientPolicy{IPMode: tt.mode, Salt: "salt"}
+			comment := &Comment{}
+			policy.Record(comment, ip, userAgent)
+
+			if comment.ClientIP != tt.clientIP {
+				t.Errorf("Record() ClientIP = %v, want %v", comment.ClientIP, tt.clientIP)
+			}
+			if comment.ClientIPHash == "" || comment.ClientIPHash != policy.HashIP(ip) {
+				t.Errorf("Record() ClientIPHash = %v, want %v", comment.ClientIPHash, policy.HashIP(ip))
+			}
+			if tt.userAgent != "" && comment.UserAgent != tt.userAgent {
+				t.Errorf("Record() UserAgent = %v, want %v", comment.UserAgent, tt.userAgent)
+			} else if tt.userAgent == "" && (comment.UserAgent == "" || comment.UserAgent == userAgent) {
+				t.Errorf("Record() expected hashed user agent, got %v", comment.UserAgent)
+			}
+		})
+	}
+
+	if (ClientPolicy{Salt: "a"}).HashIP(ip) == (ClientPolicy{Salt: "b"}).HashIP(ip) {
+		t.Errorf("HashIP() expected hashes to depend on the salt")
+	}
+
+	comment := &Comment{}
+	ClientPolicy{IPMode: IPModeFull}.Record(comment, nil, "")
+	if comment.ClientIP != "" || comment.ClientIPHash != "" || comment.UserAgent != "" {
+		t.Errorf("Record() expected nothing recorded without a client, got %+v", comment)
+	}
+}
+
+func TestTruncateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.10", "192.0.2.0"},
+		{"::ffff:192.0.2.10", "192.0.2.0"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::"},
+	}
+	for _, tt := range tests {
+		if got := TruncateIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("TruncateIP(%v) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestValidateIPMode(t *testing.T) {
+	for _, mode := range []string{"", IPModeHash, IPModeTruncate, IPModeFull} {
+		if err := ValidateIPMode(mode); err != nil {
+			t.Errorf("ValidateIPMode(%q) error = %v", mode, err)
+		}
+	}
+	if err := ValidateIPMode("partial"); err == nil {
+		t.Errorf("ValidateIPMode() expected error for unknown mode")
+	}
+}
+
+func TestExpireClientInfo(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	policy := ClientPolicy{IPMode: IPModeFull, Salt: "salt"}
+	url := "http://example.com/post/1"
+
+	old := &Comment{Content: "Old comment", URL: url}
+	policy.Record(old, net.ParseIP("192.0.2.10"), "Mozilla/5.0")
+	old, _ = commenter.CreateComment(old)
+	db.Model(old).UpdateColumn("created_at", time.Now().Add(-48*time.Hour))
+
+	recent := &Comment{Content: "Recent comment", URL: url}
+	policy.Record(recent, net.ParseIP("192.0.2.11"), "Mozilla/5.0")
+	recent, _ = commenter.CreateComment(recent)
+
+	if _, err := commenter.UpdateComment(&Comment{ID: recent.ID, Content: "Edited comment", URL: url}); err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+
+	expired, err := commenter.ExpireClientInfo(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("ExpireClientInfo() error = %v", err)
+	} else if expired != 1 {
+		t.Errorf("ExpireClientInfo() = %v, want 1", expired)
+	}
+
+	info, err := commenter.GetClientInfo(*old.ID)
+	if err != nil {
+		t.Fatalf("GetClientInfo() error = %v", err)
+	} else if info.ClientIP != "" || info.UserAgent != "" || info.ClientIPHash != policy.HashIP(net.ParseIP("192.0.2.10")) {
+		t.Errorf("GetClientInfo() expected only the ip hash to be kept, got %+v", info)
+	}
+
+	info, err = commenter.GetClientInfo(*recent.ID)
+	if err != nil {
+		t.Fatalf("GetClientInfo() error = %v", err)
+	} else if info.ClientIP != "192.0.2.11" || info.UserAgent != "Mozilla/5.0" {
+		t.Errorf("GetClientInfo() expected recent client to be kept, got %+v", info)
+	}
+}
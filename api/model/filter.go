@@ -0,0 +1,241 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FilterStore exposes methods to manage the word filter of a site and
+// review the rules comments triggered
+type FilterStore interface {
+	GetFilterRules() ([]*FilterRule, error)
+	CreateFilterRule(*FilterRule) (*FilterRule, error)
+	DeleteFilterRule(uint) error
+	GetFilterHits(uint) ([]*FilterHit, error)
+	ForSite(uint) FilterStore
+}
+
+// Filter actions taken when a rule matches the content of a comment
+const (
+	// FilterReject refuses the comment
+	FilterReject = "reject"
+	// FilterMask replaces the letters of matching words with *
+	FilterMask = "mask"
+	// FilterHold holds the comment for approval by a moderator
+	FilterHold = "hold"
+)
+
+// FilterRule matches a word or phrase in the content of comments. Matching
+// ignores case and only matches whole words, unless the pattern starts or
+// ends with * to match any word with the given suffix or prefix.
+type FilterRule struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time `json:"createdAt"`
+	SiteID    uint      `json:"siteId" sql:"index"`
+	Pattern   string    `json:"pattern"`
+	Action    string    `json:"action"`
+}
+
+// FilterHit records a rule triggered by a comment for moderator review. The
+// pattern and action are copied, so hits outlive their rule.
+type FilterHit struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time `json:"createdAt"`
+	CommentID uint      `json:"commentId" sql:"index"`
+	RuleID    uint      `json:"ruleId"`
+	Pattern   string    `json:"pattern"`
+	Action    string    `json:"action"`
+}
+
+// errFilterReject is returned when a comment matches a reject rule
+var errFilterReject = ValidationError{{Field: "content", Code: CodeNotAllowed, Message: "Content contains blocked words"}}
+
+// normalize lowercases the pattern of f and checks its action
+func (f *FilterRule) normalize() error {
+	f.Pattern = strings.Join(strings.Fields(strings.ToLower(f.Pattern)), " ")
+	errs := ValidationError{}
+
+	if strings.Trim(f.Pattern, "*") == "" || strings.Contains(strings.Trim(f.Pattern, "*"), "*") {
+		errs = append(errs, FieldError{"pattern", CodeInvalid, "Pattern must be a word or phrase, optionally starting or ending with *"})
+	}
+
+	if f.Action != FilterReject && f.Action != FilterMask && f.Action != FilterHold {
+		errs = append(errs, FieldError{"action", CodeInvalid, "Action must be reject, mask or hold"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// find returns the start and end offsets of each match of f in content
+func (f *FilterRule) find(content string) [][]int {
+	words := strings.Fields(strings.Trim(f.Pattern, "*"))
+	if len(words) == 0 {
+		return nil
+	}
+
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	pattern := regexp.MustCompile(`(?i)` + strings.Join(words, `\s+`))
+
+	prefix := strings.HasSuffix(f.Pattern, "*")
+	suffix := strings.HasPrefix(f.Pattern, "*")
+
+	matches := [][]int{}
+	for _, match := range pattern.FindAllStringIndex(content, -1) {
+		before, _ := utf8.DecodeLastRuneInString(content[:match[0]])
+		after, _ := utf8.DecodeRuneInString(content[match[1]:])
+
+		if !suffix && isWordRune(before) {
+			continue
+		} else if !prefix && isWordRune(after) {
+			continue
+		}
+
+		// Wildcards extend the match to the rest of the word
+		for suffix && isWordRune(before) {
+			match[0] -= utf8.RuneLen(before)
+			before, _ = utf8.DecodeLastRuneInString(content[:match[0]])
+		}
+		for prefix && isWordRune(after) {
+			match[1] += utf8.RuneLen(after)
+			after, _ = utf8.DecodeRuneInString(content[match[1]:])
+		}
+
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || r == '_'
+}
+
+// mask replaces the letters and digits of content between start and end
+// with *
+func mask(content string, start, end int) string {
+	masked := strings.Map(func(r rune) rune {
+		if isWordRune(r) {
+			return '*'
+		}
+		return r
+	}, content[start:end])
+	return content[:start] + masked + content[end:]
+}
+
+// applyFilters runs the word filter of the site over the content of
+// comment, masking words or holding the comment for moderation as the
+// matching rules say, and returns the hits to record. Comments matching a
+// reject rule are refused with a ValidationError.
+func (c SqliteCommentStore) applyFilters(comment *Comment) ([]*FilterHit, error) {
+	rules := []*FilterRule{}
+	if err := c.DB.Where("site_id = ?", c.SiteID).Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	hits := []*FilterHit{}
+	for _, rule := range rules {
+		matches := rule.find(comment.Content)
+		if len(matches) == 0 {
+			continue
+		}
+
+		switch rule.Action {
+		case FilterReject:
+			return nil, errFilterReject
+		case FilterMask:
+			// Masking from the end keeps the offsets of earlier matches
+			for i := len(matches) - 1; i >= 0; i-- {
+				comment.Content = mask(comment.Content, matches[i][0], matches[i][1])
+			}
+		case FilterHold:
+			comment.Status = StatusPending
+		}
+
+		hits = append(hits, &FilterHit{RuleID: rule.ID, Pattern: rule.Pattern, Action: rule.Action})
+	}
+	return hits, nil
+}
+
+// recordFilterHits replaces the hits recorded for the comment with id
+func (c SqliteCommentStore) recordFilterHits(id uint, hits []*FilterHit) error {
+	if err := c.DB.Where("comment_id = ?", id).Delete(&FilterHit{}).Error; err != nil {
+		return err
+	}
+
+	for _, hit := range hits {
+		hit.CommentID = id
+		if err := c.DB.Create(hit).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SqliteFilterStore implements a gorm based filter store
+type SqliteFilterStore struct {
+	DB *gorm.DB
+	// SiteID scopes all rules to a single site, 0 being the default site
+	SiteID uint
+}
+
+// ForSite returns a copy of the store scoped to the site with id siteID
+func (s SqliteFilterStore) ForSite(siteID uint) FilterStore {
+	s.SiteID = siteID
+	return s
+}
+
+// GetFilterRules fetches the word filter of the site from database
+func (s SqliteFilterStore) GetFilterRules() ([]*FilterRule, error) {
+	rules := []*FilterRule{}
+	return rules, s.DB.Where("site_id = ?", s.SiteID).Order("id").Find(&rules).Error
+}
+
+// CreateFilterRule inserts rule into database after checking its pattern
+// and action, returning a ValidationError if invalid
+func (s SqliteFilterStore) CreateFilterRule(rule *FilterRule) (*FilterRule, error) {
+	if err := rule.normalize(); err != nil {
+		return nil, err
+	}
+
+	rule.ID = 0
+	rule.SiteID = s.SiteID
+	return rule, s.DB.Create(rule).Error
+}
+
+// DeleteFilterRule deletes the rule with id from database. Hits of the rule
+// are kept.
+func (s SqliteFilterStore) DeleteFilterRule(id uint) error {
+	if id == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	db := s.DB.Where("site_id = ?", s.SiteID).Delete(&FilterRule{ID: id})
+
+	if db.Error != nil {
+		return db.Error
+	} else if db.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetFilterHits fetches the rules the comment with id triggered when it was
+// last posted or edited
+func (s SqliteFilterStore) GetFilterHits(id uint) ([]*FilterHit, error) {
+	comment := Comment{}
+	if err := s.DB.Where("site_id = ?", s.SiteID).First(&comment, id).Error; err != nil {
+		return nil, err
+	}
+
+	hits := []*FilterHit{}
+	return hits, s.DB.Where("comment_id = ?", id).Order("id").Find(&hits).Error
+}
@@ -0,0 +1,133 @@
+package model
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestFilterRuleMask(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		content string
+		want    string
+	}{
+		{"Whole word", "darn", "Darn it, darn!", "**** it, ****!"},
+		{"Inside word", "darn", "Undarnable", "Undarnable"},
+		{"Phrase", "darn it", "Oh darn\n it", "Oh ****\n **"},
+		{"Prefix", "darn*", "Darnation and darn", "********* and ****"},
+		{"Suffix", "*darn", "Gosh-darn", "Gosh-****"},
+		{"Unicode", "fjåsete", "Så FJÅSETE!", "Så *******!"},
+		{"Unicode boundary", "fjås", "fjåsete", "fjåsete"},
+		{"Cyrillic", "чёрт", "Ну чёрт.", "Ну ****."},
+		{"Special characters", "a.b", "axb a.b", "axb *.*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &FilterRule{Pattern: tt.pattern, Action: FilterMask}
+			if err := rule.normalize(); err != nil {
+				t.Fatalf("normalize() error = %v", err)
+			}
+
+			got := tt.content
+			matches := rule.find(got)
+			for i := len(matches) - 1; i >= 0; i-- {
+				got = mask(got, matches[i][0], matches[i][1])
+			}
+
+			if got != tt.want {
+				t.Errorf("mask() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	filters := SqliteFilterStore{DB: db}
+	commenter := SqliteCommentStore{DB: db}
+	url := "http://example.com/post/1"
+
+	invalid := []*FilterRule{
+		{Pattern: "*", Action: FilterMask},
+		{Pattern: "da*rn", Action: FilterMask},
+		{Pattern: "darn", Action: "delete"},
+	}
+	for _, rule := range invalid {
+		if _, err := filters.CreateFilterRule(rule); err == nil {
+			t.Errorf("CreateFilterRule() expected validation error for %v %v", rule.Action, rule.Pattern)
+		}
+	}
+
+	maskRule, _ := filters.CreateFilterRule(&FilterRule{Pattern: " Darn ", Action: FilterMask})
+	holdRule, _ := filters.CreateFilterRule(&FilterRule{Pattern: "buy now", Action: FilterHold})
+	filters.CreateFilterRule(&FilterRule{Pattern: "spam*", Action: FilterReject})
+	filters.ForSite(1).CreateFilterRule(&FilterRule{Pattern: "other", Action: FilterReject})
+
+	if maskRule.Pattern != "darn" {
+		t.Errorf("CreateFilterRule() expected normalized pattern, got %q", maskRule.Pattern)
+	}
+
+	if _, err := commenter.CreateComment(&Comment{Content: "Spammy content", URL: url}); !reflect.DeepEqual(err, errFilterReject) {
+		t.Errorf("CreateComment() expected rejection, got %v", err)
+	}
+
+	comment, err := commenter.CreateComment(&Comment{Content: "Darn, buy now", URL: url, Status: StatusApproved})
+	if err != nil {
+		t.Fatalf("CreateComment() error = %v", err)
+	} else if comment.Content != "****, buy now" || comment.Status != StatusPending {
+		t.Errorf("CreateComment() expected masked and held comment, got %q %v", comment.Content, comment.Status)
+	}
+
+	hits, err := filters.GetFilterHits(*comment.ID)
+	if err != nil {
+		t.Fatalf("GetFilterHits() error = %v", err)
+	} else if len(hits) != 2 || hits[0].RuleID != maskRule.ID || hits[1].RuleID != holdRule.ID || hits[1].Action != FilterHold {
+		t.Errorf("GetFilterHits() expected mask and hold hits, got %+v", hits)
+	}
+
+	if _, err := filters.ForSite(1).GetFilterHits(*comment.ID); err == nil {
+		t.Errorf("GetFilterHits() expected comments of other sites to not be found")
+	}
+
+	comment.Status = StatusApproved
+	if comment, err = commenter.UpdateComment(comment); err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	} else if comment.Status != StatusApproved {
+		t.Errorf("UpdateComment() expected moderator to approve held comment, got %v", comment.Status)
+	}
+	if hits, _ := filters.GetFilterHits(*comment.ID); len(hits) != 2 {
+		t.Errorf("GetFilterHits() expected hits to be kept when content is unchanged, got %+v", hits)
+	}
+
+	comment.Content = "Darn other content"
+	comment.Status = StatusApproved
+	if comment, err = commenter.UpdateComment(comment); err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	} else if comment.Content != "**** other content" || comment.Status != StatusApproved {
+		t.Errorf("UpdateComment() expected masked comment, got %q %v", comment.Content, comment.Status)
+	}
+
+	if hits, _ := filters.GetFilterHits(*comment.ID); len(hits) != 1 || hits[0].RuleID != maskRule.ID {
+		t.Errorf("GetFilterHits() expected hits of the edit only, got %+v", hits)
+	}
+
+	if err := filters.DeleteFilterRule(maskRule.ID); err != nil {
+		t.Errorf("DeleteFilterRule() error = %v", err)
+	}
+	if rules, _ := filters.GetFilterRules(); len(rules) != 2 {
+		t.Errorf("GetFilterRules() expected 2 rules, got %v", len(rules))
+	}
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FlagStore exposes methods for readers to flag comments and moderators to
+// review them
+type FlagStore interface {
+	FlagComment(uint, string, string) (*Comment, error)
+	GetFlaggedComments() ([]*Comment, error)
+	ClearFlags(uint) (*Comment, error)
+}
+
+// Reasons readers can flag comments for
+const (
+	FlagSpam     = "spam"
+	FlagAbuse    = "abuse"
+	FlagOffTopic = "off-topic"
+	FlagOther    = "other"
+)
+
+// ErrInvalidFlagReason is returned when flagging a comment for an unknown
+// reason
+var ErrInvalidFlagReason = errors.New("Reason must be spam, abuse, off-topic or other")
+
+// Flag represents a single reporter flagging a comment for moderators
+type Flag struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time `json:"createdAt"`
+	CommentID uint      `json:"commentId" gorm:"unique_index:uix_flags_comment_reporter"`
+	Reporter  string    `json:"-" gorm:"unique_index:uix_flags_comment_reporter"`
+	Reason    string    `json:"reason"`
+}
+
+// FlagComment records reporter flagging comment with id for reason. Each
+// reporter flags a comment once, flagging it again has no effect. Comments
+// reaching the flag threshold of the store are held for moderation.
+func (c SqliteCommentStore) FlagComment(id uint, reporter string, reason string) (*Comment, error) {
+	if reason != FlagSpam && reason != FlagAbuse && reason != FlagOffTopic && reason != FlagOther {
+		return nil, ErrInvalidFlagReason
+	}
+
+	tx := c.DB.Begin()
+	store := c
+	store.DB = tx
+	comment, err := store.flag(id, reporter, reason)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return comment, tx.Commit().Error
+}
+
+// flag records the flag of reporter, see FlagComment
+func (c SqliteCommentStore) flag(id uint, reporter string, reason string) (*Comment, error) {
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.DB.Where("comment_id = ? AND reporter = ?", id, reporter).First(&Flag{}).Error
+	if err == nil {
+		return comment, nil
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if err := c.DB.Create(&Flag{CommentID: id, Reporter: reporter, Reason: reason}).Error; err != nil {
+		return nil, err
+	}
+
+	// The hold is decided on the count in the database rather than the one
+	// read above, so concurrent flags cannot both miss the threshold
+	values := map[string]interface{}{
+		"flag_count": gorm.Expr("flag_count + 1"),
+		"version":    gorm.Expr("version + 1"),
+	}
+	if c.FlagThreshold > 0 {
+		values["status"] = gorm.Expr("CASE WHEN flag_count + 1 >= ? THEN ? ELSE status END", c.FlagThreshold, StatusPending)
+		values["updated_at"] = gorm.Expr("CASE WHEN flag_count + 1 >= ? THEN ? ELSE updated_at END", c.FlagThreshold, time.Now())
+	}
+
+	if err := c.scoped().Model(&Comment{ID: &id}).UpdateColumns(values).Error; err != nil {
+		return nil, err
+	}
+	return c.GetComment(id)
+}
+
+// GetFlaggedComments fetches the comments of the site with flags, the most
+// flagged first, with the number of flags for each reason
+func (c SqliteCommentStore) GetFlaggedComments() ([]*Comment, error) {
+	comments := []*Comment{}
+	err := c.scoped().
+		Where("flag_count > 0").
+		Order("flag_count desc").
+		Order("id").
+		Find(&comments).Error
+	if err != nil || len(comments) == 0 {
+		return comments, err
+	}
+
+	byID := make(map[uint]*Comment, len(comments))
+	ids := make([]uint, 0, len(comments))
+	for _, comment := range comments {
+		comment.FlagReasons = map[string]int{}
+		byID[*comment.ID] = comment
+		ids = append(ids, *comment.ID)
+	}
+
+	rows, err := c.DB.Model(&Flag{}).
+		Select("comment_id, reason, count(*)").
+		Where("comment_id IN (?)", ids).
+		Group("comment_id, reason").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var reason string
+		var count int
+		if err := rows.Scan(&id, &reason, &count); err != nil {
+			return nil, err
+		}
+		byID[id].FlagReasons[reason] = count
+	}
+
+	return comments, rows.Err()
+}
+
+// ClearFlags dismisses the flags of comment with id. The status of the
+// comment is kept, moderators approve held comments separately.
+func (c SqliteCommentStore) ClearFlags(id uint) (*Comment, error) {
+	if _, err := c.GetComment(id); err != nil {
+		return nil, err
+	}
+
+	if err := c.DB.Where("comment_id = ?", id).Delete(&Flag{}).Error; err != nil {
+		return nil, err
+	}
+
+	err := c.scoped().Model(&Comment{ID: &id}).UpdateColumns(map[string]interface{}{
+		"flag_count": 0,
+		"version":    gorm.Expr("version + 1"),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return c.GetComment(id)
+}
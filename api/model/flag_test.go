@@ -0,0 +1,89 @@
+package model
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestFlags(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := SqliteCommentStore{DB: db, FlagThreshold: 2}
+	url := "http://example.com/post/1"
+
+	first, _ := commenter.CreateComment(&Comment{Content: "Some content", URL: url, Status: StatusApproved})
+	second, _ := commenter.CreateComment(&Comment{Content: "Other content", URL: url, Status: StatusApproved})
+	commenter.CreateComment(&Comment{Content: "Unflagged content", URL: url, Status: StatusApproved})
+
+	if _, err := commenter.FlagComment(*first.ID, "voter-1", "boring"); err != ErrInvalidFlagReason {
+		t.Errorf("FlagComment() expected invalid reason error, got %v", err)
+	}
+
+	comment, err := commenter.FlagComment(*first.ID, "voter-1", FlagSpam)
+	if err != nil {
+		t.Fatalf("FlagComment() error = %v", err)
+	} else if comment.FlagCount != 1 || comment.Status != StatusApproved || comment.Version != first.Version+1 {
+		t.Errorf("FlagComment() expected one flag, got %+v", comment)
+	}
+
+	if comment, _ := commenter.FlagComment(*first.ID, "voter-1", FlagAbuse); comment.FlagCount != 1 {
+		t.Errorf("FlagComment() expected repeated flags of a reporter to be ignored, got %v flags", comment.FlagCount)
+	}
+
+	comment, _ = commenter.FlagComment(*first.ID, "voter-2", FlagSpam)
+	if comment.FlagCount != 2 || comment.Status != StatusPending {
+		t.Errorf("FlagComment() expected comment to be held at the threshold, got %v flags and status %v", comment.FlagCount, comment.Status)
+	}
+
+	commenter.FlagComment(*second.ID, "voter-1", FlagOffTopic)
+
+	if _, err := commenter.ForSite(1).FlagComment(*second.ID, "voter-2", FlagSpam); err == nil {
+		t.Errorf("FlagComment() expected comments of other sites to not be found")
+	}
+
+	flagged, err := commenter.GetFlaggedComments()
+	if err != nil {
+		t.Fatalf("GetFlaggedComments() error = %v", err)
+	} else if len(flagged) != 2 || *flagged[0].ID != *first.ID || *flagged[1].ID != *second.ID {
+		t.Fatalf("GetFlaggedComments() expected flagged comments sorted by flags, got %v comments", len(flagged))
+	} else if !reflect.DeepEqual(flagged[0].FlagReasons, map[string]int{FlagSpam: 2}) {
+		t.Errorf("GetFlaggedComments() expected flags by reason, got %v", flagged[0].FlagReasons)
+	}
+
+	comment, err = commenter.ClearFlags(*first.ID)
+	if err != nil {
+		t.Fatalf("ClearFlags() error = %v", err)
+	} else if comment.FlagCount != 0 || comment.Status != StatusPending {
+		t.Errorf("ClearFlags() expected flags to be cleared and status kept, got %+v", comment)
+	}
+
+	if comment, _ := commenter.FlagComment(*first.ID, "voter-1", FlagSpam); comment.FlagCount != 1 {
+		t.Errorf("FlagComment() expected reporters to flag again after clearing, got %v flags", comment.FlagCount)
+	}
+
+	// Approving a held comment dismisses the flags that held it
+	commenter.FlagComment(*first.ID, "voter-2", FlagSpam)
+	held, _ := commenter.GetComment(*first.ID)
+	held.Status = StatusApproved
+	comment, err = commenter.UpdateComment(held)
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	} else if comment.FlagCount != 0 || comment.Status != StatusApproved {
+		t.Errorf("UpdateComment() expected approving to clear flags, got %v flags and status %v", comment.FlagCount, comment.Status)
+	}
+
+	if comment, _ := commenter.FlagComment(*first.ID, "voter-3", FlagSpam); comment.FlagCount != 1 || comment.Status != StatusApproved {
+		t.Errorf("FlagComment() expected an approved comment to stay approved below the threshold, got %v flags and status %v", comment.FlagCount, comment.Status)
+	}
+}
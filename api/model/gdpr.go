@@ -0,0 +1,301 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GDPRStore answers data subject requests, letting people export or erase
+// all data stored about them by email address
+type GDPRStore interface {
+	CreateDataRequest(*DataRequest, time.Duration) (string, error)
+	ConfirmDataRequest(string) (*DataRequest, error)
+	ExportData(string, []string) (*DataExport, error)
+	EraseData(string, []string) (*DataExport, error)
+}
+
+// Data request actions
+const (
+	DataExportAction = "export"
+	DataEraseAction  = "erase"
+)
+
+// ErrInvalidDataAction is returned when requesting an unknown action
+var ErrInvalidDataAction = errors.New("Action must be export or erase")
+
+// DataRequest is a request to export or erase the data of an email address,
+// which is only carried out once confirmed with the token sent to it. Only a
+// hash of the token is stored.
+type DataRequest struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Email     string    `json:"email"`
+	Action    string    `json:"action"`
+	TokenHash string    `json:"-" gorm:"unique_index"`
+}
+
+// DataExport holds the data stored about a person. Reactions, flags and audit
+// entries are found by the user account of the person, as others may share
+// the ip addresses their comments were posted from. Reactions of the given
+// voters are included too.
+type DataExport struct {
+	Email      string      `json:"email"`
+	ExportedAt time.Time   `json:"exportedAt"`
+	User       *User       `json:"user"`
+	Identities []*Identity `json:"identities"`
+	Comments   []*Comment  `json:"comments"`
+	// Clients lists where each comment was posted from
+	Clients      []*ClientInfo `json:"clients"`
+	Reactions    []*Reaction   `json:"reactions"`
+	Flags        []*Flag       `json:"flags"`
+	Bans         []*Ban        `json:"bans"`
+	AuditEntries []*AuditEntry `json:"auditEntries"`
+}
+
+// readers returns the names flags and audit entries give the account of the
+// person of export, see Router.reader
+func (export *DataExport) readers() []string {
+	if export.User == nil {
+		return []string{}
+	}
+	return []string{fmt.Sprintf("user:%d", export.User.ID)}
+}
+
+// SqliteGDPRStore implements a gorm based data subject request store
+type SqliteGDPRStore struct {
+	DB *gorm.DB
+}
+
+// CreateDataRequest stores request valid for ttl and returns the token
+// confirming it. Requests that expired unconfirmed are removed.
+func (s SqliteGDPRStore) CreateDataRequest(request *DataRequest, ttl time.Duration) (string, error) {
+	request.Email = strings.ToLower(strings.TrimSpace(request.Email))
+
+	if !validEmail(request.Email) {
+		return "", ErrInvalidEmail
+	} else if request.Action != DataExportAction && request.Action != DataEraseAction {
+		return "", ErrInvalidDataAction
+	}
+
+	if err := s.DB.Where("expires_at <= ?", time.Now()).Delete(&DataRequest{}).Error; err != nil {
+		return "", err
+	}
+
+	token, err := NewAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	request.ExpiresAt = time.Now().Add(ttl)
+	request.TokenHash = hashToken(token)
+	return token, s.DB.Create(request).Error
+}
+
+// ConfirmDataRequest returns the request confirmed by token. Tokens can
+// only be used once, and expired requests are not found.
+func (s SqliteGDPRStore) ConfirmDataRequest(token string) (*DataRequest, error) {
+	if token == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	request := DataRequest{}
+	err := s.DB.Where("token_hash = ? AND expires_at > ?", hashToken(token), time.Now()).First(&request).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, s.DB.Delete(&request).Error
+}
+
+// ExportData collects the comments of all sites posted with email or by the
+// user registered with it, the user and their identities, the reactions of
+// voters, and the flags, bans and audit entries naming the person. Deleted
+// comments are included until purged.
+func (s SqliteGDPRStore) ExportData(email string, voters []string) (*DataExport, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	export := &DataExport{
+		Email:        email,
+		ExportedAt:   time.Now(),
+		Identities:   []*Identity{},
+		Comments:     []*Comment{},
+		Clients:      []*ClientInfo{},
+		Reactions:    []*Reaction{},
+		Flags:        []*Flag{},
+		Bans:         []*Ban{},
+		AuditEntries: []*AuditEntry{},
+	}
+
+	user := &User{}
+	if err := s.DB.Where("email = ?", email).First(user).Error; err == nil {
+		export.User = user
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	comments := s.DB.Unscoped().Where("lower(email) = ?", email)
+	if export.User != nil {
+		comments = comments.Or("user_id = ?", export.User.ID)
+
+		if err := s.DB.Where("user_id = ?", export.User.ID).Find(&export.Identities).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := comments.Order("id").Find(&export.Comments).Error; err != nil {
+		return nil, err
+	}
+
+	for _, comment := range export.Comments {
+		export.Clients = append(export.Clients, comment.clientInfo())
+	}
+
+	readers := export.readers()
+	if voters = append(readers, voters...); len(voters) > 0 {
+		if err := s.DB.Where("voter IN (?)", voters).Order("id").Find(&export.Reactions).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if len(readers) > 0 {
+		if err := s.DB.Where("reporter IN (?)", readers).Order("id").Find(&export.Flags).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.bans(export).Order("id").Find(&export.Bans).Error; err != nil {
+		return nil, err
+	}
+
+	if audit := s.auditEntries(export); audit != nil {
+		if err := audit.Order("id").Find(&export.AuditEntries).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return export, nil
+}
+
+// bans selects the bans of all sites naming the email address, username, or
+// ip addresses of the person of export
+func (s SqliteGDPRStore) bans(export *DataExport) *gorm.DB {
+	db := s.DB.Where("kind = ? AND value = ?", BanEmail, export.Email)
+	if export.User != nil {
+		db = db.Or("kind = ? AND value = ?", BanUsername, strings.ToLower(export.User.Username))
+	}
+
+	networks := []string{}
+	for _, client := range export.Clients {
+		ban := &Ban{Kind: BanIP, Value: client.ClientIP}
+		if client.ClientIP != "" && ban.normalize() == nil {
+			networks = append(networks, ban.Value)
+		}
+	}
+	if len(networks) > 0 {
+		db = db.Or("kind = ? AND value IN (?)", BanIP, networks)
+	}
+
+	return db
+}
+
+// auditEntries selects the audit entries of all sites recorded for the
+// person of export or for bans naming them, nil if there are none
+func (s SqliteGDPRStore) auditEntries(export *DataExport) *gorm.DB {
+	readers := export.readers()
+	ids := make([]uint, len(export.Bans))
+	for i, ban := range export.Bans {
+		ids[i] = ban.ID
+	}
+
+	switch {
+	case len(readers) > 0 && len(ids) > 0:
+		return s.DB.Where("actor IN (?) OR (target_type = ? AND target_id IN (?))", readers, AuditTargetBan, ids)
+	case len(readers) > 0:
+		return s.DB.Where("actor IN (?)", readers)
+	case len(ids) > 0:
+		return s.DB.Where("target_type = ? AND target_id IN (?)", AuditTargetBan, ids)
+	}
+	return nil
+}
+
+// EraseData purges everything ExportData finds and returns what was erased.
+// Comments with replies are stripped of their content and author and kept
+// as tombstones, so the discussion around them stays intact. Audit entries
+// are kept without their snapshots, see redactAudit. Bans are kept too, so
+// erasing cannot be used to lift them.
+func (s SqliteGDPRStore) EraseData(email string, voters []string) (*DataExport, error) {
+	export, err := s.ExportData(email, voters)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := s.DB.Begin()
+	if err := (SqliteGDPRStore{DB: tx}).erase(export); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return export, tx.Commit().Error
+}
+
+// erase removes the data of export, see EraseData
+func (s SqliteGDPRStore) erase(export *DataExport) error {
+	comments := SqliteCommentStore{DB: s.DB}
+
+	// Replies are purged before their parents, so parents left without
+	// replies are removed entirely
+	for i := len(export.Comments) - 1; i >= 0; i-- {
+		if err := comments.purge(export.Comments[i]); err != nil {
+			return err
+		}
+	}
+
+	// Purging redacted the audit entries of the erased comments, the entries
+	// recorded for the person also lose the actor naming them
+	readers := export.readers()
+	for _, entry := range export.AuditEntries {
+		if entry.TargetType == AuditTargetBan {
+			continue
+		} else if err := redactAudit(s.DB, readers, "id = ?", entry.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, flag := range export.Flags {
+		if err := s.DB.Delete(flag).Error; err != nil {
+			return err
+		}
+		err := s.DB.Unscoped().Model(&Comment{ID: &flag.CommentID}).UpdateColumns(map[string]interface{}{
+			"flag_count": gorm.Expr("flag_count - 1"),
+			"version":    gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, reaction := range export.Reactions {
+		if err := s.DB.Delete(reaction).Error; err != nil {
+			return err
+		}
+		if err := s.DB.Unscoped().Model(&Comment{ID: &reaction.CommentID}).UpdateColumn("version", gorm.Expr("version + 1")).Error; err != nil {
+			return err
+		}
+	}
+
+	if user := export.User; user != nil {
+		for _, value := range []interface{}{&Session{}, &Identity{}} {
+			if err := s.DB.Where("user_id = ?", user.ID).Delete(value).Error; err != nil {
+				return err
+			}
+		}
+		if err := s.DB.Delete(user).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
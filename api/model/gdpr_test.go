@@ -0,0 +1,185 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestDataRequests(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	gdpr := &SqliteGDPRStore{DB: db}
+
+	if _, err := gdpr.CreateDataRequest(&DataRequest{Email: "alice", Action: DataExportAction}, time.Hour); err != ErrInvalidEmail {
+		t.Errorf("CreateDataRequest() expected ErrInvalidEmail, got %v", err)
+	}
+
+	if _, err := gdpr.CreateDataRequest(&DataRequest{Email: "alice@example.com", Action: "delete"}, time.Hour); err != ErrInvalidDataAction {
+		t.Errorf("CreateDataRequest() expected ErrInvalidDataAction, got %v", err)
+	}
+
+	token, err := gdpr.CreateDataRequest(&DataRequest{Email: "Alice@Example.com", Action: DataEraseAction}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateDataRequest() error = %v", err)
+	}
+
+	expired, _ := gdpr.CreateDataRequest(&DataRequest{Email: "alice@example.com", Action: DataExportAction}, -time.Hour)
+
+	request, err := gdpr.ConfirmDataRequest(token)
+	if err != nil {
+		t.Fatalf("ConfirmDataRequest() error = %v", err)
+	} else if request.Email != "alice@example.com" || request.Action != DataEraseAction {
+		t.Errorf("ConfirmDataRequest() wanted erase request for alice@example.com, got %+v", request)
+	}
+
+	if _, err := gdpr.ConfirmDataRequest(token); err == nil {
+		t.Errorf("ConfirmDataRequest() expected token to only be usable once")
+	}
+
+	if _, err := gdpr.ConfirmDataRequest(expired); err == nil {
+		t.Errorf("ConfirmDataRequest() expected expired request to be rejected")
+	}
+
+	gdpr.CreateDataRequest(&DataRequest{Email: "bob@example.com", Action: DataExportAction}, time.Hour)
+	requests := 0
+	db.Model(&DataRequest{}).Where("expires_at <= ?", time.Now()).Count(&requests)
+	if requests != 0 {
+		t.Errorf("CreateDataRequest() expected expired requests to be removed, found %v", requests)
+	}
+}
+
+func TestExportAndEraseData(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	users := &SqliteUserStore{DB: db}
+	gdpr := &SqliteGDPRStore{DB: db}
+	url := "http://example.com/post/1"
+
+	alice, _ := users.CreateUser(&User{Username: "alice", Email: "alice@example.com"}, "correct horse")
+	users.LinkIdentity(&Identity{Provider: "https://id.example.com", Subject: "alice-id"}, &User{Email: "alice@example.com", EmailVerified: true})
+	users.CreateSession(alice, time.Hour)
+
+	guest, _ := commenter.CreateComment(&Comment{Content: "Posted as a guest", Email: "Alice@example.com", URL: url, ClientIP: "192.0.2.1", ClientIPHash: "alice-ip"})
+	reply, _ := commenter.CreateComment(&Comment{Content: "Reply by bob", Email: "bob@example.com", URL: url, ParentID: *guest.ID})
+	loggedIn, _ := commenter.ForSite(2).CreateComment(&Comment{Content: "Posted while logged in", URL: url, UserID: alice.ID})
+	deleted, _ := commenter.CreateComment(&Comment{Content: "Deleted comment", Email: "alice@example.com", URL: url})
+	commenter.DeleteComment(&Comment{ID: deleted.ID})
+
+	commenter.AddReaction(*reply.ID, "alice-voter", "like")
+	commenter.AddReaction(*reply.ID, "bob-voter", "like")
+
+	commenter.FlagComment(*reply.ID, "ip:alice-ip", FlagSpam)
+	commenter.FlagComment(*reply.ID, fmt.Sprintf("user:%d", alice.ID), FlagAbuse)
+	commenter.FlagComment(*reply.ID, "ip:bob-ip", FlagSpam)
+
+	bans := SqliteBanStore{DB: db}
+	emailBan, _ := bans.CreateBan(&Ban{Kind: BanEmail, Value: "alice@example.com"})
+	bans.CreateBan(&Ban{Kind: BanIP, Value: "192.0.2.1"})
+	bans.CreateBan(&Ban{Kind: BanEmail, Value: "bob@example.com"})
+
+	audit := SqliteAuditStore{DB: db}
+	audit.RecordAudit(&AuditEntry{Actor: fmt.Sprintf("user:%d", alice.ID), Action: AuditEdit, TargetType: AuditTargetComment, TargetID: *guest.ID, Before: Snapshot(guest)})
+	audit.RecordAudit(&AuditEntry{Actor: "admin", Action: AuditBan, TargetType: AuditTargetBan, TargetID: emailBan.ID, After: Snapshot(emailBan)})
+	audit.RecordAudit(&AuditEntry{Actor: "admin", Action: AuditPin, TargetType: AuditTargetComment, TargetID: *reply.ID, After: Snapshot(reply)})
+
+	export, err := gdpr.ExportData("alice@example.com", []string{"alice-voter"})
+	if err != nil {
+		t.Fatalf("ExportData() error = %v", err)
+	}
+
+	if export.User == nil || export.User.ID != alice.ID || len(export.Identities) != 1 {
+		t.Errorf("ExportData() expected user alice with 1 identity, got %+v and %v", export.User, export.Identities)
+	}
+
+	if len(export.Comments) != 3 || *export.Comments[0].ID != *guest.ID || *export.Comments[1].ID != *loggedIn.ID || *export.Comments[2].ID != *deleted.ID {
+		t.Errorf("ExportData() expected comments of alice on all sites, got %v comments", len(export.Comments))
+	}
+
+	if len(export.Reactions) != 1 || export.Reactions[0].Voter != "alice-voter" {
+		t.Errorf("ExportData() expected reaction of alice, got %v", export.Reactions)
+	}
+
+	// Others may share the ip address of alice, so only the account counts
+	if len(export.Flags) != 1 || export.Flags[0].Reason != FlagAbuse {
+		t.Errorf("ExportData() expected the flag of alice, got %v", export.Flags)
+	}
+
+	if len(export.Bans) != 2 || export.Bans[0].Value != "alice@example.com" || export.Bans[1].Value != "192.0.2.1/32" {
+		t.Errorf("ExportData() expected bans of alice, got %v", export.Bans)
+	}
+
+	if len(export.AuditEntries) != 2 || export.AuditEntries[0].Action != AuditEdit || export.AuditEntries[1].TargetID != emailBan.ID {
+		t.Errorf("ExportData() expected audit entries of alice, got %v", export.AuditEntries)
+	}
+
+	if _, err := gdpr.EraseData("alice@example.com", []string{"alice-voter"}); err != nil {
+		t.Fatalf("EraseData() error = %v", err)
+	}
+
+	if export, _ := gdpr.ExportData("alice@example.com", []string{"alice-voter"}); export.User != nil || len(export.Comments) != 0 || len(export.Reactions) != 0 {
+		t.Errorf("EraseData() expected nothing left to export, got %+v", export)
+	}
+
+	flags := []*Flag{}
+	db.Find(&flags)
+	if len(flags) != 2 || flags[0].Reporter != "ip:alice-ip" || flags[1].Reporter != "ip:bob-ip" {
+		t.Errorf("EraseData() expected only the flags of the account of alice to be removed, got %v", flags)
+	}
+
+	// Erasing cannot lift bans
+	if remaining, _ := bans.GetBans(); len(remaining) != 3 {
+		t.Errorf("EraseData() expected bans to be kept, got %v", remaining)
+	}
+
+	entries, _ := audit.GetAuditEntries(AuditQuery{})
+	if len(entries) != 3 {
+		t.Fatalf("EraseData() expected audit entries to be kept, got %v", entries)
+	}
+	for _, entry := range entries {
+		erased := entry.TargetType == AuditTargetComment && entry.TargetID == *guest.ID
+		if erased && (entry.Actor != ErasedActor || entry.Before != nil || entry.After != nil) {
+			t.Errorf("EraseData() expected audit entry to be redacted, got %+v", entry)
+		} else if !erased && (entry.Actor != "admin" || entry.After == nil) {
+			t.Errorf("EraseData() expected audit entry by admin to be kept, got %+v", entry)
+		}
+	}
+
+	comments, _ := commenter.GetComments(url, "")
+	if len(comments) != 2 || !comments[0].Deleted || comments[0].Content != "" || comments[1].Content != "Reply by bob" {
+		t.Errorf("EraseData() expected comment with reply to be kept as tombstone, got %v comments", len(comments))
+	}
+
+	if comment, _ := commenter.GetComment(*reply.ID); comment.Version != 8 || comment.FlagCount != 2 {
+		t.Errorf("EraseData() expected removing reactions and flags to change the version and flag count, got %v and %v", comment.Version, comment.FlagCount)
+	}
+
+	if _, err := users.GetUser(alice.ID); err == nil {
+		t.Errorf("EraseData() expected user account to be removed")
+	}
+
+	sessions := 0
+	db.Model(&Session{}).Where("user_id = ?", alice.ID).Count(&sessions)
+	if sessions != 0 {
+		t.Errorf("EraseData() expected sessions to be removed, found %v", sessions)
+	}
+}
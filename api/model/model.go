@@ -2,7 +2,7 @@ package model
 
 import (
 	"errors"
-	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,13 +11,50 @@ import (
 
 // CommentStore exposes common methods to create, get, update, and delete comments
 type CommentStore interface {
-	GetComments(string) ([]*Comment, error)
+	GetComments(string, string) ([]*Comment, error)
 	GetComment(uint) (*Comment, error)
 	CreateComment(*Comment) (*Comment, error)
 	UpdateComment(*Comment) (*Comment, error)
 	DeleteComment(*Comment) (*Comment, error)
+	PurgeComment(uint) error
+	PinComment(uint, bool) (*Comment, error)
+	FeatureComment(uint, bool) (*Comment, error)
+	CountComments([]string) (map[string]int, error)
+	GetClientInfo(uint) (*ClientInfo, error)
+	SearchComments(CommentQuery) ([]*Comment, error)
+	ForSite(uint) CommentStore
+	ThreadStore
+	ReactionStore
+	FlagStore
 }
 
+// Comment statuses used by the comment store
+const (
+	StatusApproved = "Approved"
+	StatusPending  = "Pending"
+)
+
+// Comment sort orders, pinned comments are always sorted first
+const (
+	SortOldest = "oldest"
+	SortNewest = "newest"
+	SortTop    = "top"
+)
+
+// sortOrders maps sort orders to their sql order clause
+var sortOrders = map[string]string{
+	SortOldest: "created_at asc",
+	SortNewest: "created_at desc",
+	SortTop:    "(upvotes - downvotes) desc, created_at asc",
+}
+
+// ErrVersionConflict is returned when updating or deleting a comment that
+// was changed since the version the caller expected
+var ErrVersionConflict = errors.New("Comment was modified since it was fetched")
+
+// ErrInvalidSort is returned when fetching comments with an unknown sort order
+var ErrInvalidSort = errors.New("Sort must be oldest, newest or top")
+
 // Comment represents a user comment
 type Comment struct {
 	ID        *uint      `json:"id" gorm:"primary_key"`
@@ -27,85 +64,466 @@ type Comment struct {
 	ParentID  uint       `json:"parentId"`
 	Username  string     `json:"username"`
 	Email     string     `json:"email"`
+	Avatar    string     `json:"avatar"`
 	Content   string     `json:"content"`
 	Upvotes   int        `json:"upvotes"`
 	Downvotes int        `json:"downvotes"`
 	Status    string     `json:"status"`
 	URL       string     `json:"url"`
+	ThreadID  uint       `json:"threadId" sql:"index"`
+	SiteID    uint       `json:"siteId" sql:"index"`
+	Pinned    bool       `json:"pinned"`
+	Featured  bool       `json:"featured"`
+
+	// ClientIP and UserAgent tell moderators where a comment was posted
+	// from, stored according to the ClientPolicy and cleared after its
+	// retention period. ClientIPHash matches comments from the same address.
+	ClientIP     string `json:"-"`
+	ClientIPHash string `json:"-" sql:"index"`
+	UserAgent    string `json:"-"`
+
+	// Shadowed comments were posted under a shadow ban and are only shown
+	// to their poster and moderators
+	Shadowed bool `json:"-" sql:"index"`
+
+	// Deleted marks tombstones, deleted comments kept in place of their
+	// replies with their content and author stripped
+	Deleted bool `json:"deleted" gorm:"-"`
+
+	// Depth counts the ancestors of a reply, and Path lists the ids of its
+	// ancestors and itself from the top-level comment down, like 1/5/9
+	Depth uint   `json:"depth"`
+	Path  string `json:"path" sql:"index"`
+
+	// Version is incremented whenever the comment, its flags, or its
+	// reactions change, for optimistic concurrency control
+	Version uint `json:"version" gorm:"not null;default:1"`
+
+	// UserID links comments posted by logged in users to their account, and
+	// Verified marks such comments for display
+	UserID   uint `json:"userId" sql:"index"`
+	Verified bool `json:"verified" gorm:"-"`
+
+	// Reactions counts reactions by kind, and MyReactions lists the kinds the
+	// requesting voter reacted with. Both are filled in by LoadReactions.
+	Reactions   map[string]int `json:"reactions" gorm:"-"`
+	MyReactions []string       `json:"myReactions" gorm:"-"`
+
+	// FlagCount counts the readers who flagged the comment, and FlagReasons
+	// counts their flags by reason for the moderation queue
+	FlagCount   uint           `json:"flagCount" gorm:"not null;default:0"`
+	FlagReasons map[string]int `json:"flagReasons,omitempty" gorm:"-"`
+}
+
+// AfterFind marks comments posted by registered users as verified, and
+// deleted comments as deleted
+func (comment *Comment) AfterFind() error {
+	comment.Verified = comment.UserID != 0
+	comment.Deleted = comment.DeletedAt != nil
+	return nil
 }
 
-// Migrate creates comment table using supplied db instance
+// tombstone strips a deleted comment down to what is needed to place its
+// replies
+func (comment *Comment) tombstone() {
+	comment.Username, comment.Email, comment.Avatar, comment.Content = "", "", "", ""
+	comment.UserID, comment.Verified = 0, false
+	comment.Upvotes, comment.Downvotes = 0, 0
+	comment.Deleted = true
+}
+
+// withTombstones removes deleted comments from comments, except those with
+// replies that are not deleted which are kept as tombstones
+func withTombstones(comments []*Comment) []*Comment {
+	hasReplies := map[string]bool{}
+	for _, comment := range comments {
+		if comment.DeletedAt == nil {
+			ancestors := strings.Split(comment.pathOf(), "/")
+			for _, id := range ancestors[:len(ancestors)-1] {
+				hasReplies[id] = true
+			}
+		}
+	}
+
+	visible := make([]*Comment, 0, len(comments))
+	for _, comment := range comments {
+		if comment.DeletedAt == nil {
+			visible = append(visible, comment)
+		} else if hasReplies[strconv.FormatUint(uint64(*comment.ID), 10)] {
+			comment.tombstone()
+			visible = append(visible, comment)
+		}
+	}
+	return visible
+}
+
+// Migrate creates comment, thread, site, reaction, user, session, identity,
+// and data request tables using supplied db instance
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(&Comment{}).Error
+	return db.AutoMigrate(&Comment{}, &Thread{}, &Site{}, &Reaction{}, &User{}, &Session{}, &Identity{}, &DataRequest{}, &Ban{}, &FilterRule{}, &FilterHit{}, &Flag{}, &AuditEntry{}).Error
 }
 
 // SqliteCommentStore implements a gorm based comment store
 type SqliteCommentStore struct {
 	DB *gorm.DB
+	// Normalizer maps comment urls to threads, DefaultURLNormalizer is used
+	// if nil
+	Normalizer *URLNormalizer
+	// SiteID scopes all queries to a single site, 0 being the default site
+	SiteID uint
+	// MaxDepth caps how deeply replies nest, deeper replies are attached to
+	// their deepest allowed ancestor. Zero allows any depth.
+	MaxDepth uint
+	// FlagThreshold holds comments for moderation once flagged by this many
+	// readers. Zero never holds flagged comments.
+	FlagThreshold uint
 }
 
-// Validate checks if comment contains DB created fields
-func Validate(comment *Comment) error {
-	deniedFields := []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt"}
+// ForSite returns a copy of the store scoped to the site with id siteID
+func (c SqliteCommentStore) ForSite(siteID uint) CommentStore {
+	c.SiteID = siteID
+	return c
+}
 
-	v := reflect.ValueOf(*comment)
+// scoped returns a db handle restricted to comments on the store's site
+func (c SqliteCommentStore) scoped() *gorm.DB {
+	return c.DB.Where("site_id = ?", c.SiteID)
+}
 
-	props := make([]string, 0)
+// GetComments fetches comments in the thread url belongs to from database,
+// pinned comments first followed by the rest in the given sort order. An
+// empty sort sorts the oldest comments first. Deleted comments with replies
+// are included as tombstones.
+func (c SqliteCommentStore) GetComments(url string, sort string) ([]*Comment, error) {
+	comments := []*Comment{}
 
-	for _, property := range deniedFields {
-		if v.FieldByName(property).Pointer() != 0 {
-			props = append(props, property)
-		}
+	if sort == "" {
+		sort = SortOldest
 	}
 
-	if len(props) > 0 {
-		return errors.New("Following properties were not nil: " + strings.Join(props, ", "))
+	order, ok := sortOrders[sort]
+	if !ok {
+		return nil, ErrInvalidSort
 	}
 
-	return nil
-}
+	thread, err := c.GetThread(url)
+	if err == gorm.ErrRecordNotFound {
+		return comments, nil
+	} else if err != nil {
+		return nil, err
+	}
 
-// GetComments fetches comments from database
-func (c SqliteCommentStore) GetComments(url string) ([]*Comment, error) {
-	comments := []*Comment{}
-	return comments, c.DB.Where(&Comment{URL: url}).Find(&comments).Error
+	err = c.scoped().
+		Unscoped().
+		Where(&Comment{ThreadID: thread.ID}).
+		Order("pinned desc").
+		Order(order).
+		Order("id").
+		Find(&comments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return withTombstones(comments), nil
 }
 
 // GetComment fetches comment by id from database
 func (c SqliteCommentStore) GetComment(id uint) (*Comment, error) {
 	comment := Comment{}
-	return &comment, c.DB.First(&comment, id).Error
+	return &comment, c.scoped().First(&comment, id).Error
 }
 
-// CreateComment inserts comment into database
+// CreateComment inserts comment into database in the thread of its url.
+// Replies must reply to a comment in the same thread. The word filter of the
+// site may mask the content, hold the comment for moderation, or reject it.
 func (c SqliteCommentStore) CreateComment(comment *Comment) (*Comment, error) {
-	return comment, c.DB.Create(comment).Error
+	thread, err := c.GetOrCreateThread(comment.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.placeReply(comment, thread); err != nil {
+		return nil, err
+	}
+
+	hits, err := c.applyFilters(comment)
+	if err != nil {
+		return nil, err
+	}
+
+	comment.ThreadID = thread.ID
+	comment.SiteID = c.SiteID
+	comment.Pinned = false
+	comment.Featured = false
+	comment.Verified = comment.UserID != 0
+	comment.Version = 1
+	if err := c.DB.Create(comment).Error; err != nil {
+		return nil, err
+	}
+
+	// The path ends with the id of the comment, which is known once created
+	id := strconv.FormatUint(uint64(*comment.ID), 10)
+	if comment.Path == "" {
+		comment.Path = id
+	} else {
+		comment.Path += "/" + id
+	}
+	if err := c.DB.Model(comment).UpdateColumn("path", comment.Path).Error; err != nil {
+		return nil, err
+	}
+
+	if err := c.recordFilterHits(*comment.ID, hits); err != nil {
+		return nil, err
+	}
+
+	return comment, c.touchThread(thread, comment.CreatedAt)
+}
+
+// editableValues returns the columns of comment users can edit
+func (comment *Comment) editableValues() map[string]interface{} {
+	return map[string]interface{}{
+		"username":  comment.Username,
+		"email":     comment.Email,
+		"content":   comment.Content,
+		"upvotes":   comment.Upvotes,
+		"downvotes": comment.Downvotes,
+		"status":    comment.Status,
+		"url":       comment.URL,
+		"thread_id": comment.ThreadID,
+		"version":   gorm.Expr("version + 1"),
+	}
+}
+
+// missingOrConflict tells why a write to comment with id matched no rows
+func (c SqliteCommentStore) missingOrConflict(id uint, version uint) error {
+	if version == 0 {
+		return gorm.ErrRecordNotFound
+	} else if _, err := c.GetComment(id); err == nil {
+		return ErrVersionConflict
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// versioned restricts db to the expected comment version, any version
+// matches if version is 0
+func versioned(db *gorm.DB, version uint) *gorm.DB {
+	if version == 0 {
+		return db
+	}
+	return db.Where("version = ?", version)
 }
 
-// UpdateComment updates selected comment
+// UpdateComment replaces the editable fields of selected comment, including
+// zero values. Fields managed by the store, like the thread, site, and the
+// user who posted the comment, are kept, and replies cannot be moved to
+// another parent or thread. Moving a comment to another url moves its replies
+// along with it. Edits changing the content pass the word filter
+// like new comments, and approving a comment dismisses its flags. If
+// comment.Version is set the update only succeeds if the stored comment still
+// has that version.
 func (c SqliteCommentStore) UpdateComment(comment *Comment) (*Comment, error) {
+	if comment.ID == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	existing, err := c.GetComment(*comment.ID)
+	if err != nil {
+		return nil, err
+	}
 
-	db := c.DB.Model(comment).Updates(comment)
+	comment.ThreadID = 0
+	if comment.URL != "" {
+		thread, err := c.GetOrCreateThread(comment.URL)
+		if err != nil {
+			return nil, err
+		}
+		comment.ThreadID = thread.ID
+	}
+
+	// Replies stay with their parent
+	if comment.ParentID != existing.ParentID {
+		return nil, errParentChanged
+	} else if comment.ParentID != 0 && comment.ThreadID != existing.ThreadID {
+		return nil, errParentThread
+	}
+
+	// Only changed content passes the word filter again, so moderators can
+	// approve comments the filter held
+	filter := comment.Content != existing.Content
+	var hits []*FilterHit
+	if filter {
+		if hits, err = c.applyFilters(comment); err != nil {
+			return nil, err
+		}
+	}
+
+	// Moderators approving a comment have reviewed its flags, so the flags
+	// cannot hold it for moderation again
+	values := comment.editableValues()
+	approve := comment.Status == StatusApproved && existing.Status != StatusApproved
+	if approve {
+		values["flag_count"] = 0
+	}
+
+	db := versioned(c.scoped(), comment.Version).Model(&Comment{ID: comment.ID}).Updates(values)
 	if db.Error != nil {
 		return nil, db.Error
 	} else if db.RowsAffected == 0 {
-		return nil, gorm.ErrRecordNotFound
-	} else if err := db.Find(comment).Error; err != nil {
-		return nil, err
+		return nil, c.missingOrConflict(*comment.ID, comment.Version)
 	}
-	return comment, nil
+
+	if approve {
+		if err := c.DB.Where("comment_id = ?", *comment.ID).Delete(&Flag{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if comment.ThreadID != existing.ThreadID {
+		if err := c.moveReplies(existing, comment.URL, comment.ThreadID); err != nil {
+			return nil, err
+		} else if err := c.markThreadChanged(existing.ThreadID); err != nil {
+			return nil, err
+		}
+	}
+
+	if filter {
+		if err := c.recordFilterHits(*comment.ID, hits); err != nil {
+			return nil, err
+		}
+	}
+	return c.GetComment(*comment.ID)
+}
+
+// moveReplies moves the replies of comment, deleted or not, to the thread
+// with threadID at url
+func (c SqliteCommentStore) moveReplies(comment *Comment, url string, threadID uint) error {
+	if comment.Path == "" {
+		return nil
+	}
+
+	return c.DB.Unscoped().Model(&Comment{}).
+		Where("site_id = ? AND path LIKE ?", comment.SiteID, comment.Path+"/%").
+		UpdateColumns(map[string]interface{}{
+			"url":        url,
+			"thread_id":  threadID,
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": time.Now(),
+		}).Error
 }
 
-// DeleteComment deletes selected comment
+// DeleteComment deletes selected comment, only if the stored comment still
+// has comment.Version when set. Deleted comments are kept in the database
+// until purged.
 func (c SqliteCommentStore) DeleteComment(comment *Comment) (*Comment, error) {
+	now := time.Now()
 
-	db := c.DB.Delete(comment)
+	// Deleting changes the version, as comments with replies turn into
+	// tombstones
+	db := versioned(c.scoped(), comment.Version).Model(&Comment{ID: comment.ID}).UpdateColumns(map[string]interface{}{
+		"deleted_at": now,
+		"version":    gorm.Expr("version + 1"),
+	})
 
 	if db.Error != nil {
 		return nil, db.Error
 	} else if db.RowsAffected == 0 {
-		return nil, gorm.ErrRecordNotFound
+		return nil, c.missingOrConflict(*comment.ID, comment.Version)
 	}
 
+	comment.DeletedAt = &now
 	return comment, nil
 }
+
+// setCommentFlag sets a moderator controlled flag on comment with id
+func (c SqliteCommentStore) setCommentFlag(id uint, flag string, value bool) (*Comment, error) {
+	comment := &Comment{ID: &id}
+
+	db := c.scoped().Model(comment).UpdateColumns(map[string]interface{}{
+		flag:         value,
+		"version":    gorm.Expr("version + 1"),
+		"updated_at": time.Now(),
+	})
+	if db.Error != nil {
+		return nil, db.Error
+	} else if db.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return c.GetComment(id)
+}
+
+// PinComment pins or unpins comment with id to the top of its thread
+func (c SqliteCommentStore) PinComment(id uint, pinned bool) (*Comment, error) {
+	return c.setCommentFlag(id, "pinned", pinned)
+}
+
+// FeatureComment highlights or unhighlights comment with id as featured
+func (c SqliteCommentStore) FeatureComment(id uint, featured bool) (*Comment, error) {
+	return c.setCommentFlag(id, "featured", featured)
+}
+
+// CountComments counts approved comments in the thread of each url in a
+// single grouped query. Urls without any approved comments are returned with
+// a zero count. Shadowed comments are not counted.
+func (c SqliteCommentStore) CountComments(urls []string) (map[string]int, error) {
+	counts := make(map[string]int, len(urls))
+	keys := make([]string, 0, len(urls))
+	for _, url := range urls {
+		counts[url] = 0
+		keys = append(keys, c.normalizer().Key(url))
+	}
+
+	if len(urls) == 0 {
+		return counts, nil
+	}
+
+	threads := []*Thread{}
+	if err := c.DB.Where("site_id = ? AND key IN (?)", c.SiteID, keys).Find(&threads).Error; err != nil {
+		return nil, err
+	}
+
+	threadIDs := make(map[string]uint, len(threads))
+	ids := make([]uint, 0, len(threads))
+	for _, thread := range threads {
+		id := thread.ID
+		if thread.MergedIntoID != 0 {
+			id = thread.MergedIntoID
+		}
+		threadIDs[thread.Key] = id
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	rows, err := c.scoped().Model(&Comment{}).
+		Select("thread_id, count(*)").
+		Where("thread_id IN (?) AND status = ? AND (shadowed IS NULL OR NOT shadowed)", ids, StatusApproved).
+		Group("thread_id").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	threadCounts := make(map[uint]int, len(ids))
+	for rows.Next() {
+		var id uint
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		threadCounts[id] = count
+	}
+
+	for i, url := range urls {
+		if id, ok := threadIDs[keys[i]]; ok {
+			counts[url] = threadCounts[id]
+		}
+	}
+
+	return counts, rows.Err()
+}
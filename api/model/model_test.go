@@ -115,7 +115,7 @@ func TestGetComments(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if comments, err := commenter.GetComments(tt.url); (err != nil) != tt.wantErr {
+			if comments, err := commenter.GetComments(tt.url, ""); (err != nil) != tt.wantErr {
 				t.Errorf("GetComments() error = %v, wantErr %v", err, tt.wantErr)
 			} else if len(comments) != len(tt.comments) {
 				t.Errorf("GetComments() Expected to find %v comments, found %v", len(tt.comments), len(comments))
@@ -281,6 +281,165 @@ func Test_updateComment(t *testing.T) {
 	}
 }
 
+func Test_updateComment_zeroValues(t *testing.T) {
+
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	parent, _ := commenter.CreateComment(&Comment{Content: "Some content", URL: "http://example.com/post/1"})
+
+	tests := []struct {
+		name   string
+		update func(comment *Comment)
+		check  func(comment *Comment) bool
+	}{
+		{
+			name:   "Clear status",
+			update: func(comment *Comment) { comment.Status = "" },
+			check:  func(comment *Comment) bool { return comment.Status == "" },
+		},
+		{
+			name:   "Reset votes to zero",
+			update: func(comment *Comment) { comment.Upvotes, comment.Downvotes = 0, 0 },
+			check:  func(comment *Comment) bool { return comment.Upvotes == 0 && comment.Downvotes == 0 },
+		},
+		{
+			name:   "Clear username and email",
+			update: func(comment *Comment) { comment.Username, comment.Email = "", "" },
+			check: func(comment *Comment) bool {
+				return comment.Username == "" && comment.Email == ""
+			},
+		},
+		{
+			name:   "Keep fields that are not editable",
+			update: func(comment *Comment) { comment.Pinned, comment.UserID, comment.SiteID = false, 0, 5 },
+			check: func(comment *Comment) bool {
+				return comment.Pinned && comment.UserID == 7 && comment.SiteID == 0
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created, _ := commenter.CreateComment(&Comment{
+				Content:   "Some content all right",
+				Username:  "alice",
+				Email:     "alice@example.com",
+				ParentID:  *parent.ID,
+				Upvotes:   5,
+				Downvotes: 2,
+				Status:    StatusApproved,
+				URL:       "http://example.com/post/1",
+				UserID:    7,
+			})
+			commenter.PinComment(*created.ID, true)
+
+			comment, _ := commenter.GetComment(*created.ID)
+			tt.update(comment)
+
+			if _, err := commenter.UpdateComment(comment); err != nil {
+				t.Fatalf("UpdateComment() error = %v", err)
+			}
+
+			if updated, _ := commenter.GetComment(*created.ID); !tt.check(updated) {
+				t.Errorf("UpdateComment() did not save zero values, got %+v", updated)
+			}
+		})
+	}
+}
+
+func Test_updateComment_moveReplies(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	top, _ := commenter.CreateComment(&Comment{Content: "Some content", URL: "http://example.com/post/1"})
+	reply, _ := commenter.CreateComment(&Comment{Content: "Reply", URL: "http://example.com/post/1", ParentID: *top.ID})
+	nested, _ := commenter.CreateComment(&Comment{Content: "Nested reply", URL: "http://example.com/post/1", ParentID: *reply.ID})
+	other, _ := commenter.CreateComment(&Comment{Content: "Other content", URL: "http://example.com/post/1"})
+	commenter.DeleteComment(&Comment{ID: nested.ID})
+
+	top.URL = "http://example.com/post/2"
+	moved, err := commenter.UpdateComment(top)
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+
+	// The deleted reply moves too, its version already bumped by deleting it
+	for id, version := range map[uint]uint{*reply.ID: 2, *nested.ID: 3} {
+		comment := &Comment{}
+		db.Unscoped().First(comment, id)
+		if comment.URL != top.URL || comment.ThreadID != moved.ThreadID || comment.Version != version {
+			t.Errorf("UpdateComment() expected reply %v to move along, got %+v", id, comment)
+		}
+	}
+
+	if comment, _ := commenter.GetComment(*other.ID); comment.ThreadID == moved.ThreadID {
+		t.Errorf("UpdateComment() expected other comments to stay, got %+v", comment)
+	}
+}
+
+func TestCommentVersions(t *testing.T) {
+
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	comment, _ := commenter.CreateComment(&Comment{Content: "Some content all right", URL: "http://example.com/post/1"})
+	if comment.Version != 1 {
+		t.Errorf("CreateComment() wanted version 1, but got %v", comment.Version)
+	}
+
+	comment, _ = commenter.UpdateComment(&Comment{ID: comment.ID, Content: "Edited", URL: comment.URL, Version: 1})
+	if comment.Version != 2 {
+		t.Errorf("UpdateComment() wanted version 2, but got %v", comment.Version)
+	}
+
+	comment, _ = commenter.PinComment(*comment.ID, true)
+	commenter.AddReaction(*comment.ID, "voter-1", "like")
+	commenter.AddReaction(*comment.ID, "voter-1", "like")
+	comment, _ = commenter.GetComment(*comment.ID)
+	if comment.Version != 4 {
+		t.Errorf("PinComment() and AddReaction() wanted version 4, but got %v", comment.Version)
+	}
+
+	if _, err := commenter.UpdateComment(&Comment{ID: comment.ID, Content: "Outdated edit", URL: comment.URL, Version: 2}); err != ErrVersionConflict {
+		t.Errorf("UpdateComment() expected ErrVersionConflict for outdated version, got %v", err)
+	}
+
+	if _, err := commenter.DeleteComment(&Comment{ID: comment.ID, Version: 3}); err != ErrVersionConflict {
+		t.Errorf("DeleteComment() expected ErrVersionConflict for outdated version, got %v", err)
+	}
+
+	missing := uint(1000)
+	if _, err := commenter.UpdateComment(&Comment{ID: &missing, Content: "Missing", Version: 1}); err != gorm.ErrRecordNotFound {
+		t.Errorf("UpdateComment() expected ErrRecordNotFound for missing comment, got %v", err)
+	}
+
+	if _, err := commenter.DeleteComment(&Comment{ID: comment.ID, Version: 4}); err != nil {
+		t.Errorf("DeleteComment() error = %v", err)
+	}
+}
+
 func Test_deleteComment(t *testing.T) {
 
 	dbname := dbname()
@@ -320,3 +479,156 @@ func Test_deleteComment(t *testing.T) {
 		})
 	}
 }
+
+func TestCountComments(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	commenter.CreateComment(&Comment{
+		Content: "Some content all right",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/1",
+	})
+
+	commenter.CreateComment(&Comment{
+		Content: "More content all right",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/2",
+	})
+
+	commenter.CreateComment(&Comment{
+		Content: "More content for post 2 all right",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/2",
+	})
+
+	commenter.CreateComment(&Comment{
+		Content: "Not yet approved",
+		Status:  "Disapproved",
+		URL:     "http://example.com/post/2",
+	})
+
+	tests := []struct {
+		name    string
+		urls    []string
+		counts  map[string]int
+		wantErr bool
+	}{
+		{
+			name:   "Count comments for no urls",
+			urls:   []string{},
+			counts: map[string]int{},
+		},
+		{
+			name: "Count approved comments for several urls",
+			urls: []string{"http://example.com/post/1", "http://example.com/post/2"},
+			counts: map[string]int{
+				"http://example.com/post/1": 1,
+				"http://example.com/post/2": 2,
+			},
+		},
+		{
+			name: "Count comments for url without comments",
+			urls: []string{"http://example.com/post/3"},
+			counts: map[string]int{
+				"http://example.com/post/3": 0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if counts, err := commenter.CountComments(tt.urls); (err != nil) != tt.wantErr {
+				t.Errorf("CountComments() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !reflect.DeepEqual(counts, tt.counts) {
+				t.Errorf("CountComments() wanted counts = %v, but got counts = %v", tt.counts, counts)
+			}
+		})
+	}
+}
+
+func TestGetComments_pinnedFirst(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	url := "http://example.com/post/1"
+	first, _ := commenter.CreateComment(&Comment{Content: "First", Status: StatusApproved, URL: url})
+	popular, _ := commenter.CreateComment(&Comment{Content: "Popular", Status: StatusApproved, URL: url, Upvotes: 10})
+	clarification, _ := commenter.CreateComment(&Comment{Content: "Clarification", Status: StatusApproved, URL: url, Pinned: true})
+
+	if clarification.Pinned {
+		t.Errorf("CreateComment() expected client supplied pinned flag to be ignored")
+	}
+
+	if _, err := commenter.PinComment(*clarification.ID, true); err != nil {
+		t.Fatalf("PinComment() error = %v", err)
+	}
+
+	if featured, err := commenter.FeatureComment(*popular.ID, true); err != nil || !featured.Featured {
+		t.Errorf("FeatureComment() expected featured comment, got %v with error %v", featured, err)
+	}
+
+	if _, err := commenter.PinComment(1000, true); err == nil {
+		t.Errorf("PinComment() expected error for comment that does not exist")
+	}
+
+	tests := []struct {
+		name    string
+		sort    string
+		ids     []uint
+		wantErr bool
+	}{
+		{
+			name: "Oldest first with pinned comment on top",
+			sort: SortOldest,
+			ids:  []uint{*clarification.ID, *first.ID, *popular.ID},
+		},
+		{
+			name: "Newest first with pinned comment on top",
+			sort: SortNewest,
+			ids:  []uint{*clarification.ID, *popular.ID, *first.ID},
+		},
+		{
+			name: "Top voted first with pinned comment on top",
+			sort: SortTop,
+			ids:  []uint{*clarification.ID, *popular.ID, *first.ID},
+		},
+		{
+			name:    "Unknown sort order",
+			sort:    "random",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments, err := commenter.GetComments(url, tt.sort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetComments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			ids := make([]uint, 0)
+			for _, comment := range comments {
+				ids = append(ids, *comment.ID)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("GetComments() wanted order %v, but got %v", tt.ids, ids)
+			}
+		})
+	}
+}
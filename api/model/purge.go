@@ -0,0 +1,101 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PurgeComment permanently removes selected comment and its reactions,
+// whether it was deleted already or not. Comments with replies are stripped
+// of their content and author instead, so their replies keep their place.
+func (c SqliteCommentStore) PurgeComment(id uint) error {
+	comment := &Comment{}
+	if err := c.scoped().Unscoped().First(comment, id).Error; err != nil {
+		return err
+	}
+	return c.purge(comment)
+}
+
+// PurgeDeleted purges the comments of all sites deleted before cutoff, and
+// returns the number of comments purged. Tombstones stripped by earlier runs
+// are left alone until their replies are gone.
+func (c SqliteCommentStore) PurgeDeleted(cutoff time.Time) (int, error) {
+	comments := []*Comment{}
+
+	// Replies are purged before their parents, so parents left without
+	// replies are removed entirely
+	err := c.DB.Unscoped().
+		Where("deleted_at < ?", cutoff).
+		Order("depth desc").
+		Order("id desc").
+		Find(&comments).Error
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, comment := range comments {
+		// Comments are required to have content, so comments without it
+		// were purged already
+		if comment.Content == "" {
+			if replies, err := c.countReplies(*comment.ID); err != nil {
+				return 0, err
+			} else if replies > 0 {
+				continue
+			}
+		}
+
+		if err := c.purge(comment); err != nil {
+			return 0, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// countReplies counts the replies to comment with id, deleted or not
+func (c SqliteCommentStore) countReplies(id uint) (int, error) {
+	replies := 0
+	return replies, c.DB.Unscoped().Model(&Comment{}).Where("parent_id = ?", id).Count(&replies).Error
+}
+
+func (c SqliteCommentStore) purge(comment *Comment) error {
+	for _, value := range []interface{}{&Reaction{}, &FilterHit{}, &Flag{}} {
+		if err := c.DB.Where("comment_id = ?", *comment.ID).Delete(value).Error; err != nil {
+			return err
+		}
+	}
+
+	// Audit entries are kept, but not the snapshots of what was purged
+	if err := redactAudit(c.DB, nil, "target_type = ? AND target_id = ?", AuditTargetComment, *comment.ID); err != nil {
+		return err
+	}
+
+	if replies, err := c.countReplies(*comment.ID); err != nil {
+		return err
+	} else if replies == 0 {
+		return c.DB.Unscoped().Delete(&Comment{ID: comment.ID}).Error
+	}
+
+	deletedAt := time.Now()
+	if comment.DeletedAt != nil {
+		deletedAt = *comment.DeletedAt
+	}
+
+	return c.DB.Unscoped().Model(&Comment{ID: comment.ID}).UpdateColumns(map[string]interface{}{
+		"username":       "",
+		"email":          "",
+		"avatar":         "",
+		"content":        "",
+		"user_id":        0,
+		"upvotes":        0,
+		"downvotes":      0,
+		"client_ip":      "",
+		"client_ip_hash": "",
+		"user_agent":     "",
+		"deleted_at":     deletedAt,
+		"version":        gorm.Expr("version + 1"),
+	}).Error
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestTombstones(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	url := "http://example.com/post/1"
+
+	top, _ := commenter.CreateComment(&Comment{Content: "Top-level comment", Username: "alice", URL: url})
+	reply, _ := commenter.CreateComment(&Comment{Content: "Reply", URL: url, ParentID: *top.ID})
+	nested, _ := commenter.CreateComment(&Comment{Content: "Nested reply", URL: url, ParentID: *reply.ID})
+	other, _ := commenter.CreateComment(&Comment{Content: "Another top-level comment", URL: url})
+	otherReply, _ := commenter.CreateComment(&Comment{Content: "Another reply", URL: url, ParentID: *other.ID})
+
+	for _, comment := range []*Comment{top, reply, other, otherReply} {
+		if _, err := commenter.DeleteComment(&Comment{ID: comment.ID}); err != nil {
+			t.Fatalf("DeleteComment() error = %v", err)
+		}
+	}
+
+	comments, err := commenter.GetComments(url, "")
+	if err != nil {
+		t.Fatalf("GetComments() error = %v", err)
+	} else if len(comments) != 3 {
+		t.Fatalf("GetComments() expected deleted comments without replies to be left out, got %v comments", len(comments))
+	}
+
+	for i, id := range []uint{*top.ID, *reply.ID} {
+		if *comments[i].ID != id || !comments[i].Deleted || comments[i].Content != "" || comments[i].Username != "" {
+			t.Errorf("GetComments() expected tombstone for comment %v, got %+v", id, comments[i])
+		}
+	}
+
+	if *comments[2].ID != *nested.ID || comments[2].Deleted || comments[2].Content != "Nested reply" {
+		t.Errorf("GetComments() expected nested reply, got %+v", comments[2])
+	}
+
+	if _, err := commenter.GetComment(*top.ID); err == nil {
+		t.Errorf("GetComment() expected deleted comment to not be found")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	url := "http://example.com/post/1"
+
+	top, _ := commenter.CreateComment(&Comment{Content: "Top-level comment", Username: "alice", Email: "alice@example.com", URL: url})
+	reply, _ := commenter.CreateComment(&Comment{Content: "Reply", URL: url, ParentID: *top.ID})
+	other, _ := commenter.CreateComment(&Comment{Content: "Another top-level comment", URL: url})
+	commenter.AddReaction(*other.ID, "voter-1", "like")
+	audit := SqliteAuditStore{DB: db}
+	audit.RecordAudit(&AuditEntry{Actor: "admin", Action: AuditEdit, TargetType: AuditTargetComment, TargetID: *top.ID, Before: Snapshot(top), After: Snapshot(top)})
+
+	if err := commenter.PurgeComment(*top.ID); err != nil {
+		t.Fatalf("PurgeComment() error = %v", err)
+	}
+
+	if err := commenter.PurgeComment(*other.ID); err != nil {
+		t.Fatalf("PurgeComment() error = %v", err)
+	}
+
+	if err := commenter.PurgeComment(1000); err == nil {
+		t.Errorf("PurgeComment() expected error purging missing comment")
+	}
+
+	scrubbed := &Comment{}
+	if err := db.Unscoped().First(scrubbed, *top.ID).Error; err != nil {
+		t.Errorf("PurgeComment() expected comment with replies to be kept, got %v", err)
+	} else if scrubbed.DeletedAt == nil || scrubbed.Content != "" || scrubbed.Username != "" || scrubbed.Email != "" {
+		t.Errorf("PurgeComment() expected comment with replies to be stripped, got %+v", scrubbed)
+	}
+
+	if err := db.Unscoped().First(&Comment{}, *other.ID).Error; err == nil {
+		t.Errorf("PurgeComment() expected comment without replies to be removed")
+	}
+
+	reactions := 0
+	db.Model(&Reaction{}).Where("comment_id = ?", *other.ID).Count(&reactions)
+	if reactions != 0 {
+		t.Errorf("PurgeComment() expected reactions to be removed, found %v", reactions)
+	}
+
+	if entries, _ := audit.GetAuditEntries(AuditQuery{}); len(entries) != 1 || entries[0].Before != nil || entries[0].After != nil {
+		t.Errorf("PurgeComment() expected audit entries to be kept without snapshots, got %+v", entries)
+	}
+
+	// Tombstones are purged once, not again on every run
+	kept, _ := commenter.CreateComment(&Comment{Content: "Kept reply", URL: url, ParentID: *reply.ID})
+	commenter.DeleteComment(&Comment{ID: reply.ID})
+	if n, err := commenter.PurgeDeleted(time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("PurgeDeleted() = %v, %v, expected the reply to be stripped", n, err)
+	}
+	stripped := &Comment{}
+	db.Unscoped().First(stripped, *reply.ID)
+	if n, err := commenter.PurgeDeleted(time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeDeleted() = %v, %v, expected stripped tombstones to be left alone", n, err)
+	} else if again := (&Comment{}); db.Unscoped().First(again, *reply.ID).Error != nil || again.Version != stripped.Version {
+		t.Errorf("PurgeDeleted() expected stripped tombstone to be unchanged, got version %v, want %v", again.Version, stripped.Version)
+	}
+	commenter.DeleteComment(&Comment{ID: kept.ID})
+
+	if n, err := commenter.PurgeDeleted(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeDeleted() = %v, %v, expected recently deleted comments to be kept", n, err)
+	}
+
+	if n, err := commenter.PurgeDeleted(time.Now().Add(time.Hour)); err != nil || n != 3 {
+		t.Errorf("PurgeDeleted() = %v, %v, expected 3 comments purged", n, err)
+	}
+
+	remaining := 0
+	db.Unscoped().Model(&Comment{}).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("PurgeDeleted() expected all comments to be removed, found %v", remaining)
+	}
+}
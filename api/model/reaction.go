@@ -0,0 +1,121 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultReactions is the reaction set used when none is configured
+var DefaultReactions = []string{"like", "love", "laugh", "surprised", "sad", "angry"}
+
+// ReactionStore exposes methods to add, remove, and count comment reactions
+type ReactionStore interface {
+	AddReaction(uint, string, string) error
+	RemoveReaction(uint, string, string) error
+	LoadReactions([]*Comment, string) error
+}
+
+// Reaction represents a single voter reacting to a comment
+type Reaction struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time `json:"createdAt"`
+	CommentID uint      `json:"commentId" gorm:"unique_index:uix_reactions_comment_voter_kind"`
+	Voter     string    `json:"-" gorm:"unique_index:uix_reactions_comment_voter_kind"`
+	Kind      string    `json:"kind" gorm:"unique_index:uix_reactions_comment_voter_kind"`
+}
+
+// AddReaction records voter reacting to comment with id. Adding a reaction
+// the voter already made has no effect.
+func (c SqliteCommentStore) AddReaction(id uint, voter string, kind string) error {
+	if _, err := c.GetComment(id); err != nil {
+		return err
+	}
+
+	err := c.DB.Where("comment_id = ? AND voter = ? AND kind = ?", id, voter, kind).First(&Reaction{}).Error
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err := c.DB.Create(&Reaction{CommentID: id, Voter: voter, Kind: kind}).Error; err != nil {
+		return err
+	}
+	return c.bumpVersion(id)
+}
+
+// RemoveReaction removes the reaction of voter to comment with id
+func (c SqliteCommentStore) RemoveReaction(id uint, voter string, kind string) error {
+	if _, err := c.GetComment(id); err != nil {
+		return err
+	}
+
+	db := c.DB.
+		Where("comment_id = ? AND voter = ? AND kind = ?", id, voter, kind).
+		Delete(&Reaction{})
+	if db.Error != nil || db.RowsAffected == 0 {
+		return db.Error
+	}
+	return c.bumpVersion(id)
+}
+
+// bumpVersion increments the version of comment with id, as reactions are
+// part of the comment representation
+func (c SqliteCommentStore) bumpVersion(id uint) error {
+	return c.scoped().Model(&Comment{ID: &id}).UpdateColumn("version", gorm.Expr("version + 1")).Error
+}
+
+// LoadReactions sets the aggregated reaction counts of each comment, and the
+// reactions made by voter if voter is not empty
+func (c SqliteCommentStore) LoadReactions(comments []*Comment, voter string) error {
+	byID := make(map[uint]*Comment, len(comments))
+	ids := make([]uint, 0, len(comments))
+	for _, comment := range comments {
+		comment.Reactions = map[string]int{}
+		comment.MyReactions = []string{}
+		if comment.ID != nil {
+			byID[*comment.ID] = comment
+			ids = append(ids, *comment.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := c.DB.Model(&Reaction{}).
+		Select("comment_id, kind, count(*)").
+		Where("comment_id IN (?)", ids).
+		Group("comment_id, kind").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var kind string
+		var count int
+		if err := rows.Scan(&id, &kind, &count); err != nil {
+			return err
+		}
+		byID[id].Reactions[kind] = count
+	}
+
+	if err := rows.Err(); err != nil || voter == "" {
+		return err
+	}
+
+	mine := []*Reaction{}
+	err = c.DB.Where("comment_id IN (?) AND voter = ?", ids, voter).Order("id").Find(&mine).Error
+	if err != nil {
+		return err
+	}
+
+	for _, reaction := range mine {
+		comment := byID[reaction.CommentID]
+		comment.MyReactions = append(comment.MyReactions, reaction.Kind)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestReactions(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	comment1, _ := commenter.CreateComment(&Comment{Content: "Some content all right", URL: "http://example.com/post/1"})
+	comment2, _ := commenter.CreateComment(&Comment{Content: "More content all right", URL: "http://example.com/post/1"})
+
+	commenter.AddReaction(*comment1.ID, "voter-1", "like")
+	commenter.AddReaction(*comment1.ID, "voter-1", "like")
+	commenter.AddReaction(*comment1.ID, "voter-1", "laugh")
+	commenter.AddReaction(*comment1.ID, "voter-2", "like")
+	commenter.AddReaction(*comment2.ID, "voter-2", "sad")
+	commenter.AddReaction(*comment2.ID, "voter-2", "angry")
+	commenter.RemoveReaction(*comment2.ID, "voter-2", "angry")
+
+	if err := commenter.AddReaction(1000, "voter-1", "like"); err == nil {
+		t.Errorf("AddReaction() expected error for comment that does not exist")
+	}
+
+	if err := commenter.ForSite(1).AddReaction(*comment1.ID, "voter-1", "like"); err == nil {
+		t.Errorf("AddReaction() expected comment to be hidden from other sites")
+	}
+
+	tests := []struct {
+		name        string
+		voter       string
+		reactions   []map[string]int
+		myReactions [][]string
+	}{
+		{
+			name:        "Load reactions for voter",
+			voter:       "voter-1",
+			reactions:   []map[string]int{{"like": 2, "laugh": 1}, {"sad": 1}},
+			myReactions: [][]string{{"like", "laugh"}, {}},
+		},
+		{
+			name:        "Load reactions without voter",
+			voter:       "",
+			reactions:   []map[string]int{{"like": 2, "laugh": 1}, {"sad": 1}},
+			myReactions: [][]string{{}, {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments := []*Comment{comment1, comment2}
+			if err := commenter.LoadReactions(comments, tt.voter); err != nil {
+				t.Fatalf("LoadReactions() error = %v", err)
+			}
+
+			for i, comment := range comments {
+				if !reflect.DeepEqual(comment.Reactions, tt.reactions[i]) {
+					t.Errorf("LoadReactions() wanted reactions %v, but got %v", tt.reactions[i], comment.Reactions)
+				}
+				if !reflect.DeepEqual(comment.MyReactions, tt.myReactions[i]) {
+					t.Errorf("LoadReactions() wanted my reactions %v, but got %v", tt.myReactions[i], comment.MyReactions)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Errors returned when replying to a comment that cannot be replied to, or
+// when editing a reply would move it
+var (
+	errParentNotFound = ValidationError{{Field: "parentId", Code: CodeNotFound, Message: "Parent comment does not exist"}}
+	errParentThread   = ValidationError{{Field: "parentId", Code: CodeInvalid, Message: "Parent comment is on another url"}}
+	errParentChanged  = ValidationError{{Field: "parentId", Code: CodeReadOnly, Message: "Replies cannot be moved to another parent"}}
+)
+
+// pathOf returns the path of comment, falling back to its id for comments
+// stored before paths were
+func (comment *Comment) pathOf() string {
+	if comment.Path == "" {
+		return strconv.FormatUint(uint64(*comment.ID), 10)
+	}
+	return comment.Path
+}
+
+// placeReply checks that the parent of comment exists in thread and sets
+// the depth and path prefix of comment. Replies nested deeper than MaxDepth
+// are attached to their deepest allowed ancestor instead.
+func (c SqliteCommentStore) placeReply(comment *Comment, thread *Thread) error {
+	comment.Depth = 0
+	comment.Path = ""
+	if comment.ParentID == 0 {
+		return nil
+	}
+
+	parent, err := c.GetComment(comment.ParentID)
+	if err == gorm.ErrRecordNotFound {
+		return errParentNotFound
+	} else if err != nil {
+		return err
+	} else if parent.ThreadID != thread.ID {
+		return errParentThread
+	}
+
+	ancestors := strings.Split(parent.pathOf(), "/")
+	if c.MaxDepth > 0 && uint(len(ancestors)) > c.MaxDepth {
+		ancestors = ancestors[:c.MaxDepth]
+		id, err := strconv.ParseUint(ancestors[len(ancestors)-1], 10, 32)
+		if err != nil {
+			return err
+		}
+		comment.ParentID = uint(id)
+	}
+
+	comment.Depth = uint(len(ancestors))
+	comment.Path = strings.Join(ancestors, "/")
+	return nil
+}
+
+// AssignPaths sets the depth and path of comments stored before replies
+// had them, and returns the number of comments updated. Replies to missing
+// comments are treated as top-level comments.
+func (c SqliteCommentStore) AssignPaths() (int, error) {
+	comments := []*Comment{}
+	if err := c.DB.Unscoped().Where("path = '' OR path IS NULL").Order("id asc").Find(&comments).Error; err != nil {
+		return 0, err
+	}
+
+	for _, comment := range comments {
+		comment.Depth, comment.Path = 0, comment.pathOf()
+
+		if comment.ParentID != 0 {
+			parent := &Comment{}
+			if err := c.DB.Unscoped().First(parent, comment.ParentID).Error; err == nil {
+				comment.Depth = parent.Depth + 1
+				comment.Path = parent.pathOf() + "/" + comment.Path
+			}
+		}
+
+		err := c.DB.Unscoped().Model(comment).UpdateColumns(map[string]interface{}{
+			"depth": comment.Depth,
+			"path":  comment.Path,
+		}).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(comments), nil
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestReplies(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db, MaxDepth: 2}
+	url := "http://example.com/post/1"
+
+	top, _ := commenter.CreateComment(&Comment{Content: "Top-level comment", URL: url})
+	reply, _ := commenter.CreateComment(&Comment{Content: "Reply", URL: url, ParentID: *top.ID})
+	other, _ := commenter.CreateComment(&Comment{Content: "Comment on another post", URL: "http://example.com/post/2"})
+	deleted, _ := commenter.CreateComment(&Comment{Content: "Deleted comment", URL: url})
+	commenter.DeleteComment(&Comment{ID: deleted.ID})
+
+	nested, _ := commenter.CreateComment(&Comment{Content: "Nested reply", URL: url, ParentID: *reply.ID})
+
+	tests := []struct {
+		name     string
+		comment  *Comment
+		err      error
+		parentID uint
+		depth    uint
+		path     string
+	}{
+		{
+			name:    "Top-level comment",
+			comment: &Comment{Content: "Another top-level comment", URL: url},
+			path:    "6",
+		},
+		{
+			name:     "Reply",
+			comment:  &Comment{Content: "Another reply", URL: url, ParentID: *top.ID},
+			parentID: *top.ID,
+			depth:    1,
+			path:     "1/7",
+		},
+		{
+			name:     "Reply at maximum depth",
+			comment:  &Comment{Content: "Nested reply", URL: url, ParentID: *reply.ID},
+			parentID: *reply.ID,
+			depth:    2,
+			path:     "1/2/8",
+		},
+		{
+			name:     "Reply deeper than maximum depth",
+			comment:  &Comment{Content: "Too deeply nested reply", URL: url, ParentID: *nested.ID},
+			parentID: *reply.ID,
+			depth:    2,
+			path:     "1/2/9",
+		},
+		{
+			name:    "Reply to missing comment",
+			comment: &Comment{Content: "Orphan", URL: url, ParentID: 1000},
+			err:     errParentNotFound,
+		},
+		{
+			name:    "Reply to deleted comment",
+			comment: &Comment{Content: "Orphan", URL: url, ParentID: *deleted.ID},
+			err:     errParentNotFound,
+		},
+		{
+			name:    "Reply to comment on another url",
+			comment: &Comment{Content: "Misplaced reply", URL: url, ParentID: *other.ID},
+			err:     errParentThread,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comment, err := commenter.CreateComment(tt.comment)
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Fatalf("CreateComment() error = %v, wanted %v", err, tt.err)
+			} else if err != nil {
+				return
+			}
+
+			comment, _ = commenter.GetComment(*comment.ID)
+			if comment.ParentID != tt.parentID || comment.Depth != tt.depth || comment.Path != tt.path {
+				t.Errorf("CreateComment() wanted parent %v, depth %v, and path %v, but got %v, %v, and %v",
+					tt.parentID, tt.depth, tt.path, comment.ParentID, comment.Depth, comment.Path)
+			}
+		})
+	}
+
+	if _, err := commenter.UpdateComment(&Comment{ID: nested.ID, Content: "Moved reply", URL: url, ParentID: *top.ID}); !reflect.DeepEqual(err, errParentChanged) {
+		t.Errorf("UpdateComment() expected replies to stay with their parent, got %v", err)
+	}
+
+	if _, err := commenter.UpdateComment(&Comment{ID: nested.ID, Content: "Moved reply", URL: "http://example.com/post/2", ParentID: *reply.ID}); !reflect.DeepEqual(err, errParentThread) {
+		t.Errorf("UpdateComment() expected replies to stay in their thread, got %v", err)
+	}
+}
+
+func TestAssignPaths(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	// Comments stored before replies had paths
+	db.Exec("INSERT INTO comments (id, site_id, parent_id, content, url) VALUES (1, 0, 0, 'Top', 'http://example.com/post/1'), (2, 0, 1, 'Reply', 'http://example.com/post/1'), (3, 0, 2, 'Nested', 'http://example.com/post/1'), (4, 0, 100, 'Orphan', 'http://example.com/post/1')")
+
+	commenter := &SqliteCommentStore{DB: db}
+	if n, err := commenter.AssignPaths(); err != nil || n != 4 {
+		t.Fatalf("AssignPaths() = %v, %v, wanted 4 comments updated", n, err)
+	}
+
+	wanted := map[uint]string{1: "1", 2: "1/2", 3: "1/2/3", 4: "4"}
+	for id, path := range wanted {
+		comment, _ := commenter.GetComment(id)
+		if comment.Path != path || comment.Depth != uint(len(path)/2) {
+			t.Errorf("AssignPaths() wanted comment %v at path %v, but got %v at depth %v", id, path, comment.Path, comment.Depth)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultSearchLimit is the number of comments returned if no limit is given
+const DefaultSearchLimit = 50
+
+// CommentQuery filters comments for moderators, zero values match any
+// comment
+type CommentQuery struct {
+	// Text matches comments containing it in their content, username, or
+	// email, ignoring case
+	Text   string
+	Status string
+	// URL matches comments in the thread the url belongs to
+	URL string
+	// Limit caps the number of comments, DefaultSearchLimit is used if 0
+	Limit  int
+	Offset int
+}
+
+// SearchComments fetches the comments of the site matching query, the
+// newest first. Deleted comments are left out.
+func (c SqliteCommentStore) SearchComments(query CommentQuery) ([]*Comment, error) {
+	db := c.scoped()
+
+	if text := strings.ToLower(strings.TrimSpace(query.Text)); text != "" {
+		pattern := "%" + escapeLike(text) + "%"
+		db = db.Where("lower(content) LIKE ? ESCAPE '\\' OR lower(username) LIKE ? ESCAPE '\\' OR lower(email) LIKE ? ESCAPE '\\'",
+			pattern, pattern, pattern)
+	}
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+	if query.URL != "" {
+		thread, err := c.GetThread(query.URL)
+		if err == gorm.ErrRecordNotFound {
+			return []*Comment{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		db = db.Where("thread_id = ?", thread.ID)
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	}
+
+	comments := []*Comment{}
+	return comments, db.Order("created_at desc").Order("id desc").
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Find(&comments).Error
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text)
+}
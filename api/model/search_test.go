@@ -0,0 +1,69 @@
+package model
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestSearchComments(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+	first, _ := commenter.CreateComment(&Comment{Content: "Buy cheap watches", Username: "spammer", URL: "http://example.com/post/1", Status: StatusPending})
+	commenter.CreateComment(&Comment{Content: "Nice post", Email: "alice@example.com", URL: "http://example.com/post/1", Status: StatusApproved})
+	commenter.CreateComment(&Comment{Content: "100% agree", URL: "http://example.com/post/2", Status: StatusApproved})
+	deleted, _ := commenter.CreateComment(&Comment{Content: "Cheap shoes", URL: "http://example.com/post/2", Status: StatusPending})
+	commenter.DeleteComment(deleted)
+	commenter.ForSite(1).CreateComment(&Comment{Content: "Cheap bags", URL: "http://example.com/post/1", Status: StatusPending})
+
+	tests := []struct {
+		name  string
+		query CommentQuery
+		want  []string
+	}{
+		{"All comments newest first", CommentQuery{}, []string{"100% agree", "Nice post", "Buy cheap watches"}},
+		{"Text ignoring case", CommentQuery{Text: "CHEAP"}, []string{"Buy cheap watches"}},
+		{"Username", CommentQuery{Text: "spam"}, []string{"Buy cheap watches"}},
+		{"Email", CommentQuery{Text: "alice@"}, []string{"Nice post"}},
+		{"Wildcards are literal", CommentQuery{Text: "0%"}, []string{"100% agree"}},
+		{"Status", CommentQuery{Status: StatusPending}, []string{"Buy cheap watches"}},
+		{"Thread", CommentQuery{URL: "http://example.com/post/2"}, []string{"100% agree"}},
+		{"Unknown thread", CommentQuery{URL: "http://example.com/post/3"}, []string{}},
+		{"Limit and offset", CommentQuery{Limit: 1, Offset: 1}, []string{"Nice post"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments, err := commenter.SearchComments(tt.query)
+			if err != nil {
+				t.Fatalf("SearchComments() error = %v", err)
+			}
+
+			got := []string{}
+			for _, comment := range comments {
+				got = append(got, comment.Content)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("SearchComments() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("SearchComments() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if comments, _ := commenter.ForSite(1).SearchComments(CommentQuery{Text: "cheap"}); len(comments) != 1 || *comments[0].ID == *first.ID {
+		t.Errorf("SearchComments() expected only comments of site 1, got %v", comments)
+	}
+}
@@ -0,0 +1,223 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Site moderation settings
+const (
+	// ModerationNone approves new comments immediately
+	ModerationNone = "none"
+	// ModerationPre holds new comments for approval by a moderator
+	ModerationPre = "pre"
+)
+
+// SiteStore exposes methods to create, get, and delete sites
+type SiteStore interface {
+	GetSites() ([]*Site, error)
+	GetSite(string) (*Site, error)
+	GetSiteByOrigin(string) (*Site, error)
+	GetSiteByAPIKey(string) (*Site, error)
+	CreateSite(*Site) (*Site, error)
+	DeleteSite(*Site) (*Site, error)
+}
+
+// ValidateOrigin checks that pattern is * or an origin like
+// https://example.com, optionally with a wildcard subdomain like
+// https://*.example.com
+func ValidateOrigin(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+
+	u, err := url.Parse(strings.Replace(pattern, "*.", "wildcard.", 1))
+	if err != nil {
+		return fmt.Errorf("Invalid origin %v: %v", pattern, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Invalid origin %v: scheme must be http or https", pattern)
+	} else if u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("Invalid origin %v: must only contain scheme, host and port", pattern)
+	} else if strings.Contains(u.Host, "*") || (strings.Contains(pattern, "*") && !strings.HasPrefix(u.Host, "wildcard.")) {
+		return fmt.Errorf("Invalid origin %v: wildcard must be the first label of the host", pattern)
+	}
+
+	return nil
+}
+
+// MatchOrigin checks if origin matches pattern. The pattern * matches any
+// origin, and a pattern like https://*.example.com matches any subdomain.
+func MatchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+
+	if i := strings.Index(pattern, "*."); i >= 0 {
+		prefix, suffix := pattern[:i], pattern[i+1:]
+		return len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) &&
+			strings.HasSuffix(origin, suffix)
+	}
+
+	return pattern == origin
+}
+
+// Site represents a domain hosting comments on this gocomment instance
+type Site struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name" gorm:"unique_index"`
+	// Origins is a comma separated list of origins allowed to use the site,
+	// see MatchOrigin for the supported patterns
+	Origins    string `json:"origins"`
+	Moderation string `json:"moderation"`
+	APIKey     string `json:"-" gorm:"unique_index"`
+	// SSOSecret signs the single sign-on tokens of the site, sites without
+	// a secret only accept tokens signed with the configured RS256 keys
+	SSOSecret string `json:"-"`
+}
+
+// AllowedOrigins returns the origins allowed to use the site
+func (s *Site) AllowedOrigins() []string {
+	origins := make([]string, 0)
+	for _, origin := range strings.Split(s.Origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// AllowsOrigin checks if origin may use the site
+func (s *Site) AllowsOrigin(origin string) bool {
+	for _, allowed := range s.AllowedOrigins() {
+		if MatchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultStatus returns the status new comments on the site are given
+func (s *Site) DefaultStatus() string {
+	if s.Moderation == ModerationPre {
+		return StatusPending
+	}
+	return StatusApproved
+}
+
+// NewAPIKey generates a random site api key
+func NewAPIKey() (string, error) {
+	key := make([]byte, 24)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// SqliteSiteStore implements a gorm based site store
+type SqliteSiteStore struct {
+	DB *gorm.DB
+}
+
+// GetSites fetches all sites from database
+func (s SqliteSiteStore) GetSites() ([]*Site, error) {
+	sites := []*Site{}
+	return sites, s.DB.Order("name").Find(&sites).Error
+}
+
+// GetSite fetches site by name from database
+func (s SqliteSiteStore) GetSite(name string) (*Site, error) {
+	site := Site{}
+	return &site, s.DB.Where(&Site{Name: name}).First(&site).Error
+}
+
+// GetSiteByOrigin fetches the site allowing origin from database
+func (s SqliteSiteStore) GetSiteByOrigin(origin string) (*Site, error) {
+	sites, err := s.GetSites()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, site := range sites {
+		if site.AllowsOrigin(origin) {
+			return site, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetSiteByAPIKey fetches site by api key from database
+func (s SqliteSiteStore) GetSiteByAPIKey(key string) (*Site, error) {
+	if key == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	site := Site{}
+	return &site, s.DB.Where(&Site{APIKey: key}).First(&site).Error
+}
+
+// CreateSite inserts site into database, generating an api key if missing
+func (s SqliteSiteStore) CreateSite(site *Site) (*Site, error) {
+	for _, origin := range site.AllowedOrigins() {
+		if err := ValidateOrigin(origin); err != nil {
+			return nil, err
+		}
+	}
+
+	if site.Moderation == "" {
+		site.Moderation = ModerationNone
+	}
+
+	if site.APIKey == "" {
+		key, err := NewAPIKey()
+		if err != nil {
+			return nil, err
+		}
+		site.APIKey = key
+	}
+
+	return site, s.DB.Create(site).Error
+}
+
+// RotateSSOSecret replaces the single sign-on secret of site with a new
+// random secret, invalidating tokens signed with the previous secret
+func (s SqliteSiteStore) RotateSSOSecret(site *Site) (*Site, error) {
+	secret, err := NewAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.DB.Model(site).Update("sso_secret", secret)
+	if db.Error != nil {
+		return nil, db.Error
+	} else if db.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return site, nil
+}
+
+// DeleteSite deletes selected site. Comments on the site are kept.
+func (s SqliteSiteStore) DeleteSite(site *Site) (*Site, error) {
+	db := s.DB.Delete(site)
+
+	if db.Error != nil {
+		return nil, db.Error
+	} else if db.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return site, nil
+}
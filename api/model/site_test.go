@@ -0,0 +1,192 @@
+package model
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestSite_AllowsOrigin(t *testing.T) {
+	site := &Site{Origins: "https://example.com, https://www.example.com"}
+
+	tests := []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{
+			name:    "Listed origin is allowed",
+			origin:  "https://example.com",
+			allowed: true,
+		},
+		{
+			name:    "Origins are compared case insensitively",
+			origin:  "https://WWW.example.com",
+			allowed: true,
+		},
+		{
+			name:    "Unlisted origin is not allowed",
+			origin:  "http://example.com",
+			allowed: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := site.AllowsOrigin(tt.origin); allowed != tt.allowed {
+				t.Errorf("AllowsOrigin() wanted %v, but got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestSqliteSiteStore(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	sites := SqliteSiteStore{DB: db}
+
+	site, err := sites.CreateSite(&Site{Name: "example", Origins: "https://example.com"})
+	if err != nil {
+		t.Fatalf("CreateSite() error = %v", err)
+	}
+
+	if site.APIKey == "" {
+		t.Errorf("CreateSite() expected an api key to be generated")
+	}
+
+	if site.Moderation != ModerationNone {
+		t.Errorf("CreateSite() expected moderation %v, but got %v", ModerationNone, site.Moderation)
+	}
+
+	if found, err := sites.GetSiteByOrigin("https://example.com"); err != nil || found.ID != site.ID {
+		t.Errorf("GetSiteByOrigin() expected site %v, got %v with error %v", site.ID, found, err)
+	}
+
+	if _, err := sites.GetSiteByOrigin("https://example.org"); err == nil {
+		t.Errorf("GetSiteByOrigin() expected error for unknown origin")
+	}
+
+	if found, err := sites.GetSiteByAPIKey(site.APIKey); err != nil || found.ID != site.ID {
+		t.Errorf("GetSiteByAPIKey() expected site %v, got %v with error %v", site.ID, found, err)
+	}
+
+	if _, err := sites.GetSiteByAPIKey(""); err == nil {
+		t.Errorf("GetSiteByAPIKey() expected error for empty key")
+	}
+
+	if site.SSOSecret != "" {
+		t.Errorf("CreateSite() expected sites to not accept single sign-on tokens until given a secret")
+	}
+
+	if _, err := sites.RotateSSOSecret(site); err != nil {
+		t.Fatalf("RotateSSOSecret() error = %v", err)
+	} else if found, _ := sites.GetSite("example"); found.SSOSecret == "" || found.SSOSecret != site.SSOSecret {
+		t.Errorf("RotateSSOSecret() expected secret %q to be stored, got %q", site.SSOSecret, found.SSOSecret)
+	}
+
+	if _, err := sites.DeleteSite(site); err != nil {
+		t.Errorf("DeleteSite() error = %v", err)
+	}
+
+	if _, err := sites.GetSite("example"); err == nil {
+		t.Errorf("GetSite() expected error for removed site")
+	}
+}
+
+func TestSqliteCommentStore_ForSite(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := SqliteCommentStore{DB: db}
+	site1 := commenter.ForSite(1)
+	site2 := commenter.ForSite(2)
+
+	comment, _ := site1.CreateComment(&Comment{
+		Content: "Comment on site 1",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/1",
+	})
+
+	site2.CreateComment(&Comment{
+		Content: "Comment on site 2",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/1",
+	})
+
+	if comments, err := site1.GetComments("http://example.com/post/1", ""); err != nil || len(comments) != 1 {
+		t.Errorf("GetComments() expected 1 comment on site 1, got %v with error %v", len(comments), err)
+	}
+
+	if _, err := site2.GetComment(*comment.ID); err == nil {
+		t.Errorf("GetComment() expected comment on site 1 to be hidden from site 2")
+	}
+
+	if _, err := site2.DeleteComment(&Comment{ID: comment.ID}); err == nil {
+		t.Errorf("DeleteComment() expected comment on site 1 to be hidden from site 2")
+	}
+
+	if counts, err := commenter.CountComments([]string{"http://example.com/post/1"}); err != nil || counts["http://example.com/post/1"] != 0 {
+		t.Errorf("CountComments() expected no comments on default site, got %v with error %v", counts, err)
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		origin  string
+		matches bool
+	}{
+		{"Any origin", "*", "https://example.com", true},
+		{"Exact origin", "https://example.com", "https://example.com", true},
+		{"Different scheme", "https://example.com", "http://example.com", false},
+		{"Wildcard subdomain", "https://*.example.com", "https://blog.example.com", true},
+		{"Wildcard nested subdomain", "https://*.example.com", "https://a.b.example.com", true},
+		{"Wildcard does not match apex", "https://*.example.com", "https://example.com", false},
+		{"Wildcard does not match other domain", "https://*.example.com", "https://evilexample.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if matches := MatchOrigin(tt.pattern, tt.origin); matches != tt.matches {
+				t.Errorf("MatchOrigin() wanted %v, but got %v", tt.matches, matches)
+			}
+		})
+	}
+}
+
+func TestValidateOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{"Any origin", "*", false},
+		{"Origin with port", "http://localhost:3449", false},
+		{"Wildcard subdomain", "https://*.example.com", false},
+		{"Missing scheme", "example.com", true},
+		{"Origin with path", "https://example.com/blog", true},
+		{"Wildcard inside host", "https://blog.*.example.com", true},
+		{"Partial wildcard", "https://*blog.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateOrigin(tt.pattern); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateOrigin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
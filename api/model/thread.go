@@ -0,0 +1,340 @@
+package model
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ThreadStore exposes methods to look up, configure, and merge comment
+// threads
+type ThreadStore interface {
+	GetThread(string) (*Thread, error)
+	GetOrCreateThread(string) (*Thread, error)
+	UpdateThread(*Thread) (*Thread, error)
+	MergeThreads(*Thread, ...*Thread) error
+}
+
+// Thread states
+const (
+	// ThreadOpen threads accept new comments and edits
+	ThreadOpen = "open"
+	// ThreadClosed threads accept edits to existing comments only
+	ThreadClosed = "closed"
+	// ThreadReadOnly threads accept neither new comments nor edits
+	ThreadReadOnly = "read-only"
+)
+
+// ErrInvalidThreadState is returned when saving a thread with an unknown state
+var ErrInvalidThreadState = errors.New("Thread state must be open, closed or read-only")
+
+// Thread represents the discussion of a single article. Comments posted to
+// different urls that normalize to the same key belong to the same thread.
+type Thread struct {
+	ID           uint      `json:"id" gorm:"primary_key"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	SiteID       uint      `json:"siteId" gorm:"unique_index:uix_threads_site_key"`
+	Key          string    `json:"key" gorm:"unique_index:uix_threads_site_key"`
+	URL          string    `json:"url"`
+	MergedIntoID uint      `json:"mergedIntoId" sql:"index"`
+
+	State string `json:"state"`
+	// AutoCloseDays closes the thread this many days after the first
+	// comment, 0 never closes the thread
+	AutoCloseDays     int        `json:"autoCloseDays"`
+	RequireModeration bool       `json:"requireModeration"`
+	FirstCommentAt    *time.Time `json:"firstCommentAt"`
+}
+
+// EffectiveState returns the state of the thread at time now, closing open
+// threads whose auto close period has passed
+func (t *Thread) EffectiveState(now time.Time) string {
+	if t.State == ThreadClosed || t.State == ThreadReadOnly {
+		return t.State
+	}
+
+	if t.AutoCloseDays > 0 && t.FirstCommentAt != nil &&
+		now.After(t.FirstCommentAt.AddDate(0, 0, t.AutoCloseDays)) {
+		return ThreadClosed
+	}
+
+	return ThreadOpen
+}
+
+// URLNormalizer turns comment urls into canonical thread keys
+type URLNormalizer struct {
+	// KeepScheme makes http and https urls separate threads
+	KeepScheme bool
+	// StripWWW removes a leading www. from the host
+	StripWWW bool
+	// StripTrailingSlash removes trailing slashes from the path
+	StripTrailingSlash bool
+	// HostAliases maps alternative host names to their canonical host
+	HostAliases map[string]string
+	// StripParams lists query parameters to remove, a trailing * matches
+	// any parameter with the given prefix
+	StripParams []string
+}
+
+// DefaultURLNormalizer returns the normalization rules used when none are
+// configured
+func DefaultURLNormalizer() *URLNormalizer {
+	return &URLNormalizer{
+		StripWWW:           true,
+		StripTrailingSlash: true,
+		StripParams:        []string{"utm_*", "fbclid", "gclid"},
+	}
+}
+
+func (n *URLNormalizer) stripParam(param string) bool {
+	for _, pattern := range n.StripParams {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(param, strings.TrimSuffix(pattern, "*")) {
+			return true
+		} else if param == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the canonical thread key for rawURL. Urls that are not
+// absolute are returned as is.
+func (n *URLNormalizer) Key(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = host + ":" + port
+	}
+
+	if n.StripWWW {
+		host = strings.TrimPrefix(host, "www.")
+	}
+
+	if alias, ok := n.HostAliases[host]; ok {
+		host = strings.ToLower(alias)
+	}
+
+	path := u.EscapedPath()
+	if n.StripTrailingSlash {
+		path = strings.TrimRight(path, "/")
+	}
+
+	query := u.Query()
+	for param := range query {
+		if n.stripParam(param) {
+			query.Del(param)
+		}
+	}
+
+	key := host + path
+	if encoded := query.Encode(); encoded != "" {
+		key = key + "?" + encoded
+	}
+
+	if n.KeepScheme {
+		key = strings.ToLower(u.Scheme) + "://" + key
+	}
+
+	return key
+}
+
+func (c SqliteCommentStore) normalizer() *URLNormalizer {
+	if c.Normalizer == nil {
+		return DefaultURLNormalizer()
+	}
+	return c.Normalizer
+}
+
+// resolveThread follows a merged thread to the thread it was merged into
+func (c SqliteCommentStore) resolveThread(thread *Thread) (*Thread, error) {
+	if thread.MergedIntoID == 0 {
+		return thread, nil
+	}
+
+	merged := Thread{}
+	return &merged, c.DB.First(&merged, thread.MergedIntoID).Error
+}
+
+// GetThread looks up the thread an url belongs to. Threads are found by their
+// normalized key, falling back to the url the thread was first created with.
+func (c SqliteCommentStore) GetThread(url string) (*Thread, error) {
+	thread := Thread{}
+
+	db := c.DB.Where("site_id = ?", c.SiteID)
+
+	err := db.Where("key = ?", c.normalizer().Key(url)).First(&thread).Error
+	if err == gorm.ErrRecordNotFound {
+		err = db.Where("url = ?", url).First(&thread).Error
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.resolveThread(&thread)
+}
+
+// GetOrCreateThread returns the thread an url belongs to, creating it if needed
+func (c SqliteCommentStore) GetOrCreateThread(url string) (*Thread, error) {
+	thread := Thread{}
+
+	err := c.DB.Where("site_id = ?", c.SiteID).
+		Where("key = ?", c.normalizer().Key(url)).
+		Attrs(&Thread{SiteID: c.SiteID, Key: c.normalizer().Key(url), URL: url}).
+		FirstOrCreate(&thread).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return c.resolveThread(&thread)
+}
+
+// UpdateThread saves the state, auto close and moderation settings of thread
+func (c SqliteCommentStore) UpdateThread(thread *Thread) (*Thread, error) {
+	if thread.State == "" {
+		thread.State = ThreadOpen
+	} else if thread.State != ThreadOpen && thread.State != ThreadClosed && thread.State != ThreadReadOnly {
+		return nil, ErrInvalidThreadState
+	}
+
+	db := c.DB.Model(thread).Where("site_id = ?", c.SiteID).Updates(map[string]interface{}{
+		"state":              thread.State,
+		"auto_close_days":    thread.AutoCloseDays,
+		"require_moderation": thread.RequireModeration,
+	})
+
+	if db.Error != nil {
+		return nil, db.Error
+	} else if db.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return thread, nil
+}
+
+// touchThread records createdAt as the time of the first comment in thread
+// unless an earlier comment exists
+func (c SqliteCommentStore) touchThread(thread *Thread, createdAt *time.Time) error {
+	if createdAt == nil {
+		return nil
+	}
+
+	return c.DB.Model(&Thread{}).
+		Where("id = ? AND (first_comment_at IS NULL OR first_comment_at > ?)", thread.ID, createdAt).
+		UpdateColumn("first_comment_at", createdAt).Error
+}
+
+// AssignThreads assigns threads to comments stored before threads existed
+// and returns the number of comments updated
+func (c SqliteCommentStore) AssignThreads() (int, error) {
+	comments := []*Comment{}
+	if err := c.DB.Unscoped().Where("thread_id = 0").Find(&comments).Error; err != nil {
+		return 0, err
+	}
+
+	for _, comment := range comments {
+		store := c
+		store.SiteID = comment.SiteID
+
+		thread, err := store.GetOrCreateThread(comment.URL)
+		if err != nil {
+			return 0, err
+		}
+
+		err = c.DB.Unscoped().Model(comment).UpdateColumn("thread_id", thread.ID).Error
+		if err != nil {
+			return 0, err
+		}
+
+		if err := c.touchThread(thread, comment.CreatedAt); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(comments), nil
+}
+
+// markThreadChanged records that comments left the thread with id, so it is
+// returned by GetChangedThreads although none of its comments changed
+func (c SqliteCommentStore) markThreadChanged(id uint) error {
+	return c.DB.Model(&Thread{}).Where("id = ?", id).UpdateColumn("updated_at", time.Now()).Error
+}
+
+// GetChangedThreads returns the threads of the site with comments created,
+// changed, deleted, or moved away after since, or every thread if since is
+// zero. Threads merged into a changed thread are returned too, as their urls
+// show the comments of the thread they were merged into.
+func (c SqliteCommentStore) GetChangedThreads(since time.Time) ([]*Thread, error) {
+	threads := []*Thread{}
+
+	db := c.DB.Where("site_id = ?", c.SiteID)
+	if !since.IsZero() {
+		changed := c.scoped().Unscoped().Model(&Comment{}).
+			Select("thread_id").
+			Where("updated_at > ? OR deleted_at > ?", since, since).
+			QueryExpr()
+		db = db.Where("id IN (?) OR merged_into_id IN (?) OR updated_at > ?", changed, changed, since)
+	}
+
+	return threads, db.Order("id").Find(&threads).Error
+}
+
+// MergeThreads moves all comments in the from threads into thread. The keys of
+// the merged threads keep resolving to thread afterwards.
+func (c SqliteCommentStore) MergeThreads(thread *Thread, from ...*Thread) error {
+	ids := make([]uint, 0, len(from))
+	for _, other := range from {
+		if other.ID == thread.ID {
+			return errors.New("Cannot merge thread into itself")
+		} else if other.SiteID != thread.SiteID {
+			return errors.New("Cannot merge threads on different sites")
+		}
+		ids = append(ids, other.ID)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx := c.DB.Begin()
+
+	// Moved comments count as changed, so static builds render them into
+	// the thread they were merged into
+	err := tx.Unscoped().Model(&Comment{}).
+		Where("thread_id IN (?)", ids).
+		UpdateColumns(map[string]interface{}{"thread_id": thread.ID, "updated_at": time.Now()}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Model(&Thread{}).
+		Where("id IN (?) OR merged_into_id IN (?)", ids, ids).
+		UpdateColumns(map[string]interface{}{"merged_into_id": thread.ID, "updated_at": time.Now()}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	for _, other := range from {
+		if err := c.touchThread(thread, other.FirstCommentAt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package model
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestURLNormalizer_Key(t *testing.T) {
+	normalizer := DefaultURLNormalizer()
+	normalizer.HostAliases = map[string]string{"blog.example.org": "example.com"}
+
+	tests := []struct {
+		name       string
+		normalizer *URLNormalizer
+		url        string
+		key        string
+	}{
+		{
+			name:       "Scheme is ignored",
+			normalizer: normalizer,
+			url:        "https://example.com/post/1",
+			key:        "example.com/post/1",
+		},
+		{
+			name:       "Scheme is kept when configured",
+			normalizer: &URLNormalizer{KeepScheme: true},
+			url:        "HTTPS://example.com/post/1",
+			key:        "https://example.com/post/1",
+		},
+		{
+			name:       "Host is lower cased and www is stripped",
+			normalizer: normalizer,
+			url:        "http://WWW.Example.com/post/1",
+			key:        "example.com/post/1",
+		},
+		{
+			name:       "Host aliases are mapped to canonical host",
+			normalizer: normalizer,
+			url:        "http://blog.example.org/post/1/",
+			key:        "example.com/post/1",
+		},
+		{
+			name:       "Default ports are dropped",
+			normalizer: normalizer,
+			url:        "http://example.com:80/post/1",
+			key:        "example.com/post/1",
+		},
+		{
+			name:       "Other ports are kept",
+			normalizer: normalizer,
+			url:        "http://example.com:8080/post/1",
+			key:        "example.com:8080/post/1",
+		},
+		{
+			name:       "Tracking params and fragments are stripped and params sorted",
+			normalizer: normalizer,
+			url:        "http://example.com/post?page=2&utm_source=feed&fbclid=abc&id=1#comments",
+			key:        "example.com/post?id=1&page=2",
+		},
+		{
+			name:       "Relative urls are left as is",
+			normalizer: normalizer,
+			url:        "/post/1/",
+			key:        "/post/1/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key := tt.normalizer.Key(tt.url); key != tt.key {
+				t.Errorf("Key() wanted key = %v, but got key = %v", tt.key, key)
+			}
+		})
+	}
+}
+
+func TestGetThread(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	comment, _ := commenter.CreateComment(&Comment{
+		Content: "Some content all right",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/1",
+	})
+
+	tests := []struct {
+		name     string
+		url      string
+		threadID uint
+		wantErr  bool
+	}{
+		{
+			name:     "Find thread by original url",
+			url:      "http://example.com/post/1",
+			threadID: comment.ThreadID,
+		},
+		{
+			name:     "Find thread by url variant",
+			url:      "https://www.example.com/post/1/?utm_campaign=spring",
+			threadID: comment.ThreadID,
+		},
+		{
+			name:    "Find thread that does not exist",
+			url:     "http://example.com/post/2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if thread, err := commenter.GetThread(tt.url); (err != nil) != tt.wantErr {
+				t.Errorf("GetThread() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !tt.wantErr && thread.ID != tt.threadID {
+				t.Errorf("GetThread() wanted thread = %v, but got thread = %v", tt.threadID, thread.ID)
+			}
+		})
+	}
+}
+
+func TestMergeThreads(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	commenter.CreateComment(&Comment{
+		Content: "Some content all right",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/1",
+	})
+
+	commenter.CreateComment(&Comment{
+		Content: "Posted before the article moved",
+		Status:  StatusApproved,
+		URL:     "http://example.com/old-post",
+	})
+
+	thread, _ := commenter.GetThread("http://example.com/post/1")
+	oldThread, _ := commenter.GetThread("http://example.com/old-post")
+
+	if err := commenter.MergeThreads(thread, thread); err == nil {
+		t.Errorf("MergeThreads() expected error when merging thread into itself")
+	}
+
+	if err := commenter.MergeThreads(thread, oldThread); err != nil {
+		t.Fatalf("MergeThreads() error = %v", err)
+	}
+
+	for _, url := range []string{"http://example.com/post/1", "http://example.com/old-post"} {
+		if comments, err := commenter.GetComments(url, ""); err != nil {
+			t.Errorf("GetComments() error = %v", err)
+		} else if len(comments) != 2 {
+			t.Errorf("GetComments() expected 2 comments for %v after merge, found %v", url, len(comments))
+		}
+	}
+
+	counts, err := commenter.CountComments([]string{"http://example.com/old-post"})
+	if err != nil {
+		t.Errorf("CountComments() error = %v", err)
+	} else if counts["http://example.com/old-post"] != 2 {
+		t.Errorf("CountComments() expected 2 comments for merged thread, found %v", counts)
+	}
+}
+
+func TestGetChangedThreads(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	for _, url := range []string{"http://example.com/post/1", "http://example.com/post/2", "http://example.com/post/3", "http://example.com/old-post"} {
+		commenter.CreateComment(&Comment{Content: "Some content", Status: StatusApproved, URL: url})
+	}
+	other := &SqliteCommentStore{DB: db, SiteID: 1}
+	other.CreateComment(&Comment{Content: "Some content", Status: StatusApproved, URL: "http://example.com/post/1"})
+
+	urls := func(threads []*Thread) []string {
+		urls := []string{}
+		for _, thread := range threads {
+			urls = append(urls, thread.URL)
+		}
+		return urls
+	}
+
+	threads, err := commenter.GetChangedThreads(time.Time{})
+	if err != nil {
+		t.Fatalf("GetChangedThreads() error = %v", err)
+	} else if len(threads) != 4 {
+		t.Errorf("GetChangedThreads() expected every thread of the site, found %v", urls(threads))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	comment, _ := commenter.GetComment(1)
+	comment.Content = "Edited content"
+	commenter.UpdateComment(comment)
+	commenter.DeleteComment(&Comment{ID: &[]uint{2}[0]})
+	other.CreateComment(&Comment{Content: "Other site", Status: StatusApproved, URL: "http://example.com/post/3"})
+
+	thread, _ := commenter.GetThread("http://example.com/post/1")
+	oldThread, _ := commenter.GetThread("http://example.com/old-post")
+	commenter.MergeThreads(thread, oldThread)
+
+	moved, _ := commenter.GetComment(3)
+	moved.URL = "http://example.com/post/4"
+	commenter.UpdateComment(moved)
+
+	threads, err = commenter.GetChangedThreads(since)
+	if err != nil {
+		t.Fatalf("GetChangedThreads() error = %v", err)
+	}
+	want := []string{"http://example.com/post/1", "http://example.com/post/2", "http://example.com/post/3", "http://example.com/old-post", "http://example.com/post/4"}
+	if got := urls(threads); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetChangedThreads() = %v, want %v", got, want)
+	}
+}
+
+func TestThread_EffectiveState(t *testing.T) {
+	now := time.Date(2018, time.March, 1, 0, 0, 0, 0, time.UTC)
+	recent := now.AddDate(0, 0, -10)
+	old := now.AddDate(0, 0, -40)
+
+	tests := []struct {
+		name   string
+		thread *Thread
+		state  string
+	}{
+		{"Thread without state is open", &Thread{}, ThreadOpen},
+		{"Closed thread is closed", &Thread{State: ThreadClosed}, ThreadClosed},
+		{"Read-only thread stays read-only after auto close", &Thread{State: ThreadReadOnly, AutoCloseDays: 30, FirstCommentAt: &old}, ThreadReadOnly},
+		{"Open thread within auto close period is open", &Thread{State: ThreadOpen, AutoCloseDays: 30, FirstCommentAt: &recent}, ThreadOpen},
+		{"Open thread past auto close period is closed", &Thread{State: ThreadOpen, AutoCloseDays: 30, FirstCommentAt: &old}, ThreadClosed},
+		{"Open thread without comments is open", &Thread{State: ThreadOpen, AutoCloseDays: 30}, ThreadOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if state := tt.thread.EffectiveState(now); state != tt.state {
+				t.Errorf("EffectiveState() wanted %v, but got %v", tt.state, state)
+			}
+		})
+	}
+}
+
+func TestUpdateThread(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	comment, _ := commenter.CreateComment(&Comment{
+		Content: "Some content all right",
+		Status:  StatusApproved,
+		URL:     "http://example.com/post/1",
+	})
+
+	thread, _ := commenter.GetThread("http://example.com/post/1")
+	if thread.FirstCommentAt == nil || !thread.FirstCommentAt.Equal(*comment.CreatedAt) {
+		t.Errorf("CreateComment() expected first comment at %v, but got %v", comment.CreatedAt, thread.FirstCommentAt)
+	}
+
+	thread.State = ThreadReadOnly
+	thread.AutoCloseDays = 30
+	thread.RequireModeration = true
+	if _, err := commenter.UpdateThread(thread); err != nil {
+		t.Fatalf("UpdateThread() error = %v", err)
+	}
+
+	thread.State = ThreadOpen
+	thread.RequireModeration = false
+	if _, err := commenter.UpdateThread(thread); err != nil {
+		t.Fatalf("UpdateThread() error = %v", err)
+	}
+
+	updated, _ := commenter.GetThread("http://example.com/post/1")
+	if updated.State != ThreadOpen || updated.AutoCloseDays != 30 || updated.RequireModeration {
+		t.Errorf("UpdateThread() did not save settings, got %+v", updated)
+	}
+
+	thread.State = "locked"
+	if _, err := commenter.UpdateThread(thread); err != ErrInvalidThreadState {
+		t.Errorf("UpdateThread() expected ErrInvalidThreadState, got %v", err)
+	}
+
+	if _, err := commenter.ForSite(1).UpdateThread(updated); err == nil {
+		t.Errorf("UpdateThread() expected thread to be hidden from other sites")
+	}
+}
@@ -0,0 +1,344 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserStore exposes methods to register users, verify their email
+// addresses, and manage their login sessions
+type UserStore interface {
+	CreateUser(*User, string) (*User, error)
+	GetUser(uint) (*User, error)
+	GetUserByUsername(string) (*User, error)
+	Authenticate(string, string) (*User, error)
+	VerifyEmail(string) (*User, error)
+	CreateSession(*User, time.Duration) (string, error)
+	GetSessionUser(string) (*User, error)
+	DeleteSession(string) error
+	LinkIdentity(*Identity, *User) (*User, error)
+}
+
+// Password length limits, bcrypt ignores anything past 72 bytes
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// usernamePattern matches the usernames users can register
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+// Errors returned when registering or authenticating users
+var (
+	ErrInvalidUsername    = errors.New("Username must be 3 to 32 letters, digits, dots, dashes or underscores")
+	ErrInvalidEmail       = errors.New("Email address is not valid")
+	ErrInvalidPassword    = errors.New("Password must be 8 to 72 characters")
+	ErrUsernameTaken      = errors.New("Username is already registered")
+	ErrEmailTaken         = errors.New("Email address is already registered")
+	ErrInvalidCredentials = errors.New("Username or password is incorrect")
+	ErrEmailNotVerified   = errors.New("Email address is not verified")
+)
+
+// User represents a registered commenter. Usernames of users are reserved,
+// so comments using them can only be posted by the user.
+type User struct {
+	ID            uint      `json:"id" gorm:"primary_key"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	Username      string    `json:"username" gorm:"unique_index"`
+	Email         string    `json:"email" sql:"index"`
+	EmailVerified bool      `json:"emailVerified"`
+	PasswordHash  string    `json:"-"`
+	VerifyToken   string    `json:"-" sql:"index"`
+}
+
+// Session represents a logged in user. Only a hash of the session token is
+// stored, so the database cannot be used to hijack sessions.
+type Session struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	TokenHash string `gorm:"unique_index"`
+	UserID    uint   `sql:"index"`
+}
+
+// Identity links a user to their account at an external identity provider
+type Identity struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time `json:"createdAt"`
+	Provider  string    `json:"provider" gorm:"unique_index:uix_identities_provider_subject"`
+	Subject   string    `json:"subject" gorm:"unique_index:uix_identities_provider_subject"`
+	UserID    uint      `json:"userId" sql:"index"`
+}
+
+// hashToken hashes session tokens for storage
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// SqliteUserStore implements a gorm based user store
+type SqliteUserStore struct {
+	DB *gorm.DB
+}
+
+// CreateUser validates and inserts user into database with a hash of
+// password. The user must verify their email address using the generated
+// verify token before logging in.
+func (s SqliteUserStore) CreateUser(user *User, password string) (*User, error) {
+	user.Username = strings.TrimSpace(user.Username)
+	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
+
+	if !usernamePattern.MatchString(user.Username) {
+		return nil, ErrInvalidUsername
+	} else if !validEmail(user.Email) {
+		return nil, ErrInvalidEmail
+	} else if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, ErrInvalidPassword
+	}
+
+	if _, err := s.GetUserByUsername(user.Username); err == nil {
+		return nil, ErrUsernameTaken
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if err := s.DB.Where("email = ?", user.Email).First(&User{}).Error; err == nil {
+		return nil, ErrEmailTaken
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := NewAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	user.ID = 0
+	user.PasswordHash = string(hash)
+	user.EmailVerified = false
+	user.VerifyToken = token
+
+	return user, s.DB.Create(user).Error
+}
+
+// GetUser fetches user by id from database
+func (s SqliteUserStore) GetUser(id uint) (*User, error) {
+	user := User{}
+	return &user, s.DB.First(&user, id).Error
+}
+
+// GetUserByUsername fetches user by username from database, ignoring case
+func (s SqliteUserStore) GetUserByUsername(username string) (*User, error) {
+	user := User{}
+	return &user, s.DB.Where("lower(username) = ?", strings.ToLower(strings.TrimSpace(username))).First(&user).Error
+}
+
+// Authenticate checks the password of the user with login as username or
+// email address
+func (s SqliteUserStore) Authenticate(login string, password string) (*User, error) {
+	login = strings.ToLower(strings.TrimSpace(login))
+
+	user := User{}
+	err := s.DB.Where("(lower(username) = ? OR email = ?) AND password_hash <> ''", login, login).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	} else if !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	return &user, nil
+}
+
+// VerifyEmail marks the email address of the user with the verify token as
+// verified. Tokens can only be used once.
+func (s SqliteUserStore) VerifyEmail(token string) (*User, error) {
+	if token == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	user := User{}
+	if err := s.DB.Where("verify_token = ?", token).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	err := s.DB.Model(&user).Updates(map[string]interface{}{
+		"email_verified": true,
+		"verify_token":   "",
+	}).Error
+	return &user, err
+}
+
+// CreateSession logs user in for ttl, returning the session token
+func (s SqliteUserStore) CreateSession(user *User, ttl time.Duration) (string, error) {
+	token, err := NewAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	session := &Session{
+		ExpiresAt: time.Now().Add(ttl),
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+	}
+	return token, s.DB.Create(session).Error
+}
+
+// GetSessionUser fetches the user logged in with session token, expired
+// sessions are not found
+func (s SqliteUserStore) GetSessionUser(token string) (*User, error) {
+	if token == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	session := Session{}
+	err := s.DB.Where("token_hash = ? AND expires_at > ?", hashToken(token), time.Now()).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(session.UserID)
+}
+
+// DeleteSession logs out the session with token
+func (s SqliteUserStore) DeleteSession(token string) error {
+	return s.DB.Where("token_hash = ?", hashToken(token)).Delete(&Session{}).Error
+}
+
+// availableUsername derives a username matching usernamePattern from name,
+// adding a number if it is already taken
+func (s SqliteUserStore) availableUsername(name string) (string, error) {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-", r)):
+			return r
+		case r == ' ':
+			return '_'
+		}
+		return -1
+	}, name)
+
+	if len(base) > 28 {
+		base = base[:28]
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+
+	for i := 1; i < 100; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%v%d", base, i)
+		}
+
+		if _, err := s.GetUserByUsername(username); err == gorm.ErrRecordNotFound {
+			return username, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	suffix, err := NewAPIKey()
+	if err != nil {
+		return "", err
+	}
+	return base + suffix[:4], nil
+}
+
+// LinkIdentity returns the user linked to identity. Unknown identities are
+// linked to the user with the same email address if the provider verified
+// it, otherwise a user without password is created from profile. Linking to a
+// user whose email address was never verified removes its password and
+// sessions, as whoever registered it did not prove they own the address.
+func (s SqliteUserStore) LinkIdentity(identity *Identity, profile *User) (*User, error) {
+	existing := Identity{}
+	err := s.DB.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&existing).Error
+	if err == nil {
+		return s.GetUser(existing.UserID)
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(profile.Email))
+
+	user := &User{}
+	err = gorm.ErrRecordNotFound
+	if email != "" {
+		err = s.DB.Where("email = ?", email).First(user).Error
+	}
+
+	switch {
+	case err == nil && profile.EmailVerified:
+		if !user.EmailVerified {
+			if err := s.claimUser(user); err != nil {
+				return nil, err
+			}
+		}
+	case err == nil || err == gorm.ErrRecordNotFound:
+		if err == nil {
+			// Unverified email addresses cannot claim existing accounts
+			email = ""
+		}
+
+		username, err := s.availableUsername(profile.Username)
+		if err != nil {
+			return nil, err
+		}
+
+		user = &User{
+			Username:      username,
+			Email:         email,
+			EmailVerified: profile.EmailVerified && email != "",
+		}
+		if err := s.DB.Create(user).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	identity.ID = 0
+	identity.UserID = user.ID
+	return user, s.DB.Create(identity).Error
+}
+
+// claimUser hands the unverified user over to the verified owner of its
+// email address, logging out and locking out whoever registered it
+func (s SqliteUserStore) claimUser(user *User) error {
+	tx := s.DB.Begin()
+	err := tx.Model(user).UpdateColumns(map[string]interface{}{
+		"email_verified": true,
+		"password_hash":  "",
+		"verify_token":   "",
+		"updated_at":     time.Now(),
+	}).Error
+	if err == nil {
+		err = tx.Where("user_id = ?", user.ID).Delete(&Session{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
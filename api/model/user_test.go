@@ -0,0 +1,286 @@
+package model
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/db"
+)
+
+func TestCreateUser(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	users := &SqliteUserStore{DB: db}
+	users.CreateUser(&User{Username: "alice", Email: "alice@example.com"}, "correct horse")
+
+	tests := []struct {
+		name     string
+		user     *User
+		password string
+		err      error
+	}{
+		{"Create user", &User{Username: "bob", Email: "Bob@Example.com"}, "correct horse", nil},
+		{"Create user with invalid username", &User{Username: "bob smith", Email: "bob@example.org"}, "correct horse", ErrInvalidUsername},
+		{"Create user with invalid email", &User{Username: "carol", Email: "carol"}, "correct horse", ErrInvalidEmail},
+		{"Create user with short password", &User{Username: "carol", Email: "carol@example.com"}, "short", ErrInvalidPassword},
+		{"Create user with taken username", &User{Username: "ALICE", Email: "carol@example.com"}, "correct horse", ErrUsernameTaken},
+		{"Create user with taken email", &User{Username: "carol", Email: "alice@example.com"}, "correct horse", ErrEmailTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := users.CreateUser(tt.user, tt.password)
+			if err != tt.err {
+				t.Fatalf("CreateUser() error = %v, wanted %v", err, tt.err)
+			} else if err != nil {
+				return
+			}
+
+			if user.PasswordHash == "" || user.PasswordHash == tt.password {
+				t.Errorf("CreateUser() expected password to be hashed")
+			}
+			if user.EmailVerified || user.VerifyToken == "" {
+				t.Errorf("CreateUser() expected unverified user with verify token, got %+v", user)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	users := &SqliteUserStore{DB: db}
+	alice, _ := users.CreateUser(&User{Username: "alice", Email: "alice@example.com"}, "correct horse")
+
+	if _, err := users.Authenticate("alice", "correct horse"); err != ErrEmailNotVerified {
+		t.Errorf("Authenticate() expected ErrEmailNotVerified before verification, got %v", err)
+	}
+
+	if _, err := users.VerifyEmail(alice.VerifyToken); err != nil {
+		t.Fatalf("VerifyEmail() error = %v", err)
+	}
+
+	if _, err := users.VerifyEmail(alice.VerifyToken); err == nil {
+		t.Errorf("VerifyEmail() expected token to only be usable once")
+	}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		wantErr  bool
+	}{
+		{"Log in with username", "Alice", "correct horse", false},
+		{"Log in with email", "alice@example.com", "correct horse", false},
+		{"Log in with wrong password", "alice", "wrong horse", true},
+		{"Log in as unknown user", "bob", "correct horse", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := users.Authenticate(tt.login, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			} else if tt.wantErr && err != ErrInvalidCredentials {
+				t.Errorf("Authenticate() expected ErrInvalidCredentials, got %v", err)
+			} else if !tt.wantErr && user.ID != alice.ID {
+				t.Errorf("Authenticate() wanted user %v, but got %v", alice.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	users := &SqliteUserStore{DB: db}
+	alice, _ := users.CreateUser(&User{Username: "alice", Email: "alice@example.com"}, "correct horse")
+
+	token, err := users.CreateSession(alice, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	expired, _ := users.CreateSession(alice, -time.Hour)
+
+	if user, err := users.GetSessionUser(token); err != nil || user.ID != alice.ID {
+		t.Errorf("GetSessionUser() expected alice, got %v, %v", user, err)
+	}
+
+	if session := (Session{}); db.Where("token_hash = ?", token).First(&session).Error == nil {
+		t.Errorf("CreateSession() expected session token to be hashed")
+	}
+
+	if _, err := users.GetSessionUser(expired); err == nil {
+		t.Errorf("GetSessionUser() expected expired session to be rejected")
+	}
+
+	if err := users.DeleteSession(token); err != nil {
+		t.Fatalf("DeleteSession() error = %v", err)
+	}
+
+	if _, err := users.GetSessionUser(token); err == nil {
+		t.Errorf("GetSessionUser() expected deleted session to be rejected")
+	}
+}
+
+func TestComment_Verified(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	commenter := &SqliteCommentStore{DB: db}
+
+	verified, _ := commenter.CreateComment(&Comment{Content: "Posted while logged in", URL: "http://example.com/post/1", UserID: 7})
+	guest, _ := commenter.CreateComment(&Comment{Content: "Posted as a guest", URL: "http://example.com/post/1"})
+
+	// Updates cannot change who posted a comment
+	commenter.UpdateComment(&Comment{ID: guest.ID, Content: "Edited", URL: guest.URL, UserID: 7})
+
+	comments, _ := commenter.GetComments("http://example.com/post/1", "")
+	if len(comments) != 2 {
+		t.Fatalf("GetComments() expected 2 comments, found %v", len(comments))
+	}
+
+	if *comments[0].ID != *verified.ID || !comments[0].Verified || comments[0].UserID != 7 {
+		t.Errorf("GetComments() expected comment by user to be verified, got %+v", comments[0])
+	}
+
+	if comments[1].Verified || comments[1].UserID != 0 {
+		t.Errorf("GetComments() expected guest comment to be unverified, got %+v", comments[1])
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	dbname := dbname()
+	db, err := db.DB(dbname)
+	if err != nil {
+		log.Fatal("Could not connect to database", err)
+	}
+	defer db.Close()
+	defer os.Remove(dbname)
+	setupDB(t, db)
+
+	users := &SqliteUserStore{DB: db}
+	alice, _ := users.CreateUser(&User{Username: "alice", Email: "alice@example.com"}, "correct horse")
+	aliceSession, _ := users.CreateSession(alice, time.Hour)
+	carol, _ := users.CreateUser(&User{Username: "carol", Email: "carol@example.com"}, "battery staple")
+	users.VerifyEmail(carol.VerifyToken)
+
+	tests := []struct {
+		name          string
+		subject       string
+		profile       *User
+		userID        uint
+		username      string
+		email         string
+		emailVerified bool
+	}{
+		{
+			name:          "Link identity with verified email to existing user",
+			subject:       "alice-id",
+			profile:       &User{Username: "Alice A", Email: "Alice@example.com", EmailVerified: true},
+			userID:        alice.ID,
+			username:      "alice",
+			email:         "alice@example.com",
+			emailVerified: true,
+		},
+		{
+			name:     "Link known identity",
+			subject:  "alice-id",
+			profile:  &User{Username: "someone else"},
+			userID:   alice.ID,
+			username: "alice",
+			email:    "alice@example.com",
+			// Linking verified the email address
+			emailVerified: true,
+		},
+		{
+			name:          "Link identity with verified email to verified user",
+			subject:       "carol-id",
+			profile:       &User{Username: "Carol", Email: "carol@example.com", EmailVerified: true},
+			userID:        carol.ID,
+			username:      "carol",
+			email:         "carol@example.com",
+			emailVerified: true,
+		},
+		{
+			name:     "Link identity with unverified email of existing user",
+			subject:  "mallory-id",
+			profile:  &User{Username: "alice", Email: "alice@example.com"},
+			username: "alice2",
+		},
+		{
+			name:          "Link new identity",
+			subject:       "bob-id",
+			profile:       &User{Username: "Bob Smith", Email: "bob@example.com", EmailVerified: true},
+			username:      "Bob_Smith",
+			email:         "bob@example.com",
+			emailVerified: true,
+		},
+		{
+			name:     "Link new identity without profile",
+			subject:  "anonymous-id",
+			profile:  &User{},
+			username: "user",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := users.LinkIdentity(&Identity{Provider: "https://id.example.com", Subject: tt.subject}, tt.profile)
+			if err != nil {
+				t.Fatalf("LinkIdentity() error = %v", err)
+			}
+
+			if tt.userID != 0 && user.ID != tt.userID {
+				t.Errorf("LinkIdentity() wanted user %v, but got %v", tt.userID, user.ID)
+			}
+
+			if user.Username != tt.username || user.Email != tt.email || user.EmailVerified != tt.emailVerified {
+				t.Errorf("LinkIdentity() wanted %v <%v> verified %v, but got %+v", tt.username, tt.email, tt.emailVerified, user)
+			}
+		})
+	}
+
+	if _, err := users.Authenticate("alice2", ""); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() expected users without password to be unable to log in, got %v", err)
+	}
+
+	// Whoever registered the unverified address is locked out once its owner
+	// links their identity
+	if _, err := users.Authenticate("alice", "correct horse"); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() expected the password of a claimed user to be removed, got %v", err)
+	} else if _, err := users.GetSessionUser(aliceSession); err == nil {
+		t.Errorf("GetSessionUser() expected the sessions of a claimed user to be removed")
+	}
+
+	if _, err := users.Authenticate("carol", "battery staple"); err != nil {
+		t.Errorf("Authenticate() expected verified users to keep their password when linked, got %v", err)
+	}
+}
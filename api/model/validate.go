@@ -0,0 +1,116 @@
+package model
+
+import (
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of the fields of a comment
+const (
+	MaxContentLength  = 10000
+	MaxUsernameLength = 64
+)
+
+// Field error codes describing why a field is invalid
+const (
+	CodeRequired   = "required"
+	CodeReadOnly   = "read_only"
+	CodeTooLong    = "too_long"
+	CodeInvalid    = "invalid"
+	CodeNotAllowed = "not_allowed"
+	CodeNotFound   = "not_found"
+)
+
+// commentUsernamePattern matches the names guests can comment with, which
+// unlike registered usernames may contain any letters and spaces
+var commentUsernamePattern = regexp.MustCompile(`^[\p{L}\p{M}\p{N} ._'-]+$`)
+
+// FieldError describes an invalid field of a comment using its json name
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a comment
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Validate checks a comment payload, which must not contain any of the
+// fields set by the database, and whose editable fields must be valid
+func Validate(comment *Comment) error {
+	deniedFields := []struct{ property, field string }{
+		{"ID", "id"}, {"CreatedAt", "createdAt"}, {"UpdatedAt", "updatedAt"}, {"DeletedAt", "deletedAt"},
+	}
+
+	v := reflect.ValueOf(*comment)
+
+	errs := ValidationError{}
+
+	for _, denied := range deniedFields {
+		if v.FieldByName(denied.property).Pointer() != 0 {
+			errs = append(errs, FieldError{denied.field, CodeReadOnly, denied.property + " is set by the server"})
+		}
+	}
+
+	if err := ValidateFields(comment); err != nil {
+		errs = append(errs, err.(ValidationError)...)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ValidateFields checks the editable fields of a comment. Checks that need
+// the database, like the parent existing, are left to the caller.
+func ValidateFields(comment *Comment) error {
+	errs := ValidationError{}
+
+	if strings.TrimSpace(comment.Content) == "" {
+		errs = append(errs, FieldError{"content", CodeRequired, "Content is required"})
+	} else if utf8.RuneCountInString(comment.Content) > MaxContentLength {
+		errs = append(errs, FieldError{"content", CodeTooLong, "Content must be at most 10000 characters"})
+	}
+
+	if utf8.RuneCountInString(comment.Username) > MaxUsernameLength {
+		errs = append(errs, FieldError{"username", CodeTooLong, "Username must be at most 64 characters"})
+	} else if comment.Username != "" && !commentUsernamePattern.MatchString(comment.Username) {
+		errs = append(errs, FieldError{"username", CodeInvalid, "Username may only contain letters, digits, spaces, dots, dashes, apostrophes or underscores"})
+	}
+
+	if comment.Email != "" && !validEmail(comment.Email) {
+		errs = append(errs, FieldError{"email", CodeInvalid, ErrInvalidEmail.Error()})
+	}
+
+	if comment.URL == "" {
+		errs = append(errs, FieldError{"url", CodeRequired, "URL is required"})
+	} else if u, err := url.Parse(comment.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{"url", CodeInvalid, "URL must be an absolute http or https url"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validEmail checks that email looks like an email address, the only way to
+// know for sure being to send it an email
+func validEmail(email string) bool {
+	at := strings.Index(email, "@")
+	return at > 0 && at < len(email)-1 && !strings.ContainsAny(email, " \t\r\n")
+}
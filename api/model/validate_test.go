@@ -0,0 +1,72 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		comment *Comment
+		codes   map[string]string
+	}{
+		{
+			name:    "Valid comment",
+			comment: &Comment{Content: "Some content", Username: "Åse O'Neil", Email: "ase@example.com", URL: "https://example.com/post/1"},
+		},
+		{
+			name:    "Comment with database fields",
+			comment: &Comment{CreatedAt: &now, Content: "Some content", URL: "https://example.com/post/1"},
+			codes:   map[string]string{"createdAt": CodeReadOnly},
+		},
+		{
+			name:    "Comment without content or url",
+			comment: &Comment{Content: " \n", Username: "guest"},
+			codes:   map[string]string{"content": CodeRequired, "url": CodeRequired},
+		},
+		{
+			name:    "Comment with too long fields",
+			comment: &Comment{Content: strings.Repeat("ø", MaxContentLength+1), Username: strings.Repeat("a", MaxUsernameLength+1), URL: "https://example.com/post/1"},
+			codes:   map[string]string{"content": CodeTooLong, "username": CodeTooLong},
+		},
+		{
+			name:    "Comment with malformed fields",
+			comment: &Comment{Content: "Some content", Username: "<b>guest</b>", Email: "guest@", URL: "example.com/post/1"},
+			codes:   map[string]string{"username": CodeInvalid, "email": CodeInvalid, "url": CodeInvalid},
+		},
+		{
+			name:    "Comment with url that is not http",
+			comment: &Comment{Content: "Some content", URL: "javascript:alert(1)"},
+			codes:   map[string]string{"url": CodeInvalid},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.comment)
+			if tt.codes == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v, wanted none", err)
+				}
+				return
+			}
+
+			fieldErrs, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("Validate() expected ValidationError, got %v", err)
+			}
+
+			codes := map[string]string{}
+			for _, fieldErr := range fieldErrs {
+				codes[fieldErr.Field] = fieldErr.Code
+			}
+			if !reflect.DeepEqual(codes, tt.codes) {
+				t.Errorf("Validate() wanted field errors %v, but got %v", tt.codes, codes)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// bearerToken returns the bearer token of the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// moderator returns the name of the moderator making the request, or an
+// empty string if the request is not made by a moderator. Moderators
+// authenticate with the admin key as a bearer token, or with a site api key
+// which grants moderation of that site. Dashboard requests are made by the
+// moderator logged in to the dashboard.
+func (router *Router) moderator(r *http.Request) string {
+	if moderator, ok := r.Context().Value(moderatorContextKey).(string); ok {
+		return moderator
+	}
+
+	token := bearerToken(r)
+	if router.AdminKey != "" && token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(router.AdminKey)) == 1 {
+		return "admin"
+	}
+
+	if site := siteFromRequest(r); site != nil && r.Header.Get(SiteKeyHeader) != "" {
+		return "site:" + site.Name
+	}
+
+	return ""
+}
+
+// requireModerator only passes requests made by moderators on to next
+func (router *Router) requireModerator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if router.moderator(r) == "" {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// ReasonHeader is the request header moderators give the reason for an
+// action in, which is recorded in the audit log
+const ReasonHeader = "X-Moderation-Reason"
+
+// maxAuditLimit caps the number of audit entries returned at once
+const maxAuditLimit = 1000
+
+// anonymousActor is recorded for readers who cannot be identified
+const anonymousActor = "anonymous"
+
+// audit records the action of the moderator or reader making the request on
+// the target with id in the audit log of the site. Readers are recorded as
+// identified by reader, and only moderators give a reason. The action has
+// already been carried out, so failing to record it is only logged.
+func (router *Router) audit(r *http.Request, action string, targetType string, id uint, before interface{}, after interface{}) {
+	if router.Audit == nil {
+		return
+	}
+
+	actor, reason := router.moderator(r), r.Header.Get(ReasonHeader)
+	if actor == "" {
+		actor, reason = router.reader(r), ""
+	}
+	if actor == "" {
+		actor = anonymousActor
+	}
+
+	entry := &model.AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   id,
+		Reason:     reason,
+		Before:     model.Snapshot(before),
+		After:      model.Snapshot(after),
+	}
+	if err := router.Audit.ForSite(siteID(r)).RecordAudit(entry); err != nil {
+		log.Printf("Could not record %v of %v %v in audit log: %v", action, targetType, id, err)
+	}
+}
+
+// auditQuery parses the filters of an audit log request
+func auditQuery(r *http.Request) (model.AuditQuery, *httpResponse) {
+	values := r.URL.Query()
+	query := model.AuditQuery{
+		Actor:      values.Get("actor"),
+		Action:     values.Get("action"),
+		TargetType: values.Get("targetType"),
+	}
+
+	invalid := func(name string) *httpResponse {
+		return &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Invalid value of query parameter " + name + ".",
+		}
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *uint
+	}{{"targetId", &query.TargetID}, {"after", &query.AfterID}} {
+		if value := values.Get(param.name); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return query, invalid(param.name)
+			}
+			*param.value = uint(parsed)
+		}
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		if value := values.Get(param.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, invalid(param.name)
+			}
+			*param.value = parsed
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return query, invalid("limit")
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// auditHandlerGet lists the audit log of the site, the newest entries first
+func (router *Router) auditHandlerGet(w http.ResponseWriter, r *http.Request) {
+	query, httpErr := auditQuery(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	entries, err := router.Audit.ForSite(siteID(r)).GetAuditEntries(query)
+
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not get audit log.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, entries, http.StatusOK)
+}
@@ -0,0 +1,256 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+type mockAuditStore struct {
+	entries *[]*model.AuditEntry
+	query   *model.AuditQuery
+}
+
+func (s mockAuditStore) RecordAudit(entry *model.AuditEntry) error {
+	if entry.Reason == "Error" {
+		return errors.New("Failed to record audit entry")
+	}
+	*s.entries = append(*s.entries, entry)
+	return nil
+}
+
+func (s mockAuditStore) GetAuditEntries(query model.AuditQuery) ([]*model.AuditEntry, error) {
+	*s.query = query
+	return []*model.AuditEntry{{ID: 1, Actor: "admin", Action: model.AuditDelete, TargetType: model.AuditTargetComment, TargetID: 1}}, nil
+}
+
+func (s mockAuditStore) ForSite(siteID uint) model.AuditStore {
+	return s
+}
+
+func Test_server_auditHandlerGet(t *testing.T) {
+	store := mockAuditStore{entries: &[]*model.AuditEntry{}, query: &model.AuditQuery{}}
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Audit:     store,
+		AdminKey:  "admin-secret",
+	}
+
+	invalid := func(name string) *httpResponse {
+		return &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Invalid value of query parameter " + name + ".",
+		}
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		statusCode int
+		query      model.AuditQuery
+		response   *httpResponse
+	}{
+		{
+			name:       "Get audit log",
+			path:       "/admin/audit",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Get audit log with filters",
+			path:       "/admin/audit?actor=admin&action=delete&targetType=comment&targetId=1&after=4&limit=10",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			query:      model.AuditQuery{Actor: "admin", Action: "delete", TargetType: "comment", TargetID: 1, AfterID: 4, Limit: 10},
+		},
+		{
+			name:       "Get audit log without moderator credentials",
+			path:       "/admin/audit",
+			statusCode: http.StatusUnauthorized,
+			response: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Get audit log with invalid target id",
+			path:       "/admin/audit?targetId=one",
+			token:      "admin-secret",
+			statusCode: http.StatusBadRequest,
+			response:   invalid("targetId"),
+		},
+		{
+			name:       "Get audit log with invalid since",
+			path:       "/admin/audit?since=yesterday",
+			token:      "admin-secret",
+			statusCode: http.StatusBadRequest,
+			response:   invalid("since"),
+		},
+		{
+			name:       "Get audit log with too large limit",
+			path:       "/admin/audit?limit=5000",
+			token:      "admin-secret",
+			statusCode: http.StatusBadRequest,
+			response:   invalid("limit"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*store.query = model.AuditQuery{}
+
+			request, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.response == nil {
+				if !reflect.DeepEqual(*store.query, tt.query) {
+					t.Errorf("Expected audit query to be %v, but got %v", tt.query, *store.query)
+				}
+				return
+			}
+
+			response := &httpResponse{}
+			if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+				t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+			}
+
+			if !reflect.DeepEqual(response, tt.response) {
+				t.Errorf("Expected json response to be %v, but got %v", tt.response, response)
+			}
+		})
+	}
+}
+
+func Test_router_audit(t *testing.T) {
+	readerHash := model.ClientPolicy{}.HashIP(net.ParseIP("192.0.2.10"))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		remoteAddr string
+		token      string
+		reason     string
+		statusCode int
+		entry      *model.AuditEntry
+	}{
+		{
+			name:       "Moderator edits comment",
+			method:     "PATCH",
+			path:       "/1",
+			body:       `{"content": "Edited content"}`,
+			token:      "admin-secret",
+			reason:     "Typo",
+			statusCode: http.StatusOK,
+			entry:      &model.AuditEntry{Actor: "admin", Action: model.AuditEdit, TargetType: model.AuditTargetComment, TargetID: 1, Reason: "Typo"},
+		},
+		{
+			name:       "Moderator approves comment",
+			method:     "PATCH",
+			path:       "/1",
+			body:       `{"status": "Approved"}`,
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			entry:      &model.AuditEntry{Actor: "admin", Action: model.AuditStatus, TargetType: model.AuditTargetComment, TargetID: 1},
+		},
+		{
+			name:       "Moderator pins comment",
+			method:     "PUT",
+			path:       "/1/pin",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			entry:      &model.AuditEntry{Actor: "admin", Action: model.AuditPin, TargetType: model.AuditTargetComment, TargetID: 1},
+		},
+		{
+			name:       "Reader edits comment",
+			method:     "PATCH",
+			path:       "/1",
+			body:       `{"content": "Edited content"}`,
+			remoteAddr: "192.0.2.10:1234",
+			reason:     "Made up",
+			statusCode: http.StatusOK,
+			entry:      &model.AuditEntry{Actor: "ip:" + readerHash, Action: model.AuditEdit, TargetType: model.AuditTargetComment, TargetID: 1},
+		},
+		{
+			name:       "Reader deletes comment",
+			method:     "DELETE",
+			path:       "/1",
+			statusCode: http.StatusOK,
+			entry:      &model.AuditEntry{Actor: "anonymous", Action: model.AuditDelete, TargetType: model.AuditTargetComment, TargetID: 1},
+		},
+		{
+			name:       "Recording fails",
+			method:     "PUT",
+			path:       "/1/pin",
+			token:      "admin-secret",
+			reason:     "Error",
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := mockAuditStore{entries: &[]*model.AuditEntry{}, query: &model.AuditQuery{}}
+			router := &Router{
+				Commenter: &mockCommentStore{},
+				Audit:     store,
+				AdminKey:  "admin-secret",
+			}
+
+			request, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			request.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.reason != "" {
+				request.Header.Set(ReasonHeader, tt.reason)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.entry == nil {
+				if len(*store.entries) != 0 {
+					t.Errorf("Expected no audit entries, but got %v", len(*store.entries))
+				}
+				return
+			}
+
+			if len(*store.entries) != 1 {
+				t.Fatalf("Expected 1 audit entry, but got %v", len(*store.entries))
+			}
+
+			entry := (*store.entries)[0]
+			if entry.After == nil {
+				t.Errorf("Expected audit entry to snapshot comment after %v", tt.name)
+			}
+			entry.Before, entry.After = nil, nil
+
+			if !reflect.DeepEqual(entry, tt.entry) {
+				t.Errorf("Expected audit entry to be %v, but got %v", tt.entry, entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// bans returns the ban store scoped to the site of the request
+func (router *Router) bans(r *http.Request) model.BanStore {
+	return router.Bans.ForSite(siteID(r))
+}
+
+// checkBan checks the poster of a request with email and username against
+// the bans of the site. Banned posters are refused, while shadow bans are
+// returned so the caller can hide what the poster does.
+func (router *Router) checkBan(r *http.Request, email, username string) (*model.Ban, *httpResponse) {
+	if router.Bans == nil {
+		return nil, nil
+	}
+
+	ban, err := router.bans(r).FindBan(email, username, router.clientIP(r))
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not check bans.",
+		}
+	} else if !ban.Shadow {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusForbidden,
+			Message:     http.StatusText(http.StatusForbidden),
+			Description: "You are banned from this site.",
+		}
+	}
+
+	return ban, nil
+}
+
+// checkVoterBan checks readers reacting to or flagging comments against the
+// bans of the site, using the account of logged in readers
+func (router *Router) checkVoterBan(r *http.Request) (*model.Ban, *httpResponse) {
+	email, username := "", ""
+	if user := router.sessionUser(r); user != nil {
+		email, username = user.Email, user.Username
+	}
+	return router.checkBan(r, email, username)
+}
+
+// visibleComments leaves out shadowed comments and comments held for
+// moderation, unless the request is made by a moderator or by the poster of
+// the comment, identified by the hash of their ip address or their user
+// account
+func (router *Router) visibleComments(r *http.Request, comments []*model.Comment) []*model.Comment {
+	hidden := false
+	for _, comment := range comments {
+		hidden = hidden || comment.Shadowed || comment.Status == model.StatusPending
+	}
+
+	if !hidden || router.moderator(r) != "" {
+		return comments
+	}
+
+	ipHash := router.Clients.HashIP(router.clientIP(r))
+	user := router.sessionUser(r)
+
+	visible := make([]*model.Comment, 0, len(comments))
+	for _, comment := range comments {
+		if (!comment.Shadowed && comment.Status != model.StatusPending) ||
+			(ipHash != "" && comment.ClientIPHash == ipHash) ||
+			(user != nil && comment.UserID == user.ID) {
+			visible = append(visible, comment)
+		}
+	}
+	return visible
+}
+
+func (router *Router) banHandlerGetAll(w http.ResponseWriter, r *http.Request) {
+	bans, err := router.bans(r).GetBans()
+
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not get bans.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, bans, http.StatusOK)
+}
+
+func (router *Router) banHandlerPost(w http.ResponseWriter, r *http.Request) {
+	ban := &model.Ban{}
+	if err := json.NewDecoder(r.Body).Decode(ban); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode ban in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	ban.CreatedBy = router.moderator(r)
+	ban, err := router.bans(r).CreateBan(ban)
+
+	if fieldErrs, ok := err.(model.ValidationError); ok {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Ban contains illegal fields.",
+			Errors:      fieldErrs,
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to create ban.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.audit(r, model.AuditBan, model.AuditTargetBan, ban.ID, nil, ban)
+
+	jsonResponse(w, ban, http.StatusCreated)
+}
+
+func (router *Router) banHandlerDelete(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	ban, err := router.bans(r).DeleteBan(*id)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find ban with id %v.", *id),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to remove ban.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.audit(r, model.AuditUnban, model.AuditTargetBan, *id, ban, nil)
+
+	response := httpResponse{
+		StatusCode:  http.StatusOK,
+		Message:     http.StatusText(http.StatusOK),
+		Description: "Ban successfully removed.",
+	}
+	jsonResponse(w, response, response.StatusCode)
+}
@@ -0,0 +1,380 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+type mockBanStore struct{}
+
+func (s mockBanStore) GetBans() ([]*model.Ban, error) {
+	return []*model.Ban{{ID: 1, Kind: model.BanEmail, Value: "troll@example.com"}}, nil
+}
+
+func (s mockBanStore) CreateBan(ban *model.Ban) (*model.Ban, error) {
+	if ban.Kind != model.BanEmail {
+		return nil, model.ValidationError{{Field: "kind", Code: model.CodeInvalid, Message: "Kind must be email, domain, ip or username"}}
+	}
+	ban.ID = 2
+	return ban, nil
+}
+
+func (s mockBanStore) DeleteBan(id uint) (*model.Ban, error) {
+	if id != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &model.Ban{ID: 1, Kind: model.BanEmail, Value: "troll@example.com"}, nil
+}
+
+func (s mockBanStore) FindBan(email, username string, ip net.IP) (*model.Ban, error) {
+	switch {
+	case email == "troll@example.com":
+		return &model.Ban{ID: 1, Kind: model.BanEmail, Value: email}, nil
+	case ip != nil && ip.Equal(net.ParseIP("192.0.2.66")):
+		return &model.Ban{ID: 3, Kind: model.BanIP, Value: "192.0.2.66/32"}, nil
+	case username == "shadow":
+		return &model.Ban{ID: 4, Kind: model.BanUsername, Value: username, Shadow: true}, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockBanStore) ForSite(siteID uint) model.BanStore {
+	return s
+}
+
+func Test_server_banHandlers(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Bans:      mockBanStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		statusCode int
+		bans       []*model.Ban
+		ban        *model.Ban
+		response   *httpResponse
+	}{
+		{
+			name:       "List bans",
+			method:     "GET",
+			path:       "/admin/bans",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			bans:       []*model.Ban{{ID: 1, Kind: model.BanEmail, Value: "troll@example.com"}},
+		},
+		{
+			name:       "List bans without moderator credentials",
+			method:     "GET",
+			path:       "/admin/bans",
+			statusCode: http.StatusUnauthorized,
+			response: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Add ban",
+			method:     "POST",
+			path:       "/admin/bans",
+			body:       `{"kind": "email", "value": "troll@example.com", "reason": "Spam"}`,
+			token:      "admin-secret",
+			statusCode: http.StatusCreated,
+			ban:        &model.Ban{ID: 2, Kind: model.BanEmail, Value: "troll@example.com", Reason: "Spam", CreatedBy: "admin"},
+		},
+		{
+			name:       "Add invalid ban",
+			method:     "POST",
+			path:       "/admin/bans",
+			body:       `{"kind": "country", "value": "xx"}`,
+			token:      "admin-secret",
+			statusCode: http.StatusBadRequest,
+			response: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Ban contains illegal fields.",
+				Errors:      []model.FieldError{{Field: "kind", Code: model.CodeInvalid, Message: "Kind must be email, domain, ip or username"}},
+			},
+		},
+		{
+			name:       "Add badly formatted ban",
+			method:     "POST",
+			path:       "/admin/bans",
+			body:       "Not json",
+			token:      "admin-secret",
+			statusCode: http.StatusBadRequest,
+			response: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Could not decode ban in payload.",
+			},
+		},
+		{
+			name:       "Remove ban",
+			method:     "DELETE",
+			path:       "/admin/bans/1",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			response: &httpResponse{
+				StatusCode:  http.StatusOK,
+				Message:     http.StatusText(http.StatusOK),
+				Description: "Ban successfully removed.",
+			},
+		},
+		{
+			name:       "Remove ban not in db",
+			method:     "DELETE",
+			path:       "/admin/bans/9",
+			token:      "admin-secret",
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find ban with id 9.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			var got, want interface{}
+			switch {
+			case tt.bans != nil:
+				got, want = &[]*model.Ban{}, &tt.bans
+			case tt.ban != nil:
+				got, want = &model.Ban{}, tt.ban
+			default:
+				got, want = &httpResponse{}, tt.response
+			}
+
+			if err := json.NewDecoder(recorder.Body).Decode(got); err != nil {
+				t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected json response to be %v, but got %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_server_bannedPosters(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Bans:      mockBanStore{},
+	}
+
+	banned := &httpResponse{
+		StatusCode:  http.StatusForbidden,
+		Message:     http.StatusText(http.StatusForbidden),
+		Description: "You are banned from this site.",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		comment    *model.Comment
+		remoteAddr string
+		statusCode int
+		response   *httpResponse
+	}{
+		{
+			name:       "Post comment",
+			method:     "POST",
+			path:       "/?url=http://example.com/posts/1",
+			comment:    &model.Comment{Content: "Some content", Email: "alice@example.com"},
+			remoteAddr: "192.0.2.10:1234",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Post comment with banned email",
+			method:     "POST",
+			path:       "/?url=http://example.com/posts/1",
+			comment:    &model.Comment{Content: "Some content", Email: "troll@example.com"},
+			remoteAddr: "192.0.2.10:1234",
+			statusCode: http.StatusForbidden,
+			response:   banned,
+		},
+		{
+			name:       "Post comment from banned ip",
+			method:     "POST",
+			path:       "/?url=http://example.com/posts/1",
+			comment:    &model.Comment{Content: "Some content"},
+			remoteAddr: "192.0.2.66:1234",
+			statusCode: http.StatusForbidden,
+			response:   banned,
+		},
+		{
+			name:       "Post comment under shadow ban",
+			method:     "POST",
+			path:       "/?url=http://example.com/posts/1",
+			comment:    &model.Comment{Content: "Some content", Username: "shadow"},
+			remoteAddr: "192.0.2.10:1234",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "React from banned ip",
+			method:     "POST",
+			path:       "/1/reactions/like",
+			remoteAddr: "192.0.2.66:1234",
+			statusCode: http.StatusForbidden,
+			response:   banned,
+		},
+		{
+			name:       "React",
+			method:     "POST",
+			path:       "/1/reactions/like",
+			remoteAddr: "192.0.2.10:1234",
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			payload, _ := json.Marshal(tt.comment)
+			request, _ := http.NewRequest(tt.method, tt.path, bytes.NewBuffer(payload))
+			request.RemoteAddr = tt.remoteAddr
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.response == nil {
+				return
+			}
+
+			response := &httpResponse{}
+			if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+				t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+			}
+
+			if !reflect.DeepEqual(response, tt.response) {
+				t.Errorf("Expected json response to be %v, but got %v", tt.response, response)
+			}
+		})
+	}
+}
+
+func Test_router_visibleComments(t *testing.T) {
+	router := &Router{AdminKey: "admin-secret"}
+	poster := router.Clients.HashIP(net.ParseIP("192.0.2.10"))
+
+	id1, id2, id3 := uint(1), uint(2), uint(3)
+	comments := []*model.Comment{
+		{ID: &id1, Content: "Some content"},
+		{ID: &id2, Content: "Troll content", Shadowed: true, ClientIPHash: poster},
+		{ID: &id3, Content: "Flagged content", Status: model.StatusPending, ClientIPHash: poster},
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		token      string
+		want       int
+	}{
+		{name: "Other reader", remoteAddr: "198.51.100.1:1234", want: 1},
+		{name: "Poster", remoteAddr: "192.0.2.10:1234", want: 3},
+		{name: "Moderator", remoteAddr: "198.51.100.1:1234", token: "admin-secret", want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/?url=http://example.com/posts/1", nil)
+			request.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			if got := router.visibleComments(request, comments); len(got) != tt.want {
+				t.Errorf("Expected %v visible comments, but got %v", tt.want, len(got))
+			}
+		})
+	}
+}
+
+// mockHiddenCommentStore returns comment 1 held for moderation and comment 2
+// shadowed, both posted from 192.0.2.10
+type mockHiddenCommentStore struct {
+	mockCommentStore
+}
+
+func (c mockHiddenCommentStore) GetComment(id uint) (*model.Comment, error) {
+	comment, err := c.mockCommentStore.GetComment(1)
+	if err != nil || id > 2 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	comment.ID = &id
+	comment.ClientIPHash = model.ClientPolicy{}.HashIP(net.ParseIP("192.0.2.10"))
+	comment.Status = model.StatusPending
+	comment.Shadowed = id == 2
+	return comment, nil
+}
+
+func (c mockHiddenCommentStore) ForSite(siteID uint) model.CommentStore {
+	return c
+}
+
+func Test_server_commentHandlerGetHidden(t *testing.T) {
+	router := &Router{
+		Commenter: mockHiddenCommentStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		remoteAddr string
+		token      string
+		statusCode int
+	}{
+		{name: "Get held comment as other reader", id: "1", remoteAddr: "198.51.100.1:1234", statusCode: http.StatusNotFound},
+		{name: "Get shadowed comment as other reader", id: "2", remoteAddr: "198.51.100.1:1234", statusCode: http.StatusNotFound},
+		{name: "Get shadowed comment as poster", id: "2", remoteAddr: "192.0.2.10:1234", statusCode: http.StatusOK},
+		{name: "Get held comment as moderator", id: "1", remoteAddr: "198.51.100.1:1234", token: "admin-secret", statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/"+tt.id, nil)
+			request.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			router.Router().ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+		})
+	}
+}
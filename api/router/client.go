@@ -0,0 +1,86 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// clientIP returns the ip address of the client making the request. The
+// X-Forwarded-For header is only trusted when the request comes from one
+// of the trusted proxies, and is read from the right so clients cannot
+// spoof addresses by sending the header themselves.
+func (router *Router) clientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !router.trustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !router.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteIP returns the ip address the request was received from, nil if
+// it cannot be parsed
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (router *Router) trustedProxy(ip net.IP) bool {
+	for _, network := range router.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientHandlerGet shows moderators the ip address and user agent a comment
+// was posted from, as far as the privacy settings keep them
+func (router *Router) clientHandlerGet(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	info, err := router.commenter(r).GetClientInfo(*id)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to get client of comment.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, info, http.StatusOK)
+}
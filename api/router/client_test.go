@@ -0,0 +1,162 @@
+package router
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+func Test_router_clientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	router := &Router{TrustedProxies: []*net.IPNet{proxies}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{
+			name:       "Direct client",
+			remoteAddr: "192.0.2.10:51234",
+			want:       "192.0.2.10",
+		},
+		{
+			name:       "Direct client spoofing forwarded header",
+			remoteAddr: "192.0.2.10:51234",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "192.0.2.10",
+		},
+		{
+			name:       "Client behind trusted proxy",
+			remoteAddr: "10.0.0.1:51234",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Client behind trusted proxies spoofing forwarded header",
+			remoteAddr: "10.0.0.1:51234",
+			forwarded:  []string{"203.0.113.7, 198.51.100.1", "10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Trusted proxy with invalid forwarded header",
+			remoteAddr: "10.0.0.1:51234",
+			forwarded:  []string{"unknown"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "IPv6 client",
+			remoteAddr: "[2001:db8::1]:51234",
+			want:       "2001:db8::1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				request.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := router.clientIP(request).String(); got != tt.want {
+				t.Errorf("Expected client ip %v, but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_server_clientHandlerGet(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	createdAt := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		statusCode int
+		info       *model.ClientInfo
+		response   *httpResponse
+	}{
+		{
+			name:       "Get client of comment",
+			path:       "/1/client",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			info: &model.ClientInfo{
+				CommentID:    1,
+				CreatedAt:    &createdAt,
+				ClientIP:     "192.0.2.0",
+				ClientIPHash: "hash",
+				UserAgent:    "Mozilla/5.0",
+			},
+		},
+		{
+			name:       "Get client without moderator credentials",
+			path:       "/1/client",
+			statusCode: http.StatusUnauthorized,
+			response: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Get client of comment not in db",
+			path:       "/1000/client",
+			token:      "admin-secret",
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.info != nil {
+				info := &model.ClientInfo{}
+				if err := json.NewDecoder(recorder.Body).Decode(info); err != nil {
+					t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+				}
+				if !reflect.DeepEqual(info, tt.info) {
+					t.Errorf("Expected client info to be %v, but got %v", tt.info, info)
+				}
+				return
+			}
+
+			response := &httpResponse{}
+			if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+				t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+			}
+
+			if !reflect.DeepEqual(response, tt.response) {
+				t.Errorf("Expected json response to be %v, but got %v", tt.response, response)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// corsMethods lists the methods a cors policy may allow
+var corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// corsSimpleHeaders are always allowed in cross origin requests
+var corsSimpleHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Origin"}
+
+// corsExposedHeaders are response headers scripts on other origins may read
+var corsExposedHeaders = []string{"ETag"}
+
+// CORSPolicy configures which cross origin requests are allowed
+type CORSPolicy struct {
+	// AllowedOrigins lists origins allowed in addition to site origins, see
+	// model.MatchOrigin for the supported patterns
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	MaxAge           time.Duration
+	AllowCredentials bool
+}
+
+// DefaultCORSPolicy returns the policy used when none is configured
+func DefaultCORSPolicy() *CORSPolicy {
+	return &CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"X-Requested-With", "Content-Type", "Authorization", "If-Match", "If-None-Match", SiteKeyHeader, SSOTokenHeader, ReasonHeader},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Validate checks the policy for unknown methods, malformed origins, and
+// combinations browsers reject
+func (p *CORSPolicy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if err := model.ValidateOrigin(origin); err != nil {
+			return err
+		} else if origin == "*" && p.AllowCredentials {
+			return errors.New("Origin * cannot be allowed together with credentials")
+		}
+	}
+
+	for _, method := range p.AllowedMethods {
+		if !containsFold(corsMethods, method) {
+			return fmt.Errorf("Unknown method %v", method)
+		}
+	}
+
+	for _, header := range p.AllowedHeaders {
+		if header == "" || strings.ContainsAny(header, " \t,:") {
+			return fmt.Errorf("Invalid header name %q", header)
+		}
+	}
+
+	if p.MaxAge < 0 {
+		return errors.New("Max age cannot be negative")
+	}
+
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS applies a cross origin resource sharing policy that can be replaced
+// while serving requests
+type CORS struct {
+	// Sites allows the origins of every site in addition to the policy
+	// origins, only policy origins are allowed if nil
+	Sites model.SiteStore
+
+	policy atomic.Value
+}
+
+// NewCORS validates policy and returns a CORS handler applying it
+func NewCORS(policy *CORSPolicy, sites model.SiteStore) (*CORS, error) {
+	cors := &CORS{Sites: sites}
+	if err := cors.Update(policy); err != nil {
+		return nil, err
+	}
+	return cors, nil
+}
+
+// Update replaces the current policy if policy is valid
+func (c *CORS) Update(policy *CORSPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	c.policy.Store(policy)
+	return nil
+}
+
+// Policy returns the current policy
+func (c *CORS) Policy() *CORSPolicy {
+	return c.policy.Load().(*CORSPolicy)
+}
+
+func (c *CORS) allowsOrigin(policy *CORSPolicy, origin string) bool {
+	for _, pattern := range policy.AllowedOrigins {
+		if model.MatchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	if c.Sites == nil {
+		return false
+	}
+
+	_, err := c.Sites.GetSiteByOrigin(origin)
+	return err == nil
+}
+
+func (c *CORS) allowsHeaders(policy *CORSPolicy, requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" || containsFold(corsSimpleHeaders, header) {
+			continue
+		} else if !containsFold(policy.AllowedHeaders, header) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, policy *CORSPolicy) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+
+	if !c.allowsOrigin(policy, origin) ||
+		!containsFold(policy.AllowedMethods, method) ||
+		!c.allowsHeaders(policy, r.Header.Get("Access-Control-Request-Headers")) {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusForbidden,
+			Message:     http.StatusText(http.StatusForbidden),
+			Description: "Cross origin request is not allowed.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+	if len(policy.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+	}
+	if policy.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	if policy.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler wraps next, answering preflight requests and adding cors headers
+// to responses for allowed origins
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := c.Policy()
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			c.preflight(w, r, policy)
+			return
+		}
+
+		if c.allowsOrigin(policy, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			if policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
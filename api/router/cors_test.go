@@ -0,0 +1,279 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCORSPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *CORSPolicy
+		wantErr bool
+	}{
+		{
+			name:   "Default policy is valid",
+			policy: DefaultCORSPolicy(),
+		},
+		{
+			name: "Wildcard subdomains with credentials are valid",
+			policy: &CORSPolicy{
+				AllowedOrigins:   []string{"https://*.example.com"},
+				AllowedMethods:   []string{"GET"},
+				AllowCredentials: true,
+			},
+		},
+		{
+			name: "Any origin with credentials is invalid",
+			policy: &CORSPolicy{
+				AllowedOrigins:   []string{"*"},
+				AllowCredentials: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Malformed origin is invalid",
+			policy: &CORSPolicy{
+				AllowedOrigins: []string{"example.com/path"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unknown method is invalid",
+			policy: &CORSPolicy{
+				AllowedMethods: []string{"FETCH"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Malformed header is invalid",
+			policy: &CORSPolicy{
+				AllowedHeaders: []string{"Content-Type, X-Foo"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative max age is invalid",
+			policy: &CORSPolicy{
+				MaxAge: -time.Second,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_CORS_preflightEveryRoute(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+	}
+	muxRouter := router.Router()
+
+	policy := DefaultCORSPolicy()
+	policy.AllowedOrigins = []string{"https://example.com"}
+	policy.AllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	cors, err := NewCORS(policy, nil)
+	if err != nil {
+		t.Fatalf("NewCORS() error = %v", err)
+	}
+	handler := cors.Handler(muxRouter)
+
+	muxRouter.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		replacer := strings.NewReplacer("{id}", "1", "{kind}", "like", "{url}", "https://example.com/post")
+		path := replacer.Replace(template)
+		if queries, err := route.GetQueriesTemplates(); err == nil && len(queries) > 0 {
+			path += "?" + replacer.Replace(strings.Join(queries, "&"))
+		}
+
+		for _, method := range methods {
+			t.Run(method+" "+template, func(t *testing.T) {
+				// The cors handler answers preflights without routing them,
+				// so make sure the preflight is for the route under test
+				match := &mux.RouteMatch{}
+				actual, _ := http.NewRequest(method, path, nil)
+				if !muxRouter.Match(actual, match) || match.Route != route {
+					t.Fatalf("Expected %v %v to match route %v", method, path, template)
+				}
+
+				request, _ := http.NewRequest("OPTIONS", path, nil)
+				request.Header.Set("Origin", "https://example.com")
+				request.Header.Set("Access-Control-Request-Method", method)
+				request.Header.Set("Access-Control-Request-Headers", "Content-Type")
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+
+				if recorder.Code != http.StatusNoContent {
+					t.Errorf("Expected preflight to respond with code %v, but got %v", http.StatusNoContent, recorder.Code)
+				}
+				if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "https://example.com" {
+					t.Errorf("Expected allowed origin https://example.com, but got %v", origin)
+				}
+				if !strings.Contains(recorder.Header().Get("Access-Control-Allow-Methods"), method) {
+					t.Errorf("Expected allowed methods to include %v, but got %v", method, recorder.Header().Get("Access-Control-Allow-Methods"))
+				}
+				if maxAge := recorder.Header().Get("Access-Control-Max-Age"); maxAge != "600" {
+					t.Errorf("Expected max age 600, but got %v", maxAge)
+				}
+
+				request.Header.Set("Origin", "https://example.org")
+				recorder = httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+
+				if recorder.Code != http.StatusForbidden {
+					t.Errorf("Expected preflight from unknown origin to respond with code %v, but got %v", http.StatusForbidden, recorder.Code)
+				}
+				if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+					t.Errorf("Expected no allowed origin for unknown origin, but got %v", origin)
+				}
+			})
+		}
+		return nil
+	})
+}
+
+func Test_CORS_Handler(t *testing.T) {
+	sites := mockSiteStore{
+		sites: []*model.Site{
+			{ID: 1, Name: "example", Origins: "https://*.example.net"},
+		},
+	}
+
+	policy := DefaultCORSPolicy()
+	policy.AllowedOrigins = []string{"https://example.com"}
+	cors, err := NewCORS(policy, sites)
+	if err != nil {
+		t.Fatalf("NewCORS() error = %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := cors.Handler(ok)
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		requestMethod string
+		headers       string
+		statusCode    int
+		allowOrigin   string
+	}{
+		{
+			name:        "Request from policy origin",
+			method:      "GET",
+			origin:      "https://example.com",
+			statusCode:  http.StatusOK,
+			allowOrigin: "https://example.com",
+		},
+		{
+			name:        "Request from site origin matching wildcard",
+			method:      "GET",
+			origin:      "https://blog.example.net",
+			statusCode:  http.StatusOK,
+			allowOrigin: "https://blog.example.net",
+		},
+		{
+			name:       "Request from unknown origin gets no cors headers",
+			method:     "GET",
+			origin:     "https://example.org",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:          "Preflight for method not allowed",
+			method:        "OPTIONS",
+			origin:        "https://example.com",
+			requestMethod: "TRACE",
+			statusCode:    http.StatusForbidden,
+		},
+		{
+			name:          "Preflight for header not allowed",
+			method:        "OPTIONS",
+			origin:        "https://example.com",
+			requestMethod: "POST",
+			headers:       "Content-Type, X-Custom",
+			statusCode:    http.StatusForbidden,
+		},
+		{
+			name:          "Preflight with simple headers",
+			method:        "OPTIONS",
+			origin:        "https://blog.example.net",
+			requestMethod: "POST",
+			headers:       "accept, content-type, x-site-key",
+			statusCode:    http.StatusNoContent,
+			allowOrigin:   "https://blog.example.net",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(tt.method, "/", nil)
+			request.Header.Set("Origin", tt.origin)
+			if tt.requestMethod != "" {
+				request.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			if tt.headers != "" {
+				request.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+			if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != tt.allowOrigin {
+				t.Errorf("Expected allowed origin %q, but got %q", tt.allowOrigin, origin)
+			}
+		})
+	}
+}
+
+func Test_CORS_Update(t *testing.T) {
+	cors, err := NewCORS(DefaultCORSPolicy(), nil)
+	if err != nil {
+		t.Fatalf("NewCORS() error = %v", err)
+	}
+
+	invalid := &CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	if err := cors.Update(invalid); err == nil {
+		t.Errorf("Update() expected error for invalid policy")
+	}
+	if cors.Policy() == invalid {
+		t.Errorf("Update() replaced policy with invalid policy")
+	}
+
+	reloaded := &CORSPolicy{AllowedOrigins: []string{"https://example.com"}, AllowedMethods: []string{"GET"}}
+	if err := cors.Update(reloaded); err != nil {
+		t.Errorf("Update() error = %v", err)
+	}
+
+	request, _ := http.NewRequest("OPTIONS", "/", nil)
+	request.Header.Set("Origin", "https://example.org")
+	request.Header.Set("Access-Control-Request-Method", "GET")
+	recorder := httptest.NewRecorder()
+	cors.Handler(http.NotFoundHandler()).ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected reloaded policy to reject origin, but got code %v", recorder.Code)
+	}
+}
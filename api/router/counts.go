@@ -0,0 +1,185 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCountURLs limits how many urls can be counted in a single request
+const maxCountURLs = 100
+
+// defaultCountCacheTTL is used when the router has no count cache ttl set
+const defaultCountCacheTTL = time.Minute
+
+type countEntry struct {
+	count   int
+	expires time.Time
+}
+
+// countCache caches approved comment counts per url for a limited time
+type countCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]countEntry
+}
+
+func newCountCache(ttl time.Duration) *countCache {
+	return &countCache{
+		ttl:     ttl,
+		entries: make(map[string]countEntry),
+	}
+}
+
+// get returns cached counts and the urls that were not found in the cache
+func (c *countCache) get(urls []string) (map[string]int, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	counts := make(map[string]int, len(urls))
+	misses := make([]string, 0)
+
+	for _, url := range urls {
+		entry, ok := c.entries[url]
+		if ok && now.Before(entry.expires) {
+			counts[url] = entry.count
+		} else {
+			misses = append(misses, url)
+		}
+	}
+
+	return counts, misses
+}
+
+func (c *countCache) set(counts map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	for url, count := range counts {
+		c.entries[url] = countEntry{count: count, expires: expires}
+	}
+}
+
+// invalidate removes url from the cache, or every url if none is given
+func (c *countCache) invalidate(urls ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(urls) == 0 {
+		c.entries = make(map[string]countEntry)
+		return
+	}
+
+	for _, url := range urls {
+		delete(c.entries, url)
+	}
+}
+
+// countCacheKey returns the key counts for url on a site are cached under
+func countCacheKey(siteID uint, url string) string {
+	return fmt.Sprintf("%d %s", siteID, url)
+}
+
+// countComments looks up counts in the cache and counts the remaining urls
+func (router *Router) countComments(r *http.Request, urls []string) (map[string]int, error) {
+	site := siteID(r)
+
+	keys := make([]string, 0, len(urls))
+	for _, url := range urls {
+		keys = append(keys, countCacheKey(site, url))
+	}
+
+	cached, _ := router.counts.get(keys)
+
+	counts := make(map[string]int, len(urls))
+	misses := make([]string, 0)
+	for i, url := range urls {
+		if count, ok := cached[keys[i]]; ok {
+			counts[url] = count
+		} else {
+			misses = append(misses, url)
+		}
+	}
+
+	if len(misses) == 0 {
+		return counts, nil
+	}
+
+	fetched, err := router.commenter(r).CountComments(misses)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]int, len(fetched))
+	for url, count := range fetched {
+		counts[url] = count
+		entries[countCacheKey(site, url)] = count
+	}
+	router.counts.set(entries)
+
+	return counts, nil
+}
+
+func validateCountURLs(urls []string) *httpResponse {
+	if len(urls) == 0 {
+		return &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "At least one url is required.",
+		}
+	}
+
+	if len(urls) > maxCountURLs {
+		return &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Too many urls in request.",
+		}
+	}
+
+	return nil
+}
+
+func (router *Router) countHandler(w http.ResponseWriter, r *http.Request, urls []string) {
+	if httpErr := validateCountURLs(urls); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	counts, err := router.countComments(r, urls)
+
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not count comments.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, counts, http.StatusOK)
+}
+
+func (router *Router) countHandlerGet(w http.ResponseWriter, r *http.Request) {
+	router.countHandler(w, r, r.URL.Query()["url"])
+}
+
+func (router *Router) countHandlerPost(w http.ResponseWriter, r *http.Request) {
+	urls := []string{}
+	if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode urls in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.countHandler(w, r, urls)
+}
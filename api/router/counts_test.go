@@ -0,0 +1,146 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_countCache(t *testing.T) {
+	cache := newCountCache(time.Minute)
+	cache.set(map[string]int{"a": 1, "b": 2})
+
+	counts, misses := cache.get([]string{"a", "b", "c"})
+	if !reflect.DeepEqual(counts, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("Expected cached counts for a and b, but got %v", counts)
+	}
+	if !reflect.DeepEqual(misses, []string{"c"}) {
+		t.Errorf("Expected cache miss for c, but got %v", misses)
+	}
+
+	cache.invalidate("a")
+	if _, misses := cache.get([]string{"a", "b"}); !reflect.DeepEqual(misses, []string{"a"}) {
+		t.Errorf("Expected cache miss for invalidated url a, but got %v", misses)
+	}
+
+	cache.invalidate()
+	if _, misses := cache.get([]string{"b"}); !reflect.DeepEqual(misses, []string{"b"}) {
+		t.Errorf("Expected cache miss after clearing cache, but got %v", misses)
+	}
+
+	expired := newCountCache(0)
+	expired.set(map[string]int{"a": 1})
+	if _, misses := expired.get([]string{"a"}); !reflect.DeepEqual(misses, []string{"a"}) {
+		t.Errorf("Expected cache miss for expired url a, but got %v", misses)
+	}
+}
+
+func Test_server_countHandler(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		statusCode int
+		counts     map[string]int
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Get counts for several urls",
+			method:     "GET",
+			target:     "/counts?url=http://example.com/posts/1&url=http://example.com/posts/2",
+			statusCode: http.StatusOK,
+			counts: map[string]int{
+				"http://example.com/posts/1": 3,
+				"http://example.com/posts/2": 0,
+			},
+		},
+		{
+			name:       "Post counts for several urls",
+			method:     "POST",
+			target:     "/counts",
+			body:       `["http://example.com/posts/1", "http://example.com/posts/2"]`,
+			statusCode: http.StatusOK,
+			counts: map[string]int{
+				"http://example.com/posts/1": 3,
+				"http://example.com/posts/2": 0,
+			},
+		},
+		{
+			name:       "Get counts without urls",
+			method:     "GET",
+			target:     "/counts",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "At least one url is required.",
+			},
+		},
+		{
+			name:       "Post badly formatted urls",
+			method:     "POST",
+			target:     "/counts",
+			body:       "Not json",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Could not decode urls in payload.",
+			},
+		},
+		{
+			name:       "Get counts when commenter returns error",
+			method:     "GET",
+			target:     "/counts?url=not-in-database",
+			statusCode: http.StatusInternalServerError,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Could not count comments.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody == nil { // Expect regular body
+				counts := map[string]int{}
+				if err := json.NewDecoder(recorder.Body).Decode(&counts); err != nil {
+					t.Errorf("Could not decode counts %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(counts, tt.counts) {
+					t.Errorf("Expected counts %v, but was %v", tt.counts, counts)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,753 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// DashboardPath is the path the moderation dashboard is served under
+const DashboardPath = "/admin/ui"
+
+// DashboardCookie is the name of the cookie holding dashboard sessions
+const DashboardCookie = "gocomment_dashboard"
+
+// dashboardTTL controls how long moderators stay logged in to the dashboard
+const dashboardTTL = 12 * time.Hour
+
+// Bulk actions moderators can take on comments in the dashboard
+const (
+	dashboardApprove    = "approve"
+	dashboardReject     = "reject"
+	dashboardSpam       = "spam"
+	dashboardClearFlags = "clear-flags"
+)
+
+// dashboardNotices are shown after redirecting from a dashboard form, by
+// the action the form carried out
+var dashboardNotices = map[string]string{
+	dashboardApprove:    "Approved %d comments.",
+	dashboardReject:     "Rejected %d comments.",
+	dashboardSpam:       "Removed %d comments as spam.",
+	dashboardClearFlags: "Cleared the flags of %d comments.",
+	model.AuditBan:      "Ban added.",
+	model.AuditUnban:    "Ban removed.",
+	model.AuditThread:   "Thread settings saved.",
+}
+
+// dashboardLabels are the button labels of bulk actions
+var dashboardLabels = map[string]string{
+	dashboardApprove:    "Approve",
+	dashboardReject:     "Reject",
+	dashboardSpam:       "Spam",
+	dashboardClearFlags: "Clear flags",
+}
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+//go:embed dashboard/style.css
+var dashboardStyle []byte
+
+var dashboardFuncs = template.FuncMap{
+	"path": func(path string) string {
+		return DashboardPath + path
+	},
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04")
+	},
+	"label": func(action string) string {
+		return dashboardLabels[action]
+	},
+	"banKinds": func() []string {
+		return []string{model.BanEmail, model.BanDomain, model.BanIP, model.BanUsername}
+	},
+	"threadStates": func() []string {
+		return []string{model.ThreadOpen, model.ThreadClosed, model.ThreadReadOnly}
+	},
+}
+
+// dashboardPages are the templates of the dashboard by page, each page is
+// rendered inside the shared layout
+var dashboardPages = parseDashboard("login", "error", "comments", "bans", "thread")
+
+func parseDashboard(pages ...string) map[string]*template.Template {
+	templates := map[string]*template.Template{}
+	for _, page := range pages {
+		templates[page] = template.Must(template.New("layout.html").Funcs(dashboardFuncs).
+			ParseFS(dashboardFiles, "dashboard/layout.html", "dashboard/partials.html", "dashboard/"+page+".html"))
+	}
+	return templates
+}
+
+// dashboardSession is the signed content of the dashboard cookie
+type dashboardSession struct {
+	Moderator string `json:"moderator"`
+	// Site names the site of moderators logged in with a site api key
+	Site   string `json:"site,omitempty"`
+	Expiry int64  `json:"exp"`
+}
+
+// dashboardPage holds what dashboard templates render
+type dashboardPage struct {
+	Title string
+	// Nav names the active navigation entry
+	Nav       string
+	Moderator string
+	// Site names the site being moderated, empty for the default site
+	Site string
+	// Sites lists the sites admins can switch between
+	Sites   []*model.Site
+	CSRF    string
+	HasBans bool
+	Notice  string
+	Error   string
+	Errors  []model.FieldError
+
+	// Return is the page forms redirect back to
+	Return   string
+	Query    model.CommentQuery
+	Comments []*model.Comment
+	// Actions lists the bulk actions offered for Comments
+	Actions []string
+	// More links to the next page of Comments
+	More   string
+	Bans   []*model.Ban
+	Ban    *model.Ban
+	URL    string
+	Thread *model.Thread
+}
+
+// dashboardLink returns the dashboard url of path with query parameters
+// given as name and value pairs, keeping the site an admin is moderating
+func dashboardLink(moderator string, site string, path string, pairs ...string) string {
+	values := url.Values{}
+	if moderator == "admin" && site != "" {
+		values.Set("site", site)
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			values.Set(pairs[i], pairs[i+1])
+		}
+	}
+
+	if len(values) == 0 {
+		return DashboardPath + path
+	}
+	return DashboardPath + path + "?" + values.Encode()
+}
+
+// Link returns the dashboard url of path for the moderator of the page
+func (page *dashboardPage) Link(path string, pairs ...string) string {
+	return dashboardLink(page.Moderator, page.Site, path, pairs...)
+}
+
+// dashboardLink returns the dashboard url of path for the moderator making
+// the request
+func (router *Router) dashboardLink(r *http.Request, path string, pairs ...string) string {
+	site := ""
+	if s := siteFromRequest(r); s != nil {
+		site = s.Name
+	}
+	return dashboardLink(router.moderator(r), site, path, pairs...)
+}
+
+// sign returns the signature of value for the dashboard secret
+func (router *Router) sign(value string) string {
+	mac := hmac.New(sha256.New, router.DashboardSecret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// dashboardToken encodes and signs session for the dashboard cookie
+func (router *Router) dashboardToken(session *dashboardSession) (string, error) {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + router.sign(encoded), nil
+}
+
+// dashboardSession returns the session of the dashboard cookie of the
+// request and its token, or nil if there is no valid session
+func (router *Router) dashboardSession(r *http.Request) (*dashboardSession, string) {
+	cookie, err := r.Cookie(DashboardCookie)
+	if err != nil {
+		return nil, ""
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(router.sign(parts[0]))) {
+		return nil, ""
+	}
+
+	session := &dashboardSession{}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(payload, session) != nil {
+		return nil, ""
+	} else if session.Moderator == "" || time.Now().Unix() >= session.Expiry {
+		return nil, ""
+	}
+
+	return session, cookie.Value
+}
+
+// csrfToken returns the token dashboard forms of the session with token
+// must carry
+func (router *Router) csrfToken(token string) string {
+	return router.sign("csrf:" + token)
+}
+
+// setDashboardCookie sets the dashboard cookie, removing it if maxAge is
+// negative. The cookie is only sent to the dashboard and never along with
+// requests from other sites.
+func (router *Router) setDashboardCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     DashboardCookie,
+		Value:    value,
+		Path:     DashboardPath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !router.InsecureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// requireDashboard only passes requests of moderators logged in to the
+// dashboard on to next, redirecting anyone else to the login page. Admins
+// pick the site to moderate with the site parameter, while moderators
+// logged in with a site api key only moderate their site.
+func (router *Router) requireDashboard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, token := router.dashboardSession(r)
+		if session == nil {
+			http.Redirect(w, r, DashboardPath+"/login", http.StatusSeeOther)
+			return
+		}
+
+		if r.Method == http.MethodPost &&
+			!hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(router.csrfToken(token))) {
+			router.dashboardError(w, r, http.StatusForbidden, "The form has expired, reload the page and try again.")
+			return
+		}
+
+		name := session.Site
+		if name == "" {
+			name = r.FormValue("site")
+		}
+
+		var site *model.Site
+		if name != "" && router.Sites != nil {
+			var err error
+			site, err = router.Sites.GetSite(name)
+
+			if err == gorm.ErrRecordNotFound {
+				router.dashboardError(w, r, http.StatusNotFound, fmt.Sprintf("Could not find site %v.", name))
+				return
+			} else if err != nil {
+				router.dashboardError(w, r, http.StatusInternalServerError, "Could not resolve site.")
+				return
+			}
+		}
+
+		// Reasons given in dashboard forms are recorded like the reason
+		// header of the api
+		if reason := r.PostFormValue("reason"); reason != "" {
+			r.Header.Set(ReasonHeader, reason)
+		}
+
+		ctx := context.WithValue(r.Context(), siteContextKey, site)
+		ctx = context.WithValue(ctx, moderatorContextKey, session.Moderator)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// renderDashboard renders the dashboard page name with status
+func (router *Router) renderDashboard(w http.ResponseWriter, r *http.Request, name string, page *dashboardPage, status int) {
+	page.Moderator, _ = r.Context().Value(moderatorContextKey).(string)
+	if site := siteFromRequest(r); site != nil {
+		page.Site = site.Name
+	}
+	if _, token := router.dashboardSession(r); token != "" {
+		page.CSRF = router.csrfToken(token)
+	}
+	if page.Moderator == "admin" && router.Sites != nil {
+		page.Sites, _ = router.Sites.GetSites()
+	}
+	page.HasBans = router.Bans != nil
+
+	query := r.URL.Query()
+	if format, ok := dashboardNotices[query.Get("done")]; ok && page.Notice == "" {
+		page.Notice = format
+		if strings.Contains(format, "%d") {
+			n, _ := strconv.Atoi(query.Get("n"))
+			page.Notice = fmt.Sprintf(format, n)
+		}
+	}
+	if failed, _ := strconv.Atoi(query.Get("failed")); failed > 0 && page.Error == "" {
+		page.Error = fmt.Sprintf("%d comments could not be changed.", failed)
+	}
+	if page.Return == "" {
+		page.Return = r.URL.RequestURI()
+	}
+
+	body := &bytes.Buffer{}
+	if err := dashboardPages[name].Execute(body, page); err != nil {
+		log.Printf("Could not render dashboard page %v: %v", name, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	body.WriteTo(w)
+}
+
+// dashboardError renders an error page with status and message
+func (router *Router) dashboardError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	page := &dashboardPage{Title: http.StatusText(status), Error: message}
+	router.renderDashboard(w, r, "error", page, status)
+}
+
+// redirectDashboard redirects to the dashboard page target after a form
+// carried out action done times and failed to carry it out failed times.
+// Targets outside the dashboard are replaced by the pending queue.
+func (router *Router) redirectDashboard(w http.ResponseWriter, r *http.Request, target string, done string, n int, failed int) {
+	link, err := url.Parse(target)
+	if err != nil || link.Scheme != "" || link.Host != "" || !strings.HasPrefix(link.Path, DashboardPath+"/") {
+		link, _ = url.Parse(router.dashboardLink(r, "/pending"))
+	}
+
+	values := link.Query()
+	values.Set("done", done)
+	values.Del("n")
+	values.Del("failed")
+	if n > 0 {
+		values.Set("n", strconv.Itoa(n))
+	}
+	if failed > 0 {
+		values.Set("failed", strconv.Itoa(failed))
+	}
+	link.RawQuery = values.Encode()
+
+	http.Redirect(w, r, link.String(), http.StatusSeeOther)
+}
+
+func dashboardStyleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write(dashboardStyle)
+}
+
+func (router *Router) dashboardLoginGet(w http.ResponseWriter, r *http.Request) {
+	if session, _ := router.dashboardSession(r); session != nil {
+		http.Redirect(w, r, DashboardPath+"/pending", http.StatusSeeOther)
+		return
+	}
+
+	router.renderDashboard(w, r, "login", &dashboardPage{Title: "Log in"}, http.StatusOK)
+}
+
+// dashboardLoginPost logs moderators in with the admin key or the api key of
+// a site
+func (router *Router) dashboardLoginPost(w http.ResponseWriter, r *http.Request) {
+	key := r.PostFormValue("key")
+	session := &dashboardSession{Expiry: time.Now().Add(dashboardTTL).Unix()}
+
+	if router.AdminKey != "" && key != "" &&
+		subtle.ConstantTimeCompare([]byte(key), []byte(router.AdminKey)) == 1 {
+		session.Moderator = "admin"
+	} else if router.Sites != nil && key != "" {
+		if site, err := router.Sites.GetSiteByAPIKey(key); err == nil {
+			session.Moderator, session.Site = "site:"+site.Name, site.Name
+		}
+	}
+
+	if session.Moderator == "" {
+		page := &dashboardPage{Title: "Log in", Error: "Invalid key."}
+		router.renderDashboard(w, r, "login", page, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := router.dashboardToken(session)
+	if err != nil {
+		router.dashboardError(w, r, http.StatusInternalServerError, "Failed to log in.")
+		return
+	}
+
+	router.setDashboardCookie(w, token, int(dashboardTTL.Seconds()))
+	http.Redirect(w, r, DashboardPath+"/pending", http.StatusSeeOther)
+}
+
+func (router *Router) dashboardLogout(w http.ResponseWriter, r *http.Request) {
+	router.setDashboardCookie(w, "", -1)
+	http.Redirect(w, r, DashboardPath+"/login", http.StatusSeeOther)
+}
+
+func (router *Router) dashboardIndex(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, router.dashboardLink(r, "/pending"), http.StatusSeeOther)
+}
+
+// dashboardComments renders comments in page, or an error page if they
+// could not be fetched
+func (router *Router) dashboardComments(w http.ResponseWriter, r *http.Request, page *dashboardPage, comments []*model.Comment, err error) {
+	if err != nil {
+		router.dashboardError(w, r, http.StatusInternalServerError, "Could not get comments.")
+		return
+	}
+
+	page.Comments = comments
+	router.renderDashboard(w, r, "comments", page, http.StatusOK)
+}
+
+// dashboardPending lists the comments held for moderation
+func (router *Router) dashboardPending(w http.ResponseWriter, r *http.Request) {
+	comments, err := router.commenter(r).SearchComments(model.CommentQuery{Status: model.StatusPending})
+	page := &dashboardPage{
+		Title:   "Pending comments",
+		Nav:     "pending",
+		Actions: []string{dashboardApprove, dashboardReject, dashboardSpam},
+	}
+	router.dashboardComments(w, r, page, comments, err)
+}
+
+// dashboardFlagged lists the comments flagged by readers, the most flagged
+// first
+func (router *Router) dashboardFlagged(w http.ResponseWriter, r *http.Request) {
+	comments, err := router.commenter(r).GetFlaggedComments()
+	page := &dashboardPage{
+		Title:   "Flagged comments",
+		Nav:     "flagged",
+		Actions: []string{dashboardApprove, dashboardClearFlags, dashboardReject, dashboardSpam},
+	}
+	router.dashboardComments(w, r, page, comments, err)
+}
+
+// dashboardSearch finds comments by text, status, and thread
+func (router *Router) dashboardSearch(w http.ResponseWriter, r *http.Request) {
+	query := model.CommentQuery{
+		Text:   r.FormValue("q"),
+		Status: r.FormValue("status"),
+		URL:    r.FormValue("url"),
+		Limit:  model.DefaultSearchLimit,
+	}
+	query.Offset, _ = strconv.Atoi(r.FormValue("offset"))
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	comments, err := router.commenter(r).SearchComments(query)
+	page := &dashboardPage{
+		Title:   "Search comments",
+		Nav:     "search",
+		Query:   query,
+		Actions: []string{dashboardApprove, dashboardReject, dashboardSpam},
+	}
+	if len(comments) == query.Limit {
+		page.More = router.dashboardLink(r, "/search", "q", query.Text, "status", query.Status,
+			"url", query.URL, "offset", strconv.Itoa(query.Offset+query.Limit))
+	}
+	router.dashboardComments(w, r, page, comments, err)
+}
+
+// dashboardBulk carries out a bulk action on the selected comments
+func (router *Router) dashboardBulk(w http.ResponseWriter, r *http.Request) {
+	action := r.PostFormValue("action")
+	if action != dashboardApprove && action != dashboardReject && action != dashboardSpam && action != dashboardClearFlags {
+		router.dashboardError(w, r, http.StatusBadRequest, "Unknown action.")
+		return
+	}
+
+	done, failed := 0, 0
+	for _, value := range r.PostForm["id"] {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err == nil {
+			err = router.moderateComment(r, uint(id), action)
+		}
+
+		if err != nil {
+			log.Printf("Could not %v comment %v: %v", action, value, err)
+			failed++
+			continue
+		}
+		done++
+	}
+
+	router.counts.invalidate()
+	router.redirectDashboard(w, r, r.PostFormValue("return"), action, done, failed)
+}
+
+// moderateComment carries out a bulk action on the comment with id. Comments
+// removed as spam are deleted and their poster's email address is banned.
+func (router *Router) moderateComment(r *http.Request, id uint, action string) error {
+	commenter := router.commenter(r)
+
+	existing, err := commenter.GetComment(id)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case dashboardApprove:
+		approved := *existing
+		approved.Status = model.StatusApproved
+
+		comment, err := commenter.UpdateComment(&approved)
+		if err != nil {
+			return err
+		}
+		router.audit(r, model.AuditStatus, model.AuditTargetComment, id, existing, comment)
+
+	case dashboardClearFlags:
+		comment, err := commenter.ClearFlags(id)
+		if err != nil {
+			return err
+		}
+		router.audit(r, model.AuditClearFlags, model.AuditTargetComment, id, nil, comment)
+
+	case dashboardReject, dashboardSpam:
+		comment, err := commenter.DeleteComment(&model.Comment{ID: &id, Version: existing.Version})
+		if err != nil {
+			return err
+		}
+		router.audit(r, model.AuditDelete, model.AuditTargetComment, id, existing, comment)
+
+		if action == dashboardSpam && router.Bans != nil && existing.Email != "" {
+			return router.banSpammer(r, existing.Email)
+		}
+	}
+
+	return nil
+}
+
+// banSpammer bans email unless it is banned already
+func (router *Router) banSpammer(r *http.Request, email string) error {
+	bans := router.bans(r)
+
+	if _, err := bans.FindBan(email, "", nil); err == nil {
+		return nil
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	ban, err := bans.CreateBan(&model.Ban{
+		Kind:      model.BanEmail,
+		Value:     email,
+		Reason:    "Spam",
+		CreatedBy: router.moderator(r),
+	})
+	if err != nil {
+		return err
+	}
+
+	router.audit(r, model.AuditBan, model.AuditTargetBan, ban.ID, nil, ban)
+	return nil
+}
+
+// dashboardBans lists the bans of the site with a form to add bans
+func (router *Router) dashboardBans(w http.ResponseWriter, r *http.Request) {
+	router.renderBans(w, r, &dashboardPage{Ban: &model.Ban{Kind: model.BanEmail}}, http.StatusOK)
+}
+
+func (router *Router) renderBans(w http.ResponseWriter, r *http.Request, page *dashboardPage, status int) {
+	bans, err := router.bans(r).GetBans()
+	if err != nil {
+		router.dashboardError(w, r, http.StatusInternalServerError, "Could not get bans.")
+		return
+	}
+
+	page.Title, page.Nav, page.Bans = "Bans", "bans", bans
+	router.renderDashboard(w, r, "bans", page, status)
+}
+
+func (router *Router) dashboardBanPost(w http.ResponseWriter, r *http.Request) {
+	form := model.Ban{
+		Kind:      r.PostFormValue("kind"),
+		Value:     r.PostFormValue("value"),
+		Reason:    r.PostFormValue("reason"),
+		Shadow:    r.PostFormValue("shadow") != "",
+		CreatedBy: router.moderator(r),
+	}
+
+	if expires := r.PostFormValue("expires"); expires != "" {
+		expiresAt, err := time.Parse("2006-01-02", expires)
+		if err != nil {
+			page := &dashboardPage{
+				Ban:    &form,
+				Errors: []model.FieldError{{Field: "expiresAt", Code: model.CodeInvalid, Message: "Expiry must be a date"}},
+			}
+			router.renderBans(w, r, page, http.StatusBadRequest)
+			return
+		}
+		form.ExpiresAt = &expiresAt
+	}
+
+	ban := form
+	created, err := router.bans(r).CreateBan(&ban)
+
+	if fieldErrs, ok := err.(model.ValidationError); ok {
+		router.renderBans(w, r, &dashboardPage{Ban: &form, Errors: fieldErrs}, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		router.dashboardError(w, r, http.StatusInternalServerError, "Failed to create ban.")
+		return
+	}
+
+	router.audit(r, model.AuditBan, model.AuditTargetBan, created.ID, nil, created)
+	router.redirectDashboard(w, r, router.dashboardLink(r, "/bans"), model.AuditBan, 0, 0)
+}
+
+func (router *Router) dashboardBanDelete(w http.ResponseWriter, r *http.Request) {
+	var ban *model.Ban
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		err = gorm.ErrRecordNotFound
+	} else {
+		ban, err = router.bans(r).DeleteBan(uint(id))
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		router.dashboardError(w, r, http.StatusNotFound, fmt.Sprintf("Could not find ban with id %v.", mux.Vars(r)["id"]))
+		return
+	} else if err != nil {
+		router.dashboardError(w, r, http.StatusInternalServerError, "Failed to remove ban.")
+		return
+	}
+
+	router.audit(r, model.AuditUnban, model.AuditTargetBan, uint(id), ban, nil)
+	router.redirectDashboard(w, r, router.dashboardLink(r, "/bans"), model.AuditUnban, 0, 0)
+}
+
+// dashboardThread shows the settings and comments of the thread of the url
+// parameter
+func (router *Router) dashboardThread(w http.ResponseWriter, r *http.Request) {
+	url := r.FormValue("url")
+	page := &dashboardPage{
+		Title:   "Threads",
+		Nav:     "threads",
+		URL:     url,
+		Actions: []string{dashboardApprove, dashboardReject, dashboardSpam},
+	}
+
+	if url == "" {
+		router.renderDashboard(w, r, "thread", page, http.StatusOK)
+		return
+	}
+
+	commenter := router.commenter(r)
+	thread, err := commenter.GetThread(url)
+
+	if err == gorm.ErrRecordNotFound {
+		thread = &model.Thread{URL: url}
+	} else if err != nil {
+		router.dashboardError(w, r, http.StatusInternalServerError, "Could not get thread.")
+		return
+	}
+
+	if thread.State == "" {
+		thread.State = model.ThreadOpen
+	}
+	page.Thread = thread
+
+	comments, err := commenter.SearchComments(model.CommentQuery{URL: url})
+	router.renderThread(w, r, page, comments, err)
+}
+
+func (router *Router) renderThread(w http.ResponseWriter, r *http.Request, page *dashboardPage, comments []*model.Comment, err error) {
+	if err != nil {
+		router.dashboardError(w, r, http.StatusInternalServerError, "Could not get comments.")
+		return
+	}
+
+	page.Comments = comments
+	status := http.StatusOK
+	if page.Error != "" {
+		status = http.StatusBadRequest
+	}
+	router.renderDashboard(w, r, "thread", page, status)
+}
+
+func (router *Router) dashboardThreadPost(w http.ResponseWriter, r *http.Request) {
+	url := r.PostFormValue("url")
+	settings := &model.Thread{
+		URL:               url,
+		State:             r.PostFormValue("state"),
+		RequireModeration: r.PostFormValue("requireModeration") != "",
+	}
+
+	page := &dashboardPage{
+		Title:   "Threads",
+		Nav:     "threads",
+		URL:     url,
+		Thread:  settings,
+		Actions: []string{dashboardApprove, dashboardReject, dashboardSpam},
+	}
+
+	days, err := strconv.Atoi(r.PostFormValue("autoCloseDays"))
+	if url == "" {
+		page.Error = "Thread url is required."
+	} else if err != nil || days < 0 {
+		page.Error = "Days to close the thread after must be a number of at least 0."
+	} else {
+		settings.AutoCloseDays = days
+		_, err = router.saveThreadSettings(r, url, settings)
+
+		if err == model.ErrInvalidThreadState {
+			page.Error = err.Error() + "."
+		} else if err != nil {
+			router.dashboardError(w, r, http.StatusInternalServerError, "Failed to update thread settings.")
+			return
+		} else {
+			router.redirectDashboard(w, r, router.dashboardLink(r, "/threads", "url", url), model.AuditThread, 0, 0)
+			return
+		}
+	}
+
+	comments, err := router.commenter(r).SearchComments(model.CommentQuery{URL: url})
+	router.renderThread(w, r, page, comments, err)
+}
+
+// dashboardRoutes adds the routes of the moderation dashboard to muxRouter
+func (router *Router) dashboardRoutes(muxRouter *mux.Router) {
+	muxRouter.HandleFunc(DashboardPath+"/style.css", dashboardStyleHandler).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/login", router.dashboardLoginGet).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/login", router.dashboardLoginPost).Methods("POST")
+	muxRouter.HandleFunc(DashboardPath+"/logout", router.requireDashboard(router.dashboardLogout)).Methods("POST")
+	muxRouter.HandleFunc(DashboardPath, router.requireDashboard(router.dashboardIndex)).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/", router.requireDashboard(router.dashboardIndex)).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/pending", router.requireDashboard(router.dashboardPending)).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/flagged", router.requireDashboard(router.dashboardFlagged)).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/search", router.requireDashboard(router.dashboardSearch)).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/comments", router.requireDashboard(router.dashboardBulk)).Methods("POST")
+	muxRouter.HandleFunc(DashboardPath+"/threads", router.requireDashboard(router.dashboardThread)).Methods("GET")
+	muxRouter.HandleFunc(DashboardPath+"/threads", router.requireDashboard(router.dashboardThreadPost)).Methods("POST")
+	if router.Bans != nil {
+		muxRouter.HandleFunc(DashboardPath+"/bans", router.requireDashboard(router.dashboardBans)).Methods("GET")
+		muxRouter.HandleFunc(DashboardPath+"/bans", router.requireDashboard(router.dashboardBanPost)).Methods("POST")
+		muxRouter.HandleFunc(DashboardPath+"/bans/{id}/delete", router.requireDashboard(router.dashboardBanDelete)).Methods("POST")
+	}
+}
@@ -0,0 +1,319 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+func dashboardCookie(t *testing.T, router *Router, session *dashboardSession) *http.Cookie {
+	if session.Expiry == 0 {
+		session.Expiry = time.Now().Add(time.Hour).Unix()
+	}
+	token, err := router.dashboardToken(session)
+	if err != nil {
+		t.Fatalf("Could not create dashboard token: %v", err)
+	}
+	return &http.Cookie{Name: DashboardCookie, Value: token}
+}
+
+func Test_server_dashboardLogin(t *testing.T) {
+	router := &Router{
+		Commenter:       &mockCommentStore{},
+		Sites:           mockSiteStore{sites: []*model.Site{{ID: 1, Name: "example", APIKey: "site-secret"}}},
+		AdminKey:        "admin-secret",
+		DashboardSecret: []byte("dashboard-secret"),
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		statusCode int
+		session    *dashboardSession
+	}{
+		{name: "Admin key", key: "admin-secret", statusCode: http.StatusSeeOther, session: &dashboardSession{Moderator: "admin"}},
+		{name: "Site key", key: "site-secret", statusCode: http.StatusSeeOther, session: &dashboardSession{Moderator: "site:example", Site: "example"}},
+		{name: "Invalid key", key: "guess", statusCode: http.StatusUnauthorized},
+		{name: "No key", statusCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"key": {tt.key}}
+			request, _ := http.NewRequest("POST", DashboardPath+"/login", strings.NewReader(form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.session == nil {
+				if !strings.Contains(recorder.Body.String(), "Invalid key.") {
+					t.Errorf("Expected login page to show error, but got %v", recorder.Body)
+				}
+				return
+			}
+
+			cookies := recorder.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].SameSite != http.SameSiteStrictMode || !cookies[0].HttpOnly {
+				t.Fatalf("Expected strict http only dashboard cookie, but got %v", cookies)
+			}
+
+			request, _ = http.NewRequest("GET", DashboardPath+"/pending", nil)
+			request.AddCookie(cookies[0])
+			session, _ := router.dashboardSession(request)
+			if session == nil || session.Moderator != tt.session.Moderator || session.Site != tt.session.Site {
+				t.Errorf("Expected session %+v, but got %+v", tt.session, session)
+			}
+		})
+	}
+}
+
+func Test_server_dashboardPages(t *testing.T) {
+	router := &Router{
+		Commenter:       &mockCommentStore{},
+		Bans:            mockBanStore{},
+		Sites:           mockSiteStore{sites: []*model.Site{{ID: 1, Name: "example", Origins: "https://example.com"}}},
+		AdminKey:        "admin-secret",
+		DashboardSecret: []byte("dashboard-secret"),
+	}
+
+	admin := dashboardCookie(t, router, &dashboardSession{Moderator: "admin"})
+	expired := dashboardCookie(t, router, &dashboardSession{Moderator: "admin", Expiry: time.Now().Add(-time.Hour).Unix()})
+	forged := &http.Cookie{Name: DashboardCookie, Value: admin.Value + "x"}
+
+	tests := []struct {
+		name       string
+		path       string
+		cookie     *http.Cookie
+		statusCode int
+		contains   []string
+	}{
+		{name: "Pending without session", path: "/pending", statusCode: http.StatusSeeOther},
+		{name: "Pending with expired session", path: "/pending", cookie: expired, statusCode: http.StatusSeeOther},
+		{name: "Pending with forged session", path: "/pending", cookie: forged, statusCode: http.StatusSeeOther},
+		{name: "Pending", path: "/pending", cookie: admin, statusCode: http.StatusOK, contains: []string{"No comments."}},
+		{name: "Flagged", path: "/flagged", cookie: admin, statusCode: http.StatusOK, contains: []string{"Some content", "spam: 2", "Clear flags", `name="id" value="1"`}},
+		{name: "Search", path: "/search?q=some", cookie: admin, statusCode: http.StatusOK, contains: []string{"Some content", `value="some"`}},
+		{name: "Search without match", path: "/search?q=other", cookie: admin, statusCode: http.StatusOK, contains: []string{"No comments."}},
+		{name: "Bans", path: "/bans", cookie: admin, statusCode: http.StatusOK, contains: []string{"troll@example.com"}},
+		{name: "Thread", path: "/threads?url=http://example.com/posts/moderated", cookie: admin, statusCode: http.StatusOK, contains: []string{"requireModeration\" checked"}},
+		{name: "Site", path: "/pending?site=example", cookie: admin, statusCode: http.StatusOK, contains: []string{`<option value="example" selected>`}},
+		{name: "Unknown site", path: "/pending?site=other", cookie: admin, statusCode: http.StatusNotFound, contains: []string{"Could not find site other."}},
+		{name: "Notice", path: "/pending?done=approve&n=2", cookie: admin, statusCode: http.StatusOK, contains: []string{"Approved 2 comments."}},
+		{name: "Style", path: "/style.css", statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", DashboardPath+tt.path, nil)
+			request.Header.Set("Origin", "https://dashboard.example.com")
+			if tt.cookie != nil {
+				request.AddCookie(tt.cookie)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.statusCode == http.StatusSeeOther && recorder.Header().Get("Location") != DashboardPath+"/login" {
+				t.Errorf("Expected redirect to login page, but got %v", recorder.Header().Get("Location"))
+			}
+
+			for _, s := range tt.contains {
+				if !strings.Contains(recorder.Body.String(), s) {
+					t.Errorf("Expected page to contain %q, but got %v", s, recorder.Body)
+				}
+			}
+		})
+	}
+}
+
+func Test_server_dashboardForms(t *testing.T) {
+	router := &Router{
+		Commenter:       &mockCommentStore{},
+		Bans:            mockBanStore{},
+		AdminKey:        "admin-secret",
+		DashboardSecret: []byte("dashboard-secret"),
+	}
+
+	admin := dashboardCookie(t, router, &dashboardSession{Moderator: "admin"})
+	csrf := router.csrfToken(admin.Value)
+
+	tests := []struct {
+		name       string
+		path       string
+		form       url.Values
+		statusCode int
+		location   string
+		entries    []string
+	}{
+		{
+			name:       "Approve without csrf token",
+			path:       "/comments",
+			form:       url.Values{"action": {"approve"}, "id": {"1"}},
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Approve",
+			path:       "/comments",
+			form:       url.Values{"csrf": {csrf}, "action": {"approve"}, "id": {"1"}, "return": {DashboardPath + "/flagged"}},
+			statusCode: http.StatusSeeOther,
+			location:   DashboardPath + "/flagged?done=approve&n=1",
+			entries:    []string{model.AuditStatus},
+		},
+		{
+			name:       "Reject with missing comment",
+			path:       "/comments",
+			form:       url.Values{"csrf": {csrf}, "action": {"reject"}, "id": {"1", "9"}, "reason": {"Off topic"}},
+			statusCode: http.StatusSeeOther,
+			location:   DashboardPath + "/pending?done=reject&failed=1&n=1",
+			entries:    []string{model.AuditDelete},
+		},
+		{
+			name:       "Clear flags returning elsewhere",
+			path:       "/comments",
+			form:       url.Values{"csrf": {csrf}, "action": {"clear-flags"}, "id": {"1"}, "return": {"https://example.com/admin/ui/pending"}},
+			statusCode: http.StatusSeeOther,
+			location:   DashboardPath + "/pending?done=clear-flags&n=1",
+			entries:    []string{model.AuditClearFlags},
+		},
+		{
+			name:       "Unknown action",
+			path:       "/comments",
+			form:       url.Values{"csrf": {csrf}, "action": {"publish"}, "id": {"1"}},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Add ban",
+			path:       "/bans",
+			form:       url.Values{"csrf": {csrf}, "kind": {"email"}, "value": {"troll@example.com"}},
+			statusCode: http.StatusSeeOther,
+			location:   DashboardPath + "/bans?done=ban",
+			entries:    []string{model.AuditBan},
+		},
+		{
+			name:       "Add invalid ban",
+			path:       "/bans",
+			form:       url.Values{"csrf": {csrf}, "kind": {"country"}, "value": {"xx"}},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Remove ban",
+			path:       "/bans/1/delete",
+			form:       url.Values{"csrf": {csrf}},
+			statusCode: http.StatusSeeOther,
+			location:   DashboardPath + "/bans?done=unban",
+			entries:    []string{model.AuditUnban},
+		},
+		{
+			name:       "Remove missing ban",
+			path:       "/bans/9/delete",
+			form:       url.Values{"csrf": {csrf}},
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "Save thread settings",
+			path:       "/threads",
+			form:       url.Values{"csrf": {csrf}, "url": {"http://example.com/posts/1"}, "state": {"closed"}, "autoCloseDays": {"7"}},
+			statusCode: http.StatusSeeOther,
+			location:   DashboardPath + "/threads?done=thread&url=http%3A%2F%2Fexample.com%2Fposts%2F1",
+			entries:    []string{model.AuditThread},
+		},
+		{
+			name:       "Save invalid thread settings",
+			path:       "/threads",
+			form:       url.Values{"csrf": {csrf}, "url": {"http://example.com/posts/1"}, "state": {"archived"}, "autoCloseDays": {"0"}},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Log out",
+			path:       "/logout",
+			form:       url.Values{"csrf": {csrf}},
+			statusCode: http.StatusSeeOther,
+			location:   DashboardPath + "/login",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := &[]*model.AuditEntry{}
+			router.Audit = mockAuditStore{entries: entries, query: &model.AuditQuery{}}
+
+			request, _ := http.NewRequest("POST", DashboardPath+tt.path, strings.NewReader(tt.form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			request.AddCookie(admin)
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if location := recorder.Header().Get("Location"); location != tt.location {
+				t.Errorf("Expected redirect to %v, but got %v", tt.location, location)
+			}
+
+			if len(*entries) != len(tt.entries) {
+				t.Fatalf("Expected audit entries %v, but got %v", tt.entries, len(*entries))
+			}
+			for i, entry := range *entries {
+				if entry.Action != tt.entries[i] || entry.Actor != "admin" {
+					t.Errorf("Expected %v by admin to be audited, but got %v by %v", tt.entries[i], entry.Action, entry.Actor)
+				}
+				if reason := tt.form.Get("reason"); entry.Reason != reason {
+					t.Errorf("Expected audit reason %q, but got %q", reason, entry.Reason)
+				}
+				if entry.Action == model.AuditUnban && entry.Before == nil {
+					t.Errorf("Expected unban to snapshot the removed ban")
+				}
+			}
+		})
+	}
+}
+
+func Test_server_dashboardSiteModerator(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Bans:      mockBanStore{},
+		Sites: mockSiteStore{sites: []*model.Site{
+			{ID: 1, Name: "example"},
+			{ID: 2, Name: "other"},
+		}},
+		DashboardSecret: []byte("dashboard-secret"),
+	}
+
+	moderator := dashboardCookie(t, router, &dashboardSession{Moderator: "site:example", Site: "example"})
+
+	request, _ := http.NewRequest("GET", DashboardPath+"/pending?site=other", nil)
+	request.AddCookie(moderator)
+	recorder := httptest.NewRecorder()
+	muxRouter := router.Router()
+	muxRouter.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected handler to respond with code %v, but got %v", http.StatusOK, recorder.Code)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, `<span class="site">example</span>`) || strings.Contains(body, "<select name=\"site\"") {
+		t.Errorf("Expected site moderator to stay on their site, but got %v", body)
+	}
+
+	// The dashboard cookie does not authenticate api requests
+	request, _ = http.NewRequest("GET", "/admin/bans", nil)
+	request.AddCookie(moderator)
+	recorder = httptest.NewRecorder()
+	muxRouter.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected api to refuse dashboard cookie with code %v, but got %v", http.StatusUnauthorized, recorder.Code)
+	}
+}
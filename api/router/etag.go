@@ -0,0 +1,80 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// commentETag returns the strong entity tag of comment, which changes with
+// every version of the comment
+func commentETag(comment *model.Comment) string {
+	return fmt.Sprintf(`"%d-%d"`, *comment.ID, comment.Version)
+}
+
+// listETag returns the strong entity tag of a list of comments, which
+// changes when comments are added, removed, reordered, or changed
+func listETag(comments []*model.Comment) string {
+	hash := sha256.New()
+	for _, comment := range comments {
+		fmt.Fprintf(hash, "%d-%d,", *comment.ID, comment.Version)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// matchesETag reports if etag is listed in the value of an If-Match or
+// If-None-Match header. Weak tags only match when weak is set.
+func matchesETag(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag header of a read response, and responds with 304
+// Not Modified if the client already has the current representation
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	// Reactions of the requesting reader are part of the representation
+	w.Header().Add("Vary", "Cookie")
+
+	if header := r.Header.Get("If-None-Match"); header != "" && matchesETag(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// checkIfMatch returns the version of existing a write must apply to, 0 if
+// the request is unconditional, or 412 Precondition Failed if the request
+// expects another version
+func checkIfMatch(r *http.Request, existing *model.Comment) (uint, *httpResponse) {
+	header := r.Header.Get("If-Match")
+	if header == "" || existing == nil {
+		return 0, nil
+	}
+
+	if !matchesETag(header, commentETag(existing), false) {
+		return 0, preconditionFailed()
+	}
+	return existing.Version, nil
+}
+
+// preconditionFailed is the response to writes of outdated versions
+func preconditionFailed() *httpResponse {
+	return &httpResponse{
+		StatusCode:  http.StatusPreconditionFailed,
+		Message:     http.StatusText(http.StatusPreconditionFailed),
+		Description: model.ErrVersionConflict.Error() + ".",
+	}
+}
@@ -0,0 +1,153 @@
+package router
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_server_etags(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+	}
+	muxRouter := router.Router()
+
+	// Fetch the list once to learn its entity tag
+	request, _ := http.NewRequest("GET", "/?url=http://example.com/posts/1", nil)
+	recorder := httptest.NewRecorder()
+	muxRouter.ServeHTTP(recorder, request)
+	listETag := recorder.Header().Get("ETag")
+	if listETag == "" {
+		t.Fatalf("Expected list response to have an ETag")
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		headers    map[string]string
+		body       string
+		statusCode int
+		etag       string
+	}{
+		{
+			name:       "Get comment",
+			method:     "GET",
+			path:       "/1",
+			statusCode: http.StatusOK,
+			etag:       `"1-3"`,
+		},
+		{
+			name:       "Get comment that is not modified",
+			method:     "GET",
+			path:       "/1",
+			headers:    map[string]string{"If-None-Match": `"0-1", "1-3"`},
+			statusCode: http.StatusNotModified,
+			etag:       `"1-3"`,
+		},
+		{
+			name:       "Get comment that is not modified with weak tag",
+			method:     "GET",
+			path:       "/1",
+			headers:    map[string]string{"If-None-Match": `W/"1-3"`},
+			statusCode: http.StatusNotModified,
+			etag:       `"1-3"`,
+		},
+		{
+			name:       "Get comment that was modified",
+			method:     "GET",
+			path:       "/1",
+			headers:    map[string]string{"If-None-Match": `"1-2"`},
+			statusCode: http.StatusOK,
+			etag:       `"1-3"`,
+		},
+		{
+			name:       "Get comments that are not modified",
+			method:     "GET",
+			path:       "/?url=http://example.com/posts/1",
+			headers:    map[string]string{"If-None-Match": listETag},
+			statusCode: http.StatusNotModified,
+			etag:       listETag,
+		},
+		{
+			name:       "Put comment matching current version",
+			method:     "PUT",
+			path:       "/1",
+			headers:    map[string]string{"If-Match": `"1-3"`},
+			body:       `{"content": "Edited content", "url": "http://example.com/posts/1"}`,
+			statusCode: http.StatusOK,
+			etag:       `"1-4"`,
+		},
+		{
+			name:       "Put comment matching any version",
+			method:     "PUT",
+			path:       "/1",
+			headers:    map[string]string{"If-Match": "*"},
+			body:       `{"content": "Edited content", "url": "http://example.com/posts/1"}`,
+			statusCode: http.StatusOK,
+			etag:       `"1-4"`,
+		},
+		{
+			name:       "Put comment matching outdated version",
+			method:     "PUT",
+			path:       "/1",
+			headers:    map[string]string{"If-Match": `"1-2"`},
+			body:       `{"content": "Edited content", "url": "http://example.com/posts/1"}`,
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Put comment matching weak tag",
+			method:     "PUT",
+			path:       "/1",
+			headers:    map[string]string{"If-Match": `W/"1-3"`},
+			body:       `{"content": "Edited content", "url": "http://example.com/posts/1"}`,
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Patch comment matching outdated version",
+			method:     "PATCH",
+			path:       "/1",
+			headers:    map[string]string{"If-Match": `"1-2"`},
+			body:       `{"content": "Edited content", "url": "http://example.com/posts/1"}`,
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Delete comment matching outdated version",
+			method:     "DELETE",
+			path:       "/1",
+			headers:    map[string]string{"If-Match": `"1-2"`},
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Delete comment matching current version",
+			method:     "DELETE",
+			path:       "/1",
+			headers:    map[string]string{"If-Match": `"1-3"`},
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			for header, value := range tt.headers {
+				request.Header.Set(header, value)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if etag := recorder.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("Expected ETag %v, but got %v", tt.etag, etag)
+			}
+
+			if recorder.Code == http.StatusNotModified && recorder.Body.Len() != 0 {
+				t.Errorf("Expected empty body for not modified response, but got %v", recorder.Body)
+			}
+		})
+	}
+}
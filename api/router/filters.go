@@ -0,0 +1,141 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// filters returns the filter store scoped to the site of the request
+func (router *Router) filters(r *http.Request) model.FilterStore {
+	return router.Filters.ForSite(siteID(r))
+}
+
+func (router *Router) filterHandlerGetAll(w http.ResponseWriter, r *http.Request) {
+	rules, err := router.filters(r).GetFilterRules()
+
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not get filter rules.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, rules, http.StatusOK)
+}
+
+func (router *Router) filterHandlerPost(w http.ResponseWriter, r *http.Request) {
+	rule := &model.FilterRule{}
+	if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode filter rule in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	rule, err := router.filters(r).CreateFilterRule(rule)
+
+	if fieldErrs, ok := err.(model.ValidationError); ok {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Filter rule contains illegal fields.",
+			Errors:      fieldErrs,
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to create filter rule.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.audit(r, model.AuditAddFilter, model.AuditTargetFilter, rule.ID, nil, rule)
+
+	jsonResponse(w, rule, http.StatusCreated)
+}
+
+func (router *Router) filterHandlerDelete(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	err := router.filters(r).DeleteFilterRule(*id)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find filter rule with id %v.", *id),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to remove filter rule.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.audit(r, model.AuditRemoveFilter, model.AuditTargetFilter, *id, nil, nil)
+
+	response := httpResponse{
+		StatusCode:  http.StatusOK,
+		Message:     http.StatusText(http.StatusOK),
+		Description: "Filter rule successfully removed.",
+	}
+	jsonResponse(w, response, response.StatusCode)
+}
+
+// filterHitsHandlerGet shows moderators the filter rules a comment
+// triggered
+func (router *Router) filterHitsHandlerGet(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	hits, err := router.filters(r).GetFilterHits(*id)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not get filter hits of comment.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, hits, http.StatusOK)
+}
@@ -0,0 +1,193 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+type mockFilterStore struct{}
+
+func (s mockFilterStore) GetFilterRules() ([]*model.FilterRule, error) {
+	return []*model.FilterRule{{ID: 1, Pattern: "darn", Action: model.FilterMask}}, nil
+}
+
+func (s mockFilterStore) CreateFilterRule(rule *model.FilterRule) (*model.FilterRule, error) {
+	if rule.Action != model.FilterMask {
+		return nil, model.ValidationError{{Field: "action", Code: model.CodeInvalid, Message: "Action must be reject, mask or hold"}}
+	}
+	rule.ID = 2
+	return rule, nil
+}
+
+func (s mockFilterStore) DeleteFilterRule(id uint) error {
+	if id != 1 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s mockFilterStore) GetFilterHits(id uint) ([]*model.FilterHit, error) {
+	if id != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return []*model.FilterHit{{ID: 1, CommentID: 1, RuleID: 1, Pattern: "darn", Action: model.FilterMask}}, nil
+}
+
+func (s mockFilterStore) ForSite(siteID uint) model.FilterStore {
+	return s
+}
+
+func Test_server_filterHandlers(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Filters:   mockFilterStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		statusCode int
+		want       interface{}
+		response   *httpResponse
+	}{
+		{
+			name:       "List filter rules",
+			method:     "GET",
+			path:       "/admin/filters",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			want:       &[]*model.FilterRule{{ID: 1, Pattern: "darn", Action: model.FilterMask}},
+		},
+		{
+			name:       "List filter rules without moderator credentials",
+			method:     "GET",
+			path:       "/admin/filters",
+			statusCode: http.StatusUnauthorized,
+			response: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Add filter rule",
+			method:     "POST",
+			path:       "/admin/filters",
+			body:       `{"pattern": "heck", "action": "mask"}`,
+			token:      "admin-secret",
+			statusCode: http.StatusCreated,
+			want:       &model.FilterRule{ID: 2, Pattern: "heck", Action: model.FilterMask},
+		},
+		{
+			name:       "Add invalid filter rule",
+			method:     "POST",
+			path:       "/admin/filters",
+			body:       `{"pattern": "heck", "action": "delete"}`,
+			token:      "admin-secret",
+			statusCode: http.StatusBadRequest,
+			response: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Filter rule contains illegal fields.",
+				Errors:      []model.FieldError{{Field: "action", Code: model.CodeInvalid, Message: "Action must be reject, mask or hold"}},
+			},
+		},
+		{
+			name:       "Remove filter rule",
+			method:     "DELETE",
+			path:       "/admin/filters/1",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			response: &httpResponse{
+				StatusCode:  http.StatusOK,
+				Message:     http.StatusText(http.StatusOK),
+				Description: "Filter rule successfully removed.",
+			},
+		},
+		{
+			name:       "Remove filter rule not in db",
+			method:     "DELETE",
+			path:       "/admin/filters/9",
+			token:      "admin-secret",
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find filter rule with id 9.",
+			},
+		},
+		{
+			name:       "Get filter hits of comment",
+			method:     "GET",
+			path:       "/1/filters",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			want:       &[]*model.FilterHit{{ID: 1, CommentID: 1, RuleID: 1, Pattern: "darn", Action: model.FilterMask}},
+		},
+		{
+			name:       "Get filter hits without moderator credentials",
+			method:     "GET",
+			path:       "/1/filters",
+			statusCode: http.StatusUnauthorized,
+			response: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Get filter hits of comment not in db",
+			method:     "GET",
+			path:       "/1000/filters",
+			token:      "admin-secret",
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			want := tt.want
+			if want == nil {
+				want = tt.response
+			}
+
+			got := reflect.New(reflect.TypeOf(want).Elem()).Interface()
+			if err := json.NewDecoder(recorder.Body).Decode(got); err != nil {
+				t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected json response to be %v, but got %v", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+type flagRequest struct {
+	Reason string `json:"reason"`
+}
+
+// reader identifies the reader making the request by their user account as
+// user:<id>, or else by the hash of their ip address as ip:<hash>. Readers
+// cannot choose their identity, so each reader counts once towards the flag
+// threshold.
+func (router *Router) reader(r *http.Request) string {
+	if user := router.sessionUser(r); user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	} else if ipHash := router.Clients.HashIP(router.clientIP(r)); ipHash != "" {
+		return "ip:" + ipHash
+	}
+	return ""
+}
+
+// flagHandler lets readers flag a comment for moderators, each reader flags a
+// comment once
+func (router *Router) flagHandler(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	body := flagRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode flag in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	reporter := router.reader(r)
+	if reporter == "" {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not identify the reporter.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	ban, httpErr := router.checkVoterBan(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	// Flags of shadow banned readers are silently dropped
+	var err error
+	if ban == nil {
+		var comment *model.Comment
+		if comment, err = router.commenter(r).FlagComment(*id, reporter, body.Reason); err == nil {
+			router.counts.invalidate(countCacheKey(siteID(r), comment.URL))
+		}
+	}
+
+	if err == model.ErrInvalidFlagReason {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to flag comment.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	response := httpResponse{
+		StatusCode:  http.StatusOK,
+		Message:     http.StatusText(http.StatusOK),
+		Description: "Comment successfully flagged.",
+	}
+	jsonResponse(w, response, response.StatusCode)
+}
+
+// flagQueueHandler lists flagged comments for moderators, the most flagged
+// first
+func (router *Router) flagQueueHandler(w http.ResponseWriter, r *http.Request) {
+	comments, err := router.commenter(r).GetFlaggedComments()
+
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not get flagged comments.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, comments, http.StatusOK)
+}
+
+// flagHandlerDelete dismisses the flags of a comment
+func (router *Router) flagHandlerDelete(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	comment, err := router.commenter(r).ClearFlags(*id)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to clear flags of comment.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.audit(r, model.AuditClearFlags, model.AuditTargetComment, *id, nil, comment)
+
+	w.Header().Set("ETag", commentETag(comment))
+	jsonResponse(w, comment, http.StatusOK)
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_server_flagHandlers(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	flagged, _ := mockCommentStore{}.GetFlaggedComments()
+	cleared, _ := mockCommentStore{}.ClearFlags(1)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		remoteAddr string
+		token      string
+		statusCode int
+		want       interface{}
+		response   *httpResponse
+	}{
+		{
+			name:       "Flag comment",
+			method:     "POST",
+			path:       "/1/flag",
+			body:       `{"reason": "spam"}`,
+			remoteAddr: "192.0.2.1:1234",
+			statusCode: http.StatusOK,
+			response: &httpResponse{
+				StatusCode:  http.StatusOK,
+				Message:     http.StatusText(http.StatusOK),
+				Description: "Comment successfully flagged.",
+			},
+		},
+		{
+			name:       "Flag comment for unknown reason",
+			method:     "POST",
+			path:       "/1/flag",
+			body:       `{"reason": "boring"}`,
+			remoteAddr: "192.0.2.1:1234",
+			statusCode: http.StatusBadRequest,
+			response: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Reason must be spam, abuse, off-topic or other.",
+			},
+		},
+		{
+			name:       "Flag comment without reporter",
+			method:     "POST",
+			path:       "/1/flag",
+			body:       `{"reason": "spam"}`,
+			statusCode: http.StatusBadRequest,
+			response: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Could not identify the reporter.",
+			},
+		},
+		{
+			name:       "Flag comment not in db",
+			method:     "POST",
+			path:       "/1000/flag",
+			body:       `{"reason": "spam"}`,
+			remoteAddr: "192.0.2.1:1234",
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+		{
+			name:       "List flagged comments",
+			method:     "GET",
+			path:       "/admin/flags",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			want:       &flagged,
+		},
+		{
+			name:       "List flagged comments without moderator credentials",
+			method:     "GET",
+			path:       "/admin/flags",
+			statusCode: http.StatusUnauthorized,
+			response: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Clear flags",
+			method:     "DELETE",
+			path:       "/1/flags",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			want:       cleared,
+		},
+		{
+			name:       "Clear flags of comment not in db",
+			method:     "DELETE",
+			path:       "/1000/flags",
+			token:      "admin-secret",
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			request.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			want := tt.want
+			if want == nil {
+				want = tt.response
+			}
+
+			got := reflect.New(reflect.TypeOf(want).Elem()).Interface()
+			if err := json.NewDecoder(recorder.Body).Decode(got); err != nil {
+				t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected json response to be %v, but got %v", want, got)
+			}
+		})
+	}
+}
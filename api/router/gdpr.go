@@ -0,0 +1,164 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// dataRequestTTL controls how long data requests can be confirmed
+const dataRequestTTL = time.Hour
+
+// DataRequestSender delivers the tokens confirming data requests to the
+// email address the request is for
+type DataRequestSender interface {
+	SendDataRequest(request *model.DataRequest, token string) error
+}
+
+type dataRequest struct {
+	Email  string `json:"email"`
+	Action string `json:"action"`
+}
+
+type dataConfirmation struct {
+	Token string `json:"token"`
+}
+
+// dataRequestHandlerPost requests an export or erasure of the data of an
+// email address, sending a confirmation token to it. The response is the
+// same whether any data is stored or not.
+func (router *Router) dataRequestHandlerPost(w http.ResponseWriter, r *http.Request) {
+	body := dataRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode data request in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	request := &model.DataRequest{Email: body.Email, Action: body.Action}
+	token, err := router.GDPR.CreateDataRequest(request, dataRequestTTL)
+
+	switch err {
+	case nil:
+	case model.ErrInvalidEmail, model.ErrInvalidDataAction:
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	default:
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to create data request.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if router.DataRequests != nil {
+		if err := router.DataRequests.SendDataRequest(request, token); err != nil {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Failed to send data request confirmation.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+	}
+
+	response := httpResponse{
+		StatusCode:  http.StatusAccepted,
+		Message:     http.StatusText(http.StatusAccepted),
+		Description: "Confirm the request using the token sent to the email address.",
+	}
+	jsonResponse(w, response, response.StatusCode)
+}
+
+// dataRequestHandlerConfirm carries out the data request confirmed by the
+// token in the payload. Reactions are included for the voter making the
+// request, as voters cannot be linked to email addresses.
+func (router *Router) dataRequestHandlerConfirm(w http.ResponseWriter, r *http.Request) {
+	body := dataConfirmation{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode confirmation in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	request, err := router.GDPR.ConfirmDataRequest(body.Token)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: "Confirmation token is invalid, expired or already used.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to confirm data request.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	// Reactions of the account of the person are found by the store, others
+	// may share the ip address of the reader confirming the request
+	voters := []string{}
+
+	if request.Action == model.DataExportAction {
+		export, err := router.GDPR.ExportData(request.Email, voters)
+		if err != nil {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Failed to export data.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		jsonResponse(w, export, http.StatusOK)
+		return
+	}
+
+	erased, err := router.GDPR.EraseData(request.Email, voters)
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to erase data.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.counts.invalidate()
+
+	response := httpResponse{
+		StatusCode:  http.StatusOK,
+		Message:     http.StatusText(http.StatusOK),
+		Description: fmt.Sprintf("Erased %d comments and %d reactions.", len(erased.Comments), len(erased.Reactions)),
+	}
+	jsonResponse(w, response, response.StatusCode)
+}
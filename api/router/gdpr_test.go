@@ -0,0 +1,181 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+type mockGDPRStore struct{}
+
+func (s mockGDPRStore) CreateDataRequest(request *model.DataRequest, ttl time.Duration) (string, error) {
+	if request.Email == "" {
+		return "", model.ErrInvalidEmail
+	} else if request.Action != model.DataExportAction && request.Action != model.DataEraseAction {
+		return "", model.ErrInvalidDataAction
+	}
+	return request.Action + "-token", nil
+}
+
+func (s mockGDPRStore) ConfirmDataRequest(token string) (*model.DataRequest, error) {
+	switch token {
+	case "export-token":
+		return &model.DataRequest{Email: "alice@example.com", Action: model.DataExportAction}, nil
+	case "erase-token":
+		return &model.DataRequest{Email: "alice@example.com", Action: model.DataEraseAction}, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockGDPRStore) ExportData(email string, voters []string) (*model.DataExport, error) {
+	id := uint(1)
+	export := &model.DataExport{
+		Email:      email,
+		Identities: []*model.Identity{},
+		Comments:   []*model.Comment{{ID: &id, Email: email, Content: "Some content"}},
+		Reactions:  []*model.Reaction{},
+	}
+	for _, voter := range voters {
+		export.Reactions = append(export.Reactions, &model.Reaction{CommentID: 2, Voter: voter, Kind: "like"})
+	}
+	return export, nil
+}
+
+func (s mockGDPRStore) EraseData(email string, voters []string) (*model.DataExport, error) {
+	return s.ExportData(email, voters)
+}
+
+type mockDataRequestSender struct {
+	sent []string
+}
+
+func (m *mockDataRequestSender) SendDataRequest(request *model.DataRequest, token string) error {
+	m.sent = append(m.sent, token)
+	return nil
+}
+
+func Test_server_dataRequestHandlers(t *testing.T) {
+	sender := &mockDataRequestSender{}
+	router := &Router{
+		Commenter:    &mockCommentStore{},
+		GDPR:         mockGDPRStore{},
+		DataRequests: sender,
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		statusCode int
+		export     *model.DataExport
+		response   *httpResponse
+	}{
+		{
+			name:       "Request data export",
+			path:       "/gdpr/requests",
+			body:       `{"email": "alice@example.com", "action": "export"}`,
+			statusCode: http.StatusAccepted,
+			response: &httpResponse{
+				StatusCode:  http.StatusAccepted,
+				Message:     http.StatusText(http.StatusAccepted),
+				Description: "Confirm the request using the token sent to the email address.",
+			},
+		},
+		{
+			name:       "Request unknown action",
+			path:       "/gdpr/requests",
+			body:       `{"email": "alice@example.com", "action": "delete"}`,
+			statusCode: http.StatusBadRequest,
+			response: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Action must be export or erase.",
+			},
+		},
+		{
+			name:       "Confirm data export",
+			path:       "/gdpr/confirm",
+			body:       `{"token": "export-token"}`,
+			statusCode: http.StatusOK,
+			export: &model.DataExport{
+				Email:      "alice@example.com",
+				Identities: []*model.Identity{},
+				Comments:   []*model.Comment{{Email: "alice@example.com", Content: "Some content"}},
+				Reactions:  []*model.Reaction{},
+			},
+		},
+		{
+			name:       "Confirm data erasure",
+			path:       "/gdpr/confirm",
+			body:       `{"token": "erase-token"}`,
+			statusCode: http.StatusOK,
+			response: &httpResponse{
+				StatusCode:  http.StatusOK,
+				Message:     http.StatusText(http.StatusOK),
+				Description: "Erased 1 comments and 0 reactions.",
+			},
+		},
+		{
+			name:       "Confirm with invalid token",
+			path:       "/gdpr/confirm",
+			body:       `{"token": "wrong"}`,
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Confirmation token is invalid, expired or already used.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest("POST", tt.path, bytes.NewBufferString(tt.body))
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.export != nil { // Expect export body
+				export := &model.DataExport{}
+				if err := json.NewDecoder(recorder.Body).Decode(export); err != nil {
+					t.Errorf("Could not decode export body %v because of error %v", recorder.Body, err)
+				}
+
+				// Ids are not compared, as comment ids are pointers
+				for _, comment := range export.Comments {
+					comment.ID = nil
+				}
+
+				if !reflect.DeepEqual(export, tt.export) {
+					t.Errorf("Expected export %+v, but was %+v", tt.export, export)
+				}
+
+			} else { // Expect response body
+				response := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+					t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(response, tt.response) {
+					t.Errorf("Expected json response to be %v, but got %v", tt.response, response)
+				}
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(sender.sent, []string{"export-token"}) {
+		t.Errorf("Expected confirmation token to be sent, but sent %v", sender.sent)
+	}
+}
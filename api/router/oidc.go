@@ -0,0 +1,175 @@
+package router
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/snorremd/gocomment/api/auth"
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// oidcCookie holds the state of a login in progress
+const oidcCookie = "gocomment_oidc"
+
+// oidcLoginMaxAge is how long users have to log in with the provider
+const oidcLoginMaxAge = 10 * 60
+
+// OIDCProvider logs users in with an OpenID Connect provider, see
+// auth.OIDCProvider
+type OIDCProvider interface {
+	AuthCodeURL(state string, nonce string, verifier string) string
+	Authenticate(code string, verifier string, nonce string) (*auth.Claims, error)
+}
+
+// oidcLogin is the login state kept in the oidc cookie until the provider
+// redirects back
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"returnTo"`
+}
+
+// safeReturnTo returns returnTo if it is a relative path or belongs to the
+// origin of a site, so logins cannot redirect users anywhere else
+func (router *Router) safeReturnTo(returnTo string) string {
+	if strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\") {
+		return returnTo
+	}
+
+	u, err := url.Parse(returnTo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || router.Sites == nil {
+		return ""
+	}
+
+	if _, err := router.Sites.GetSiteByOrigin(u.Scheme + "://" + u.Host); err != nil {
+		return ""
+	}
+	return returnTo
+}
+
+// profileUsername picks the username suggested by the provider claims
+func profileUsername(claims *auth.Claims) string {
+	for _, name := range []string{claims.PreferredUsername, claims.Name} {
+		if name != "" {
+			return name
+		}
+	}
+	if at := strings.Index(claims.Email, "@"); at > 0 {
+		return claims.Email[:at]
+	}
+	return ""
+}
+
+func (router *Router) oidcHandlerLogin(w http.ResponseWriter, r *http.Request) {
+	login := oidcLogin{ReturnTo: router.safeReturnTo(r.URL.Query().Get("return_to"))}
+
+	var err error
+	for _, token := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		if *token, err = auth.RandomToken(); err != nil {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Failed to start login.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+	}
+
+	value, _ := json.Marshal(&login)
+	router.setCookie(w, oidcCookie, base64.RawURLEncoding.EncodeToString(value), oidcLoginMaxAge)
+
+	http.Redirect(w, r, router.OIDC.AuthCodeURL(login.State, login.Nonce, login.Verifier), http.StatusFound)
+}
+
+func (router *Router) oidcHandlerCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if denied := query.Get("error"); denied != "" {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusUnauthorized,
+			Message:     http.StatusText(http.StatusUnauthorized),
+			Description: fmt.Sprintf("Identity provider denied login with %v.", denied),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	login := oidcLogin{}
+	cookie, err := r.Cookie(oidcCookie)
+	if err == nil {
+		var value []byte
+		if value, err = base64.RawURLEncoding.DecodeString(cookie.Value); err == nil {
+			err = json.Unmarshal(value, &login)
+		}
+	}
+
+	if err != nil || login.State == "" {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Login has expired, please try again.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.setCookie(w, oidcCookie, "", -1)
+
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Login state does not match.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	claims, err := router.OIDC.Authenticate(query.Get("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusUnauthorized,
+			Message:     http.StatusText(http.StatusUnauthorized),
+			Description: "Could not verify identity.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	identity := &model.Identity{Provider: claims.Issuer, Subject: claims.Subject}
+	profile := &model.User{
+		Username:      profileUsername(claims),
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}
+
+	user, err := router.Users.LinkIdentity(identity, profile)
+	if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to link identity.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if httpErr := router.startSession(w, user); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if login.ReturnTo != "" {
+		http.Redirect(w, r, login.ReturnTo, http.StatusFound)
+		return
+	}
+
+	jsonResponse(w, user, http.StatusOK)
+}
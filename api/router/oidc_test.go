@@ -0,0 +1,173 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/auth"
+	"github.com/snorremd/gocomment/api/model"
+)
+
+type mockOIDCProvider struct{}
+
+func (p mockOIDCProvider) AuthCodeURL(state string, nonce string, verifier string) string {
+	return "https://id.example.com/authorize?" + url.Values{"state": {state}}.Encode()
+}
+
+func (p mockOIDCProvider) Authenticate(code string, verifier string, nonce string) (*auth.Claims, error) {
+	if code != "alice-code" || verifier == "" || nonce == "" {
+		return nil, errors.New("invalid_grant")
+	}
+	return &auth.Claims{Issuer: "https://id.example.com", Subject: "alice-id", Email: "alice@example.com"}, nil
+}
+
+func Test_server_oidcHandlers(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Sites: mockSiteStore{
+			sites: []*model.Site{{ID: 1, Name: "example", Origins: "https://example.com"}},
+		},
+		Users: mockUserStore{},
+		OIDC:  mockOIDCProvider{},
+	}
+	muxRouter := router.Router()
+
+	// login starts a login returning to returnTo and returns the state and
+	// login cookie
+	login := func(returnTo string) (string, *http.Cookie) {
+		request, _ := http.NewRequest("GET", "/auth/oidc/login?"+url.Values{"return_to": {returnTo}}.Encode(), nil)
+		recorder := httptest.NewRecorder()
+		muxRouter.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusFound {
+			t.Fatalf("Expected login to redirect to provider, but got %v", recorder.Code)
+		}
+
+		location, _ := url.Parse(recorder.Header().Get("Location"))
+		cookies := recorder.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != oidcCookie || !cookies[0].HttpOnly {
+			t.Fatalf("Expected login to set http only login cookie, but got %v", cookies)
+		}
+		return location.Query().Get("state"), cookies[0]
+	}
+
+	tests := []struct {
+		name       string
+		returnTo   string
+		code       string
+		state      string
+		noCookie   bool
+		statusCode int
+		location   string
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Log in",
+			code:       "alice-code",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Log in returning to site",
+			returnTo:   "https://example.com/post/1",
+			code:       "alice-code",
+			statusCode: http.StatusFound,
+			location:   "https://example.com/post/1",
+		},
+		{
+			name:       "Log in returning to relative path",
+			returnTo:   "/post/1",
+			code:       "alice-code",
+			statusCode: http.StatusFound,
+			location:   "/post/1",
+		},
+		{
+			name:       "Log in returning to unknown origin",
+			returnTo:   "https://evil.example.com/",
+			code:       "alice-code",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Log in with wrong state",
+			code:       "alice-code",
+			state:      "forged",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Login state does not match.",
+			},
+		},
+		{
+			name:       "Log in without login cookie",
+			code:       "alice-code",
+			noCookie:   true,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Login has expired, please try again.",
+			},
+		},
+		{
+			name:       "Log in with invalid code",
+			code:       "wrong-code",
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Could not verify identity.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			state, cookie := login(tt.returnTo)
+			if tt.state != "" {
+				state = tt.state
+			}
+
+			request, _ := http.NewRequest("GET", "/auth/oidc/callback?"+url.Values{"code": {tt.code}, "state": {state}}.Encode(), nil)
+			if !tt.noCookie {
+				request.AddCookie(cookie)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody != nil { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+				return
+			}
+
+			if location := recorder.Header().Get("Location"); location != tt.location {
+				t.Errorf("Expected redirect to %q, but got %q", tt.location, location)
+			}
+
+			session := ""
+			for _, cookie := range recorder.Result().Cookies() {
+				if cookie.Name == SessionCookie {
+					session = cookie.Value
+				}
+			}
+			if session != "alice-session" {
+				t.Errorf("Expected session cookie for alice, but got %v", recorder.Result().Cookies())
+			}
+		})
+	}
+}
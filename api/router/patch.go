@@ -0,0 +1,78 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// patchableFields lists the json fields of a comment a patch may change
+var patchableFields = []string{"parentId", "username", "email", "content", "url"}
+
+// moderatorFields lists the json fields only moderators may patch in
+// addition to patchableFields
+var moderatorFields = []string{"status", "upvotes", "downvotes"}
+
+// mergePatch applies the JSON Merge Patch (RFC 7396) in the request body to
+// a copy of comment. Null values reset fields to their zero value. Fields only
+// moderators may change are rejected unless moderator is set.
+func mergePatch(comment *model.Comment, r *http.Request, moderator bool) (*model.Comment, *httpResponse) {
+	patch := map[string]json.RawMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode patch in payload.",
+		}
+	}
+
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := map[string]json.RawMessage{}
+	current, _ := json.Marshal(comment)
+	json.Unmarshal(current, &fields)
+
+	for _, key := range keys {
+		if !containsString(patchableFields, key) && !(moderator && containsString(moderatorFields, key)) {
+			return nil, &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: fmt.Sprintf("Field %v cannot be patched.", key),
+			}
+		}
+
+		if string(patch[key]) == "null" {
+			delete(fields, key)
+		} else {
+			fields[key] = patch[key]
+		}
+	}
+
+	merged, _ := json.Marshal(fields)
+	patched := &model.Comment{}
+	if err := json.Unmarshal(merged, patched); err != nil {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Patch contains values of the wrong type.",
+		}
+	}
+
+	return patched, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
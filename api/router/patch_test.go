@@ -0,0 +1,202 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+func Test_server_commentHandlerPatch(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	tests := []struct {
+		name        string
+		id          uint
+		patch       string
+		token       string
+		statusCode  int
+		commentBody *model.Comment
+		errorBody   *httpResponse
+	}{
+		{
+			name:       "Patch content",
+			id:         uint(1),
+			patch:      `{"content": "Edited content"}`,
+			statusCode: http.StatusOK,
+			commentBody: &model.Comment{
+				Content: "Edited content",
+				URL:     "http://example.com/posts/1",
+			},
+		},
+		{
+			name:       "Patch fields to zero values",
+			id:         uint(1),
+			patch:      `{"upvotes": 0, "status": null, "username": ""}`,
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			commentBody: &model.Comment{
+				Content: "Some content",
+				URL:     "http://example.com/posts/1",
+			},
+		},
+		{
+			name:       "Patch counters",
+			id:         uint(1),
+			patch:      `{"upvotes": 3, "downvotes": 1, "status": "Approved"}`,
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			commentBody: &model.Comment{
+				Content:   "Some content",
+				Upvotes:   3,
+				Downvotes: 1,
+				Status:    model.StatusApproved,
+				URL:       "http://example.com/posts/1",
+			},
+		},
+		{
+			name:       "Patch status without moderator credentials",
+			id:         uint(1),
+			patch:      `{"content": "Edited content", "status": "Approved"}`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Field status cannot be patched.",
+			},
+		},
+		{
+			name:       "Patch votes without moderator credentials",
+			id:         uint(1),
+			patch:      `{"upvotes": 100}`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Field upvotes cannot be patched.",
+			},
+		},
+		{
+			name:       "Patch field that is not editable",
+			id:         uint(1),
+			patch:      `{"content": "Edited content", "pinned": true}`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Field pinned cannot be patched.",
+			},
+		},
+		{
+			name:       "Patch field with value of wrong type",
+			id:         uint(1),
+			patch:      `{"upvotes": "many"}`,
+			token:      "admin-secret",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Patch contains values of the wrong type.",
+			},
+		},
+		{
+			name:       "Patch with payload that is not an object",
+			id:         uint(1),
+			patch:      `["content"]`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Could not decode patch in payload.",
+			},
+		},
+		{
+			name:       "Patch comment to invalid values",
+			id:         uint(1),
+			patch:      `{"content": " ", "url": null}`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "content", Code: model.CodeRequired, Message: "Content is required"},
+					{Field: "url", Code: model.CodeRequired, Message: "URL is required"},
+				},
+			},
+		},
+		{
+			name:       "Patch parent of comment",
+			id:         uint(1),
+			patch:      `{"parentId": 2}`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "parentId", Code: model.CodeReadOnly, Message: "Replies cannot be moved to another parent"},
+				},
+			},
+		},
+		{
+			name:       "Patch comment not in db",
+			id:         uint(1000),
+			patch:      `{"content": "Edited content"}`,
+			statusCode: http.StatusNotFound,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest("PATCH", fmt.Sprintf("/%v", tt.id), bytes.NewBufferString(tt.patch))
+			request.Header.Set("Content-Type", "application/merge-patch+json")
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.commentBody != nil { // Expect regular body
+				comment := &model.Comment{}
+				if err := json.NewDecoder(recorder.Body).Decode(comment); err != nil {
+					t.Errorf("Could not decode comment body %v because of error %v", recorder.Body, err)
+				}
+
+				got := []interface{}{comment.Content, comment.Username, comment.Upvotes, comment.Downvotes, comment.Status, comment.URL}
+				want := []interface{}{tt.commentBody.Content, tt.commentBody.Username, tt.commentBody.Upvotes, tt.commentBody.Downvotes, tt.commentBody.Status, tt.commentBody.URL}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Expected patched fields %v, but was %v", want, got)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
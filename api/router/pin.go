@@ -0,0 +1,75 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// commentFlagHandler returns a handler setting a moderator controlled flag
+// on a comment using set, recording action in the audit log
+func (router *Router) commentFlagHandler(action string, set func(model.CommentStore, uint) (*model.Comment, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, httpErr := validateIDParam(r)
+
+		if httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		comment, err := set(router.commenter(r), *id)
+
+		if err == gorm.ErrRecordNotFound {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		} else if err != nil {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Failed to update comment.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		router.audit(r, action, model.AuditTargetComment, *id, nil, comment)
+
+		if httpErr := router.withReactions(r, comment); httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		w.Header().Set("ETag", commentETag(comment))
+		jsonResponse(w, comment, http.StatusOK)
+	}
+}
+
+// pinHandler returns a handler pinning or unpinning a comment
+func (router *Router) pinHandler(pinned bool) http.HandlerFunc {
+	action := model.AuditUnpin
+	if pinned {
+		action = model.AuditPin
+	}
+	return router.commentFlagHandler(action, func(commenter model.CommentStore, id uint) (*model.Comment, error) {
+		return commenter.PinComment(id, pinned)
+	})
+}
+
+// featureHandler returns a handler featuring or unfeaturing a comment
+func (router *Router) featureHandler(featured bool) http.HandlerFunc {
+	action := model.AuditUnfeature
+	if featured {
+		action = model.AuditFeature
+	}
+	return router.commentFlagHandler(action, func(commenter model.CommentStore, id uint) (*model.Comment, error) {
+		return commenter.FeatureComment(id, featured)
+	})
+}
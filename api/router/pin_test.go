@@ -0,0 +1,113 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+func Test_server_pinAndFeatureHandlers(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		AdminKey:  "secret",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		statusCode int
+		pinned     bool
+		featured   bool
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Pin comment",
+			method:     "PUT",
+			path:       "/1/pin",
+			token:      "secret",
+			statusCode: http.StatusOK,
+			pinned:     true,
+		},
+		{
+			name:       "Unpin comment",
+			method:     "DELETE",
+			path:       "/1/pin",
+			token:      "secret",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Feature comment",
+			method:     "PUT",
+			path:       "/1/feature",
+			token:      "secret",
+			statusCode: http.StatusOK,
+			featured:   true,
+		},
+		{
+			name:       "Pin comment without credentials",
+			method:     "PUT",
+			path:       "/1/pin",
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Pin comment that is not in db",
+			method:     "PUT",
+			path:       "/1000/pin",
+			token:      "secret",
+			statusCode: http.StatusNotFound,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody == nil { // Expect regular body
+				comment := &model.Comment{}
+				if err := json.NewDecoder(recorder.Body).Decode(comment); err != nil {
+					t.Errorf("Could not decode comment body %v because of error %v", recorder.Body, err)
+				}
+
+				if comment.Pinned != tt.pinned || comment.Featured != tt.featured {
+					t.Errorf("Expected pinned %v and featured %v, but was %v and %v", tt.pinned, tt.featured, comment.Pinned, comment.Featured)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
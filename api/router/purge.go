@@ -0,0 +1,51 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// commentHandlerPurge permanently removes a comment, including comments
+// that were deleted already
+func (router *Router) commentHandlerPurge(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	err := router.commenter(r).PurgeComment(*id)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to purge comment.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	router.counts.invalidate()
+	router.audit(r, model.AuditPurge, model.AuditTargetComment, *id, nil, nil)
+
+	response := httpResponse{
+		StatusCode:  http.StatusOK,
+		Message:     http.StatusText(http.StatusOK),
+		Description: "Comment successfully purged.",
+	}
+	jsonResponse(w, response, response.StatusCode)
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_server_commentHandlerPurge(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		statusCode int
+		response   *httpResponse
+	}{
+		{
+			name:       "Purge comment",
+			path:       "/1?purge=true",
+			token:      "admin-secret",
+			statusCode: http.StatusOK,
+			response: &httpResponse{
+				StatusCode:  http.StatusOK,
+				Message:     http.StatusText(http.StatusOK),
+				Description: "Comment successfully purged.",
+			},
+		},
+		{
+			name:       "Purge comment without moderator credentials",
+			path:       "/1?purge=true",
+			statusCode: http.StatusUnauthorized,
+			response: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Purge comment not in db",
+			path:       "/1000?purge=true",
+			token:      "admin-secret",
+			statusCode: http.StatusNotFound,
+			response: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+		{
+			name:       "Delete comment without purging",
+			path:       "/1?purge=false",
+			statusCode: http.StatusOK,
+			response: &httpResponse{
+				StatusCode:  http.StatusOK,
+				Message:     http.StatusText(http.StatusOK),
+				Description: "Comment successfully deleted.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest("DELETE", tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			response := &httpResponse{}
+			if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+				t.Errorf("Could not decode response body %v because of error %v", recorder.Body, err)
+			}
+
+			if !reflect.DeepEqual(response, tt.response) {
+				t.Errorf("Expected json response to be %v, but got %v", tt.response, response)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// reactionKinds returns the configured reaction set
+func (router *Router) reactionKinds() []string {
+	if len(router.Reactions) == 0 {
+		return model.DefaultReactions
+	}
+	return router.Reactions
+}
+
+// reactionKind returns the configured reaction matching kind regardless of
+// case, so differently cased kinds count as one reaction. Unknown kinds
+// return an empty string.
+func (router *Router) reactionKind(kind string) string {
+	for _, configured := range router.reactionKinds() {
+		if strings.EqualFold(configured, kind) {
+			return configured
+		}
+	}
+	return ""
+}
+
+// withReactions fills in the reaction counts of comments and the reactions
+// of the requesting reader
+func (router *Router) withReactions(r *http.Request, comments ...*model.Comment) *httpResponse {
+	if err := router.commenter(r).LoadReactions(comments, router.reader(r)); err != nil {
+		return &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not load reactions.",
+		}
+	}
+	return nil
+}
+
+// reactionHandler returns a handler adding or removing a reaction
+func (router *Router) reactionHandler(add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, httpErr := validateIDParam(r)
+
+		if httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		kind := router.reactionKind(mux.Vars(r)["kind"])
+		if kind == "" {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: fmt.Sprintf("Unknown reaction %v.", mux.Vars(r)["kind"]),
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		// Voters are identified like reporters of flags, so clients cannot
+		// react more than once by claiming to be someone else
+		voter := router.reader(r)
+		if voter == "" {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Could not identify the voter.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		ban, httpErr := router.checkVoterBan(r)
+
+		if httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		commenter := router.commenter(r)
+
+		var err error
+		switch {
+		case ban != nil:
+			// Reactions of shadow banned voters are silently dropped
+		case add:
+			err = commenter.AddReaction(*id, voter, kind)
+		default:
+			err = commenter.RemoveReaction(*id, voter, kind)
+		}
+
+		var comment *model.Comment
+		if err == nil {
+			comment, err = commenter.GetComment(*id)
+		}
+
+		if err == gorm.ErrRecordNotFound {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		} else if err != nil {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Failed to update reactions.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		if httpErr := router.withReactions(r, comment); httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		w.Header().Set("ETag", commentETag(comment))
+		jsonResponse(w, comment, http.StatusOK)
+	}
+}
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+func Test_server_reactionHandler(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Reactions: []string{"like", "laugh"},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		remoteAddr  string
+		statusCode  int
+		myReactions []string
+		errorBody   *httpResponse
+	}{
+		{
+			name:        "Add reaction",
+			method:      "POST",
+			path:        "/1/reactions/like",
+			remoteAddr:  "192.0.2.1:1234",
+			statusCode:  http.StatusOK,
+			myReactions: []string{"like"},
+		},
+		{
+			name:        "Remove reaction",
+			method:      "DELETE",
+			path:        "/1/reactions/like",
+			remoteAddr:  "192.0.2.2:1234",
+			statusCode:  http.StatusOK,
+			myReactions: []string{},
+		},
+		{
+			name:        "Add reaction in another case",
+			method:      "POST",
+			path:        "/1/reactions/LIKE",
+			remoteAddr:  "192.0.2.1:1234",
+			statusCode:  http.StatusOK,
+			myReactions: []string{"like"},
+		},
+		{
+			name:       "Add reaction not in reaction set",
+			method:     "POST",
+			path:       "/1/reactions/love",
+			remoteAddr: "192.0.2.1:1234",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Unknown reaction love.",
+			},
+		},
+		{
+			name:       "Add reaction without voter",
+			method:     "POST",
+			path:       "/1/reactions/like",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Could not identify the voter.",
+			},
+		},
+		{
+			name:       "Add reaction to comment that is not in db",
+			method:     "POST",
+			path:       "/1000/reactions/like",
+			remoteAddr: "192.0.2.1:1234",
+			statusCode: http.StatusNotFound,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find comment with id 1000.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.path, nil)
+			request.RemoteAddr = tt.remoteAddr
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody == nil { // Expect regular body
+				comment := &model.Comment{}
+				if err := json.NewDecoder(recorder.Body).Decode(comment); err != nil {
+					t.Errorf("Could not decode comment body %v because of error %v", recorder.Body, err)
+				}
+
+				if comment.Reactions["like"] != 2 {
+					t.Errorf("Expected 2 like reactions, but was %v", comment.Reactions)
+				}
+
+				if !reflect.DeepEqual(comment.MyReactions, tt.myReactions) {
+					t.Errorf("Expected my reactions %v, but was %v", tt.myReactions, comment.MyReactions)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
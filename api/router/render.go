@@ -0,0 +1,406 @@
+package router
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// RenderPath is the path threads are rendered as html under, for readers
+// without javascript and for crawlers
+const RenderPath = "/render"
+
+// renderPageSize is the number of top-level comments rendered per page,
+// replies are rendered with their top-level comment
+const renderPageSize = 20
+
+// Outcomes of posting a comment with the html form
+const (
+	renderPosted = "posted"
+	renderHeld   = "held"
+)
+
+// renderNotices are shown after redirecting from the html form, by outcome
+var renderNotices = map[string]string{
+	renderPosted: "Your comment was posted.",
+	renderHeld:   "Your comment is awaiting moderation.",
+}
+
+//go:embed render/thread.html
+var renderSource string
+
+var renderTemplate = template.Must(template.New("thread.html").Funcs(template.FuncMap{
+	"date": func(t *time.Time) string {
+		return t.Format("January 2, 2006 15:04")
+	},
+	"iso": func(t *time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+	"paragraphs": paragraphs,
+}).Parse(renderSource))
+
+// fragmentComment is a rendered comment with its rendered replies
+type fragmentComment struct {
+	*model.Comment
+	// ReplyLink opens the form to reply to the comment
+	ReplyLink string
+	Replies   []*fragmentComment
+}
+
+// Pending reports if the comment is held for moderation and only shown to
+// its poster
+func (c *fragmentComment) Pending() bool {
+	return c.Status == model.StatusPending
+}
+
+// fragmentForm holds the values of the html form, kept when a posted comment
+// is rejected
+type fragmentForm struct {
+	Username string
+	Email    string
+	Content  string
+}
+
+// fragmentPage holds what the thread fragment renders
+type fragmentPage struct {
+	URL string
+	// Action is the absolute url the html form posts to, the form is left
+	// out if empty
+	Action   string
+	Count    int
+	Comments []*fragmentComment
+	Page     int
+	Pages    int
+	Prev     string
+	Next     string
+	// Closed explains why the thread does not accept comments, the form is
+	// left out if set
+	Closed string
+	// Reply is the comment being replied to, and Cancel links back to the
+	// form for new comments
+	Reply  *model.Comment
+	Cancel string
+	Form   fragmentForm
+	Notice string
+	Error  string
+	Errors []model.FieldError
+}
+
+// paragraphs splits content into paragraphs at blank lines, and each
+// paragraph into lines
+func paragraphs(content string) [][]string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	result := [][]string{}
+	for _, paragraph := range strings.Split(content, "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			result = append(result, strings.Split(paragraph, "\n"))
+		}
+	}
+	return result
+}
+
+// threadTree groups comments under their parent in the order of the sort.
+// Replies to comments that are not shown and tombstones without shown replies
+// are left out. Comments link to the reply form with replyLink unless nil.
+func threadTree(comments []*model.Comment, replyLink func(*model.Comment) string) []*fragmentComment {
+	byID := map[uint]*fragmentComment{}
+	for _, comment := range comments {
+		rendered := &fragmentComment{Comment: comment}
+		if replyLink != nil && !comment.Deleted {
+			rendered.ReplyLink = replyLink(comment)
+		}
+		byID[*comment.ID] = rendered
+	}
+
+	topLevel := []*fragmentComment{}
+	for _, comment := range comments {
+		rendered := byID[*comment.ID]
+		if comment.ParentID == 0 {
+			topLevel = append(topLevel, rendered)
+		} else if parent, ok := byID[comment.ParentID]; ok {
+			parent.Replies = append(parent.Replies, rendered)
+		}
+	}
+
+	return pruneTombstones(topLevel)
+}
+
+// pruneTombstones leaves out tombstones without replies once their own
+// tombstones without replies are left out
+func pruneTombstones(comments []*fragmentComment) []*fragmentComment {
+	pruned := make([]*fragmentComment, 0, len(comments))
+	for _, comment := range comments {
+		comment.Replies = pruneTombstones(comment.Replies)
+		if !comment.Deleted || len(comment.Replies) > 0 {
+			pruned = append(pruned, comment)
+		}
+	}
+	return pruned
+}
+
+// countFragment counts the comments that are not deleted in comments and
+// their replies
+func countFragment(comments []*fragmentComment) int {
+	count := 0
+	for _, comment := range comments {
+		if !comment.Deleted {
+			count++
+		}
+		count += countFragment(comment.Replies)
+	}
+	return count
+}
+
+// threadOrigin returns the origin of the thread url, empty if url is not
+// absolute
+func threadOrigin(threadURL string) string {
+	u, err := url.Parse(threadURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// renderBase returns the scheme and host readers reach the server on, the
+// X-Forwarded-Proto header is only trusted from trusted proxies
+func (router *Router) renderBase(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	} else if ip := remoteIP(r); ip != nil && router.trustedProxy(ip) && r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// renderLink returns the absolute link to the rendered thread of threadURL
+// with the query parameters in pairs, skipping empty values
+func (router *Router) renderLink(r *http.Request, threadURL string, pairs ...string) string {
+	values := url.Values{"url": {threadURL}}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			values.Set(pairs[i], pairs[i+1])
+		}
+	}
+	return router.renderBase(r) + RenderPath + "?" + values.Encode()
+}
+
+// renderFragment renders the thread fragment with status
+func (router *Router) renderFragment(w http.ResponseWriter, page *fragmentPage, status int) {
+	body := &bytes.Buffer{}
+	if err := renderTemplate.Execute(body, page); err != nil {
+		log.Printf("Could not render thread %v: %v", page.URL, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Held comments are only shown to their poster, so the fragment depends
+	// on who asks for it
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	body.WriteTo(w)
+}
+
+// renderComments renders page with the comments of its thread for the query
+// parameters of the request, or an error if they are invalid
+func (router *Router) renderComments(w http.ResponseWriter, r *http.Request, page *fragmentPage, status int) {
+	query := r.URL.Query()
+	sort := query.Get("sort")
+
+	pageNumber := 1
+	if value := query.Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			router.renderFragment(w, &fragmentPage{Error: "Invalid page " + value + "."}, http.StatusBadRequest)
+			return
+		}
+		pageNumber = n
+	}
+
+	comments, err := router.commenter(r).GetComments(page.URL, sort)
+
+	if err == model.ErrInvalidSort {
+		router.renderFragment(w, &fragmentPage{Error: err.Error() + "."}, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		router.renderFragment(w, &fragmentPage{Error: "Could not get comments."}, http.StatusInternalServerError)
+		return
+	}
+
+	thread, httpErr := router.threadState(r, page.URL)
+	if httpErr != nil {
+		router.renderFragment(w, &fragmentPage{Error: httpErr.Description}, httpErr.StatusCode)
+		return
+	}
+
+	switch thread.EffectiveState(time.Now()) {
+	case model.ThreadClosed:
+		page.Closed = "Comments are closed."
+	case model.ThreadReadOnly:
+		page.Closed = "This thread is read-only."
+	}
+
+	comments = router.visibleComments(r, comments)
+
+	var replyLink func(*model.Comment) string
+	if page.Closed == "" {
+		replyLink = func(comment *model.Comment) string {
+			return router.renderLink(r, page.URL, "sort", sort, "page", query.Get("page"), "reply", strconv.FormatUint(uint64(*comment.ID), 10)) + "#gocomment-form"
+		}
+	}
+
+	topLevel := threadTree(comments, replyLink)
+	page.Count = countFragment(topLevel)
+
+	// Rejected replies keep replying to their parent if it is still shown
+	reply := query.Get("reply")
+	if r.Method == http.MethodPost {
+		reply = r.PostFormValue("parent")
+	}
+
+	if reply != "" && page.Closed == "" {
+		id, err := strconv.ParseUint(reply, 10, 32)
+		for _, comment := range comments {
+			if err == nil && *comment.ID == uint(id) && !comment.Deleted {
+				page.Reply = comment
+			}
+		}
+
+		if page.Reply != nil {
+			page.Cancel = router.renderLink(r, page.URL, "sort", sort, "page", query.Get("page")) + "#gocomment-form"
+		} else if r.Method != http.MethodPost {
+			router.renderFragment(w, &fragmentPage{Error: "Could not find comment with id " + reply + "."}, http.StatusNotFound)
+			return
+		}
+	}
+
+	page.Page = pageNumber
+	page.Pages = (len(topLevel) + renderPageSize - 1) / renderPageSize
+	if page.Pages == 0 {
+		page.Pages = 1
+	}
+	if pageNumber > page.Pages {
+		router.renderFragment(w, &fragmentPage{Error: "Invalid page " + query.Get("page") + "."}, http.StatusNotFound)
+		return
+	}
+
+	start := (pageNumber - 1) * renderPageSize
+	end := start + renderPageSize
+	if end > len(topLevel) {
+		end = len(topLevel)
+	}
+	page.Comments = topLevel[start:end]
+
+	if pageNumber > 1 {
+		page.Prev = router.renderLink(r, page.URL, "sort", sort, "page", strconv.Itoa(pageNumber-1))
+	}
+	if pageNumber < page.Pages {
+		page.Next = router.renderLink(r, page.URL, "sort", sort, "page", strconv.Itoa(pageNumber+1))
+	}
+
+	router.renderFragment(w, page, status)
+}
+
+// renderHandlerGet renders the comments of the thread of the url parameter
+// as an html fragment, for host sites to include server side or in an
+// iframe
+func (router *Router) renderHandlerGet(w http.ResponseWriter, r *http.Request) {
+	threadURL := r.URL.Query().Get("url")
+	if threadURL == "" {
+		router.renderFragment(w, &fragmentPage{Error: "Missing url of the thread."}, http.StatusBadRequest)
+		return
+	}
+
+	page := &fragmentPage{
+		URL:    threadURL,
+		Action: router.renderLink(r, threadURL),
+		Notice: renderNotices[r.URL.Query().Get("done")],
+	}
+	router.renderComments(w, r, page, http.StatusOK)
+}
+
+// renderHandlerPost posts a comment with the html form, and redirects back
+// to the page the form was shown on. Rejected comments render the thread
+// again with the errors and the values of the form.
+func (router *Router) renderHandlerPost(w http.ResponseWriter, r *http.Request) {
+	threadURL := r.URL.Query().Get("url")
+	if threadURL == "" {
+		router.renderFragment(w, &fragmentPage{Error: "Missing url of the thread."}, http.StatusBadRequest)
+		return
+	}
+
+	page := &fragmentPage{
+		URL:    threadURL,
+		Action: router.renderLink(r, threadURL),
+		Form: fragmentForm{
+			Username: r.PostFormValue("username"),
+			Email:    r.PostFormValue("email"),
+			Content:  r.PostFormValue("content"),
+		},
+	}
+
+	comment := &model.Comment{
+		URL:      threadURL,
+		Username: page.Form.Username,
+		Email:    page.Form.Email,
+		Content:  page.Form.Content,
+	}
+
+	if parent := r.PostFormValue("parent"); parent != "" {
+		id, err := strconv.ParseUint(parent, 10, 32)
+		if err != nil {
+			page.Error = "Invalid parent comment " + parent + "."
+			router.renderComments(w, r, page, http.StatusBadRequest)
+			return
+		}
+		comment.ParentID = uint(id)
+	}
+
+	if err := model.Validate(comment); err != nil {
+		httpErr := invalidFields(err)
+		page.Error = httpErr.Description
+		page.Errors = httpErr.Errors
+		router.renderComments(w, r, page, httpErr.StatusCode)
+		return
+	}
+
+	comment, httpErr := router.createComment(r, comment)
+
+	if httpErr != nil {
+		page.Error = httpErr.Description
+		page.Errors = httpErr.Errors
+		router.renderComments(w, r, page, httpErr.StatusCode)
+		return
+	}
+
+	done := renderPosted
+	// Shadow banned posters are told their comment was posted
+	if comment.Status == model.StatusPending {
+		done = renderHeld
+	}
+	http.Redirect(w, r, router.renderReturn(r, comment, done), http.StatusSeeOther)
+}
+
+// renderReturn returns where readers are sent after posting comment. Readers
+// return to the host page when the thread was included server side, and to
+// the rendered thread otherwise.
+func (router *Router) renderReturn(r *http.Request, comment *model.Comment, done string) string {
+	anchor := "#gocomment-" + strconv.FormatUint(uint64(*comment.ID), 10)
+
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host != "" && referer.Path != RenderPath &&
+		threadOrigin(referer.String()) == threadOrigin(comment.URL) {
+		referer.Fragment = ""
+		return referer.String() + anchor
+	}
+
+	return router.renderLink(r, comment.URL, "done", done) + anchor
+}
@@ -0,0 +1,329 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// mockRenderStore returns threads with replies, tombstones, held comments,
+// and enough top-level comments for several pages
+type mockRenderStore struct {
+	mockCommentStore
+}
+
+func (c mockRenderStore) GetComments(url string, sort string) ([]*model.Comment, error) {
+	if sort != "" && sort != model.SortOldest && sort != model.SortNewest && sort != model.SortTop {
+		return nil, model.ErrInvalidSort
+	}
+
+	createdAt := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+	comment := func(id uint, parentID uint, content string) *model.Comment {
+		return &model.Comment{ID: &id, ParentID: parentID, CreatedAt: &createdAt, Username: fmt.Sprintf("user-%d", id), Content: content, URL: url}
+	}
+
+	switch url {
+	case "http://example.com/posts/1", "http://example.com/posts/closed":
+		held := comment(5, 0, "Held comment")
+		held.Status = model.StatusPending
+		heldReply := comment(8, 7, "Reply held under deleted")
+		heldReply.Status = model.StatusPending
+		lonely := comment(7, 0, "")
+		lonely.Deleted = true
+		deleted := comment(3, 0, "")
+		deleted.Deleted = true
+		deleted.Username = ""
+		return []*model.Comment{
+			// Replies sorted before their parent are still nested
+			comment(2, 1, "Reply <em>to</em> first"),
+			comment(1, 0, "First paragraph\n\nSecond <script>alert(1)</script>"),
+			deleted,
+			comment(4, 3, "Reply to deleted"),
+			held,
+			comment(6, 5, "Reply to held"),
+			lonely,
+			heldReply,
+		}, nil
+	case "http://example.com/posts/long":
+		comments := []*model.Comment{}
+		for id := uint(1); id <= 45; id++ {
+			comments = append(comments, comment(id, 0, fmt.Sprintf("Comment number %d.", id)))
+		}
+		return comments, nil
+	}
+	return []*model.Comment{}, nil
+}
+
+func (c mockRenderStore) ForSite(siteID uint) model.CommentStore {
+	return c
+}
+
+func Test_server_renderHandlerGet(t *testing.T) {
+	router := &Router{
+		Commenter: mockRenderStore{},
+	}
+	muxRouter := router.Router()
+
+	link := "http://gocomment.example.com" + RenderPath + "?"
+	render := link + "url="
+	post := url.QueryEscape("http://example.com/posts/1")
+	long := url.QueryEscape("http://example.com/posts/long")
+
+	tests := []struct {
+		name       string
+		path       string
+		statusCode int
+		contains   []string
+		excludes   []string
+	}{
+		{
+			name:       "Render thread",
+			path:       RenderPath + "?url=" + post,
+			statusCode: http.StatusOK,
+			contains: []string{
+				"3 comments",
+				`<li class="gocomment-comment" id="gocomment-1">`,
+				"<p>First paragraph</p><p>Second &lt;script&gt;alert(1)&lt;/script&gt;</p>",
+				`<ol class="gocomment-replies">` + "\n    \n" + `<li class="gocomment-comment" id="gocomment-2">`,
+				"Reply &lt;em&gt;to&lt;/em&gt; first",
+				"This comment was deleted.",
+				"Reply to deleted",
+				`href="` + link + "reply=1&amp;url=" + post + `#gocomment-form"`,
+				`action="` + render + post + `"`,
+			},
+			excludes: []string{"<script>", "Held comment", "Reply to held", "reply=3", "gocomment-pages", `id="gocomment-7"`},
+		},
+		{
+			name:       "Render closed thread",
+			path:       RenderPath + "?url=" + url.QueryEscape("http://example.com/posts/closed"),
+			statusCode: http.StatusOK,
+			contains:   []string{"Comments are closed."},
+			excludes:   []string{"<form", "reply="},
+		},
+		{
+			name:       "Render empty thread",
+			path:       RenderPath + "?url=" + url.QueryEscape("http://example.com/posts/2"),
+			statusCode: http.StatusOK,
+			contains:   []string{"0 comments", "<form"},
+			excludes:   []string{"gocomment-comments"},
+		},
+		{
+			name:       "Render first page",
+			path:       RenderPath + "?url=" + long,
+			statusCode: http.StatusOK,
+			contains:   []string{"45 comments", "Comment number 20.", "Page 1 of 3", `href="` + link + "page=2&amp;url=" + long + `" rel="next"`},
+			excludes:   []string{"Comment number 21.", `rel="prev"`},
+		},
+		{
+			name:       "Render last page",
+			path:       RenderPath + "?url=" + long + "&page=3&sort=newest",
+			statusCode: http.StatusOK,
+			contains:   []string{"Comment number 41.", "Page 3 of 3", `href="` + link + "page=2&amp;sort=newest&amp;url=" + long + `" rel="prev"`},
+			excludes:   []string{"Comment number 40.", `rel="next"`},
+		},
+		{
+			name:       "Render reply form",
+			path:       RenderPath + "?url=" + post + "&reply=1",
+			statusCode: http.StatusOK,
+			contains:   []string{"Replying to user-1.", `<input type="hidden" name="parent" value="1">`},
+		},
+		{
+			name:       "Render notice after posting",
+			path:       RenderPath + "?url=" + post + "&done=held",
+			statusCode: http.StatusOK,
+			contains:   []string{"Your comment is awaiting moderation."},
+		},
+		{
+			name:       "Render reply form for deleted comment",
+			path:       RenderPath + "?url=" + post + "&reply=3",
+			statusCode: http.StatusNotFound,
+			contains:   []string{"Could not find comment with id 3."},
+		},
+		{
+			name:       "Render page after the last page",
+			path:       RenderPath + "?url=" + long + "&page=4",
+			statusCode: http.StatusNotFound,
+			contains:   []string{"Invalid page 4."},
+		},
+		{
+			name:       "Render invalid page",
+			path:       RenderPath + "?url=" + long + "&page=first",
+			statusCode: http.StatusBadRequest,
+			contains:   []string{"Invalid page first."},
+		},
+		{
+			name:       "Render invalid sort",
+			path:       RenderPath + "?url=" + post + "&sort=random",
+			statusCode: http.StatusBadRequest,
+			contains:   []string{"Sort must be oldest, newest or top."},
+		},
+		{
+			name:       "Render without url",
+			path:       RenderPath,
+			statusCode: http.StatusBadRequest,
+			contains:   []string{"Missing url of the thread."},
+			excludes:   []string{"<form"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "http://gocomment.example.com"+tt.path, nil)
+			recorder := httptest.NewRecorder()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Render returned status code %v, want %v", recorder.Code, tt.statusCode)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+				t.Errorf("Render returned Content-Type %q", contentType)
+			}
+
+			body := recorder.Body.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Render returned body without %q:\n%v", s, body)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("Render returned body with %q:\n%v", s, body)
+				}
+			}
+		})
+	}
+}
+
+func Test_server_renderHandlerPost(t *testing.T) {
+	link := "http://gocomment.example.com" + RenderPath + "?"
+	post := url.QueryEscape("http://example.com/posts/1")
+
+	tests := []struct {
+		name       string
+		sites      model.SiteStore
+		path       string
+		form       url.Values
+		headers    map[string]string
+		statusCode int
+		location   string
+		contains   []string
+	}{
+		{
+			name:       "Post comment",
+			path:       RenderPath + "?url=" + post,
+			form:       url.Values{"username": {"reader"}, "content": {"Some content"}},
+			statusCode: http.StatusSeeOther,
+			location:   link + "done=posted&url=" + post + "#gocomment-1",
+		},
+		{
+			name:       "Post reply from host page",
+			path:       RenderPath + "?url=" + post,
+			form:       url.Values{"content": {"Some content"}, "parent": {"1"}},
+			headers:    map[string]string{"Referer": "http://example.com/posts/1?page=2#comments"},
+			statusCode: http.StatusSeeOther,
+			location:   "http://example.com/posts/1?page=2#gocomment-1",
+		},
+		{
+			name:       "Post comment from another host page",
+			path:       RenderPath + "?url=" + post,
+			form:       url.Values{"content": {"Some content"}},
+			headers:    map[string]string{"Referer": "http://example.org/"},
+			statusCode: http.StatusSeeOther,
+			location:   link + "done=posted&url=" + post + "#gocomment-1",
+		},
+		{
+			name:       "Post comment to moderated thread",
+			path:       RenderPath + "?url=" + url.QueryEscape("http://example.com/posts/moderated"),
+			form:       url.Values{"content": {"Some content"}},
+			statusCode: http.StatusSeeOther,
+			location:   link + "done=held&url=" + url.QueryEscape("http://example.com/posts/moderated") + "#gocomment-1",
+		},
+		{
+			name: "Post comment to site of the thread",
+			sites: mockSiteStore{sites: []*model.Site{
+				{ID: 1, Name: "example", Origins: "http://example.com", Moderation: model.ModerationPre},
+			}},
+			path:       RenderPath + "?url=" + post,
+			form:       url.Values{"content": {"Some content"}},
+			headers:    map[string]string{"Origin": "http://gocomment.example.com"},
+			statusCode: http.StatusSeeOther,
+			location:   link + "done=held&url=" + post + "#gocomment-1",
+		},
+		{
+			name:       "Post empty comment",
+			path:       RenderPath + "?url=" + post,
+			form:       url.Values{"username": {`<b>reader</b>`}, "email": {"not an email"}},
+			statusCode: http.StatusBadRequest,
+			contains: []string{
+				"Comment contains illegal fields.",
+				`value="&lt;b&gt;reader&lt;/b&gt;"`,
+				`value="not an email"`,
+				"<form",
+			},
+		},
+		{
+			name:       "Post rejected reply",
+			path:       RenderPath + "?url=" + post,
+			form:       url.Values{"parent": {"1"}},
+			statusCode: http.StatusBadRequest,
+			contains:   []string{"Replying to user-1.", `name="parent" value="1"`},
+		},
+		{
+			name:       "Post comment to closed thread",
+			path:       RenderPath + "?url=" + url.QueryEscape("http://example.com/posts/closed"),
+			form:       url.Values{"content": {"Some content"}},
+			statusCode: http.StatusForbidden,
+			contains:   []string{"Thread is closed for new comments.", "Comments are closed."},
+		},
+		{
+			name:       "Post comment with invalid parent",
+			path:       RenderPath + "?url=" + post,
+			form:       url.Values{"content": {"Some content"}, "parent": {"first"}},
+			statusCode: http.StatusBadRequest,
+			contains:   []string{"Invalid parent comment first.", `>Some content</textarea>`},
+		},
+		{
+			name:       "Post comment without url",
+			path:       RenderPath,
+			form:       url.Values{"content": {"Some content"}},
+			statusCode: http.StatusBadRequest,
+			contains:   []string{"Missing url of the thread."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &Router{
+				Commenter: mockRenderStore{},
+				Sites:     tt.sites,
+			}
+			muxRouter := router.Router()
+
+			request, _ := http.NewRequest("POST", "http://gocomment.example.com"+tt.path, strings.NewReader(tt.form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Fatalf("Render returned status code %v, want %v:\n%v", recorder.Code, tt.statusCode, recorder.Body.String())
+			}
+			if location := recorder.Header().Get("Location"); location != tt.location {
+				t.Errorf("Render redirected to %q, want %q", location, tt.location)
+			}
+
+			body := recorder.Body.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Render returned body without %q:\n%v", s, body)
+				}
+			}
+		})
+	}
+}
@@ -3,10 +3,13 @@ package router
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"reflect"
 	"strconv"
+	"time"
 
+	"github.com/snorremd/gocomment/api/auth"
 	"github.com/snorremd/gocomment/api/model"
 
 	"github.com/gorilla/mux"
@@ -16,12 +19,95 @@ import (
 // Router contains commenter used to create, update, get, and delete comments
 type Router struct {
 	Commenter model.CommentStore
+
+	// Sites resolves the site of each request, every request belongs to the
+	// default site if nil
+	Sites model.SiteStore
+
+	// AdminKey authenticates moderators using admin routes as a bearer
+	// token, only site api keys are accepted if empty
+	AdminKey string
+
+	// Reactions lists the reaction kinds readers can use,
+	// model.DefaultReactions is used if empty
+	Reactions []string
+
+	// Users registers and logs in users, user accounts are disabled and
+	// usernames are not reserved if nil
+	Users model.UserStore
+
+	// Verifier sends email verification tokens to registered users
+	Verifier VerificationSender
+
+	// GDPR answers requests to export or erase the data of an email address,
+	// disabled if nil
+	GDPR model.GDPRStore
+
+	// DataRequests sends the tokens confirming data requests
+	DataRequests DataRequestSender
+
+	// Bans refuses comments and reactions of banned posters, disabled if
+	// nil
+	Bans model.BanStore
+
+	// Filters manages the word filters of sites and shows moderators the
+	// rules comments triggered, the filters are applied by the comment store
+	Filters model.FilterStore
+
+	// Audit records the actions of moderators, disabled if nil
+	Audit model.AuditStore
+
+	// Widget serves the compiled comment widget under EmbedPath, disabled if
+	// nil
+	Widget *Widget
+
+	// DashboardSecret signs the sessions of moderators logged in to the
+	// moderation dashboard served under DashboardPath, the dashboard is
+	// disabled if empty
+	DashboardSecret []byte
+
+	// OIDC logs users in with an OpenID Connect provider, disabled if nil
+	OIDC OIDCProvider
+
+	// SSO verifies single sign-on tokens of host sites, which override the
+	// author of posted comments, disabled if nil
+	SSO *auth.SSOVerifier
+
+	// SessionTTL controls how long users stay logged in, defaults to 30 days
+	SessionTTL time.Duration
+
+	// InsecureCookies allows session cookies over plain http, only meant
+	// for development
+	InsecureCookies bool
+
+	// CrossSiteCookies sends session cookies along with requests from
+	// widgets embedded on other sites, which also requires the cors policy
+	// to allow credentials. Ignored together with InsecureCookies, as
+	// browsers only accept cross site cookies over https. Writes sending the
+	// cookie from other sites must send json, see sessionMiddleware.
+	CrossSiteCookies bool
+
+	// CountCacheTTL controls how long comment counts are cached, defaults to
+	// one minute
+	CountCacheTTL time.Duration
+
+	// Clients controls how the ip address and user agent of clients posting
+	// comments are stored
+	Clients model.ClientPolicy
+
+	// TrustedProxies lists the networks of reverse proxies whose
+	// X-Forwarded-For header is trusted, the header is ignored if empty
+	TrustedProxies []*net.IPNet
+
+	counts *countCache
 }
 
 type httpResponse struct {
 	StatusCode  int    `json:"code"`
 	Message     string `json:"message"`
 	Description string `json:"description"`
+	// Errors lists the invalid fields of rejected comments
+	Errors []model.FieldError `json:"errors,omitempty"`
 }
 
 func jsonErrorResponse(w http.ResponseWriter, err *httpResponse) error {
@@ -66,13 +152,13 @@ func validateComment(r *http.Request) (*model.Comment, *httpResponse) {
 		return nil, &httpErr
 	}
 
+	// Comments are posted to the url of the request unless they say otherwise
+	if comment.URL == "" {
+		comment.URL = mux.Vars(r)["url"]
+	}
+
 	if err := model.Validate(&comment); err != nil {
-		httpErr := httpResponse{
-			Message:     http.StatusText(http.StatusBadRequest),
-			StatusCode:  http.StatusBadRequest,
-			Description: "Comment contains illegal fields.",
-		}
-		return nil, &httpErr
+		return nil, invalidFields(err)
 	}
 
 	return &comment, nil
@@ -104,19 +190,79 @@ func (router *Router) commentHandlerPost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	comment, err := router.Commenter.CreateComment(comment)
+	comment, httpErr = router.createComment(r, comment)
 
-	if err != nil {
-		httpErr := &httpResponse{
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if httpErr := router.withReactions(r, comment); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, comment, 200)
+}
+
+// createComment posts a validated comment as the author of the request,
+// applying the site, thread, and ban settings of its url
+func (router *Router) createComment(r *http.Request, comment *model.Comment) (*model.Comment, *httpResponse) {
+	if httpErr := router.validateSiteURL(r, comment); httpErr != nil {
+		return nil, httpErr
+	}
+
+	thread, httpErr := router.threadAcceptsComments(r, comment.URL)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	if httpErr := router.commentAuthor(r, comment, nil); httpErr != nil {
+		return nil, httpErr
+	}
+
+	// Comments on the default site are approved unless their thread is
+	// moderated, whatever status the client sent
+	comment.Status = model.StatusApproved
+	if site := siteFromRequest(r); site != nil {
+		comment.Status = site.DefaultStatus()
+	}
+
+	if thread.RequireModeration {
+		comment.Status = model.StatusPending
+	}
+
+	// Votes are counted from reactions, new comments have none
+	if router.moderator(r) == "" {
+		comment.Upvotes, comment.Downvotes = 0, 0
+	}
+
+	ban, httpErr := router.checkBan(r, comment.Email, comment.Username)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	comment.Shadowed = ban != nil
+
+	router.Clients.Record(comment, router.clientIP(r), r.UserAgent())
+
+	comment, err := router.commenter(r).CreateComment(comment)
+
+	if _, ok := err.(model.ValidationError); ok {
+		return nil, invalidFields(err)
+	} else if err != nil {
+		return nil, &httpResponse{
 			StatusCode:  http.StatusInternalServerError,
 			Message:     http.StatusText(http.StatusInternalServerError),
 			Description: "Failed to create comment.",
 		}
-		jsonErrorResponse(w, httpErr)
-		return
 	}
 
-	jsonResponse(w, comment, 200)
+	router.counts.invalidate(countCacheKey(siteID(r), comment.URL))
+
+	return comment, nil
 }
 
 func (router *Router) commentHandlerGet(w http.ResponseWriter, r *http.Request) {
@@ -127,7 +273,12 @@ func (router *Router) commentHandlerGet(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	comment, err := router.Commenter.GetComment(*id)
+	comment, err := router.commenter(r).GetComment(*id)
+
+	// Held and shadowed comments are only found by their poster and moderators
+	if err == nil && len(router.visibleComments(r, []*model.Comment{comment})) == 0 {
+		err = gorm.ErrRecordNotFound
+	}
 
 	if err != nil {
 		httpErr := httpResponse{
@@ -139,15 +290,32 @@ func (router *Router) commentHandlerGet(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if httpErr := router.withReactions(r, comment); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if notModified(w, r, commentETag(comment)) {
+		return
+	}
+
 	jsonResponse(w, comment, http.StatusOK)
 }
 
 func (router *Router) commentHandlerGetAll(w http.ResponseWriter, r *http.Request) {
 	url := mux.Vars(r)["url"]
 
-	comments, err := router.Commenter.GetComments(url)
+	comments, err := router.commenter(r).GetComments(url, r.URL.Query().Get("sort"))
 
-	if err != nil {
+	if err == model.ErrInvalidSort {
+		httpErr := httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, &httpErr)
+		return
+	} else if err != nil {
 		httpErr := httpResponse{
 			StatusCode:  http.StatusInternalServerError,
 			Message:     http.StatusText(http.StatusInternalServerError),
@@ -157,34 +325,76 @@ func (router *Router) commentHandlerGetAll(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	comments = router.visibleComments(r, comments)
+
+	if httpErr := router.withReactions(r, comments...); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if notModified(w, r, listETag(comments)) {
+		return
+	}
+
 	jsonResponse(w, comments, http.StatusOK)
 
 }
 
-func (router *Router) commentHandlerPut(w http.ResponseWriter, r *http.Request) {
-	id, httpErr := validateIDParam(r)
+// saveComment saves comment as the new version of existing, which is nil if
+// the comment could not be found
+func (router *Router) saveComment(w http.ResponseWriter, r *http.Request, comment *model.Comment, existing *model.Comment) {
+	version, httpErr := checkIfMatch(r, existing)
 
 	if httpErr != nil {
 		jsonErrorResponse(w, httpErr)
 		return
 	}
 
-	comment, httpErr := validateComment(r)
+	comment.Version = version
 
-	if httpErr != nil {
+	if httpErr := router.validateSiteURL(r, comment); httpErr != nil {
 		jsonErrorResponse(w, httpErr)
 		return
 	}
 
-	comment.ID = id
+	if existing != nil {
+		if httpErr := router.threadAcceptsEdits(r, existing.URL); httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		if httpErr := router.threadAcceptsMove(r, existing, comment.URL); httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		if httpErr := router.commentAuthor(r, comment, existing); httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		// Only moderators approve or hold comments and correct votes, anyone
+		// else keeps the status and votes the comment was given
+		if router.moderator(r) == "" {
+			comment.Status = existing.Status
+			comment.Upvotes, comment.Downvotes = existing.Upvotes, existing.Downvotes
+		}
+	}
 
-	comment, err := router.Commenter.UpdateComment(comment)
+	id := *comment.ID
+	comment, err := router.commenter(r).UpdateComment(comment)
 
-	if err != nil && err == gorm.ErrRecordNotFound {
+	if _, ok := err.(model.ValidationError); ok {
+		jsonErrorResponse(w, invalidFields(err))
+		return
+	} else if err == model.ErrVersionConflict {
+		jsonErrorResponse(w, preconditionFailed())
+		return
+	} else if err != nil && err == gorm.ErrRecordNotFound {
 		httpErr := httpResponse{
 			StatusCode:  http.StatusNotFound,
 			Message:     http.StatusText(http.StatusNotFound),
-			Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+			Description: fmt.Sprintf("Could not find comment with id %v.", id),
 		}
 		jsonErrorResponse(w, &httpErr)
 		return
@@ -198,8 +408,86 @@ func (router *Router) commentHandlerPut(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	router.counts.invalidate()
+
+	action := model.AuditEdit
+	if existing != nil && existing.Status != comment.Status {
+		action = model.AuditStatus
+	}
+	router.audit(r, action, model.AuditTargetComment, id, existing, comment)
+
+	if httpErr := router.withReactions(r, comment); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	w.Header().Set("ETag", commentETag(comment))
 	jsonResponse(w, comment, 200)
+}
+
+// commentHandlerPut replaces all editable fields of a comment, fields left
+// out of the payload are cleared. The status is only replaced by moderators.
+func (router *Router) commentHandlerPut(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	comment, httpErr := validateComment(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	comment.ID = id
+
+	// Comments that cannot be found are reported when saving
+	existing, err := router.commenter(r).GetComment(*id)
+	if err != nil {
+		existing = nil
+	}
+
+	router.saveComment(w, r, comment, existing)
+}
+
+// commentHandlerPatch applies a JSON Merge Patch to the editable fields of
+// a comment
+func (router *Router) commentHandlerPatch(w http.ResponseWriter, r *http.Request) {
+	id, httpErr := validateIDParam(r)
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	existing, err := router.commenter(r).GetComment(*id)
+
+	if err != nil {
+		httpErr := httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find comment with id %v.", *id),
+		}
+		jsonErrorResponse(w, &httpErr)
+		return
+	}
+
+	comment, httpErr := mergePatch(existing, r, router.moderator(r) != "")
+
+	if httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if err := model.ValidateFields(comment); err != nil {
+		jsonErrorResponse(w, invalidFields(err))
+		return
+	}
 
+	router.saveComment(w, r, comment, existing)
 }
 
 func (router *Router) commentHandlerDelete(w http.ResponseWriter, r *http.Request) {
@@ -214,9 +502,33 @@ func (router *Router) commentHandlerDelete(w http.ResponseWriter, r *http.Reques
 		ID: id,
 	}
 
-	comment, err := router.Commenter.DeleteComment(comment)
+	existing, err := router.commenter(r).GetComment(*id)
+	if err != nil {
+		existing = nil
+	} else if user, _, httpErr := router.readerUser(r); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if httpErr := router.commentOwner(r, user, existing); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if httpErr := router.threadAcceptsDelete(r, existing.URL); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if r.Header.Get("If-Match") != "" {
+		version, httpErr := checkIfMatch(r, existing)
+		if httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+		comment.Version = version
+	}
+
+	comment, err = router.commenter(r).DeleteComment(comment)
 
-	if err != nil && err == gorm.ErrRecordNotFound {
+	if err == model.ErrVersionConflict {
+		jsonErrorResponse(w, preconditionFailed())
+		return
+	} else if err != nil && err == gorm.ErrRecordNotFound {
 		httpErr := httpResponse{
 			StatusCode:  http.StatusNotFound,
 			Message:     http.StatusText(http.StatusNotFound),
@@ -234,6 +546,9 @@ func (router *Router) commentHandlerDelete(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	router.counts.invalidate()
+	router.audit(r, model.AuditDelete, model.AuditTargetComment, *id, existing, comment)
+
 	response := httpResponse{
 		StatusCode:  http.StatusOK,
 		Message:     http.StatusText(http.StatusOK),
@@ -245,11 +560,72 @@ func (router *Router) commentHandlerDelete(w http.ResponseWriter, r *http.Reques
 
 // Router returns new mux router for comment routes
 func (router *Router) Router() *mux.Router {
+	ttl := router.CountCacheTTL
+	if ttl == 0 {
+		ttl = defaultCountCacheTTL
+	}
+	router.counts = newCountCache(ttl)
+
 	muxRouter := mux.NewRouter()
+	muxRouter.Use(router.siteMiddleware)
+	muxRouter.Use(router.sessionMiddleware)
+	if len(router.DashboardSecret) > 0 {
+		router.dashboardRoutes(muxRouter)
+	}
+	if router.Widget != nil {
+		muxRouter.PathPrefix(EmbedPath).Handler(router.Widget).Methods("GET", "HEAD")
+	}
+	muxRouter.HandleFunc(RenderPath, router.renderHandlerGet).Methods("GET")
+	muxRouter.HandleFunc(RenderPath, router.renderHandlerPost).Methods("POST")
+	muxRouter.HandleFunc("/counts", router.countHandlerPost).Methods("POST")
+	muxRouter.HandleFunc("/counts", router.countHandlerGet).Methods("GET")
+	muxRouter.HandleFunc("/admin/threads", router.requireModerator(router.threadHandlerGet)).Methods("GET").Queries("url", "{url}")
+	muxRouter.HandleFunc("/admin/threads", router.requireModerator(router.threadHandlerPut)).Methods("PUT").Queries("url", "{url}")
+	if router.Users != nil {
+		muxRouter.HandleFunc("/users", router.userHandlerPost).Methods("POST")
+		muxRouter.HandleFunc("/users/verify", router.verifyHandler).Methods("GET")
+		muxRouter.HandleFunc("/session", router.sessionHandlerPost).Methods("POST")
+		muxRouter.HandleFunc("/session", router.sessionHandlerGet).Methods("GET")
+		muxRouter.HandleFunc("/session", router.sessionHandlerDelete).Methods("DELETE")
+		if router.OIDC != nil {
+			muxRouter.HandleFunc("/auth/oidc/login", router.oidcHandlerLogin).Methods("GET")
+			muxRouter.HandleFunc("/auth/oidc/callback", router.oidcHandlerCallback).Methods("GET")
+		}
+	}
+	if router.Bans != nil {
+		muxRouter.HandleFunc("/admin/bans", router.requireModerator(router.banHandlerGetAll)).Methods("GET")
+		muxRouter.HandleFunc("/admin/bans", router.requireModerator(router.banHandlerPost)).Methods("POST")
+		muxRouter.HandleFunc("/admin/bans/{id}", router.requireModerator(router.banHandlerDelete)).Methods("DELETE")
+	}
+	if router.Filters != nil {
+		muxRouter.HandleFunc("/admin/filters", router.requireModerator(router.filterHandlerGetAll)).Methods("GET")
+		muxRouter.HandleFunc("/admin/filters", router.requireModerator(router.filterHandlerPost)).Methods("POST")
+		muxRouter.HandleFunc("/admin/filters/{id}", router.requireModerator(router.filterHandlerDelete)).Methods("DELETE")
+		muxRouter.HandleFunc("/{id}/filters", router.requireModerator(router.filterHitsHandlerGet)).Methods("GET")
+	}
+	if router.Audit != nil {
+		muxRouter.HandleFunc("/admin/audit", router.requireModerator(router.auditHandlerGet)).Methods("GET")
+	}
+	if router.GDPR != nil {
+		muxRouter.HandleFunc("/gdpr/requests", router.dataRequestHandlerPost).Methods("POST")
+		muxRouter.HandleFunc("/gdpr/confirm", router.dataRequestHandlerConfirm).Methods("POST")
+	}
 	muxRouter.HandleFunc("/", router.commentHandlerPost).Methods("POST").Queries("url", "{url}")
 	muxRouter.HandleFunc("/", router.commentHandlerGetAll).Methods("GET").Queries("url", "{url}")
 	muxRouter.HandleFunc("/{id}", router.commentHandlerGet).Methods("GET")
 	muxRouter.HandleFunc("/{id}", router.commentHandlerPut).Methods("PUT")
+	muxRouter.HandleFunc("/{id}", router.commentHandlerPatch).Methods("PATCH")
+	muxRouter.HandleFunc("/{id}", router.requireModerator(router.commentHandlerPurge)).Methods("DELETE").Queries("purge", "true")
 	muxRouter.HandleFunc("/{id}", router.commentHandlerDelete).Methods("DELETE")
+	muxRouter.HandleFunc("/admin/flags", router.requireModerator(router.flagQueueHandler)).Methods("GET")
+	muxRouter.HandleFunc("/{id}/flag", router.flagHandler).Methods("POST")
+	muxRouter.HandleFunc("/{id}/flags", router.requireModerator(router.flagHandlerDelete)).Methods("DELETE")
+	muxRouter.HandleFunc("/{id}/client", router.requireModerator(router.clientHandlerGet)).Methods("GET")
+	muxRouter.HandleFunc("/{id}/pin", router.requireModerator(router.pinHandler(true))).Methods("PUT")
+	muxRouter.HandleFunc("/{id}/pin", router.requireModerator(router.pinHandler(false))).Methods("DELETE")
+	muxRouter.HandleFunc("/{id}/feature", router.requireModerator(router.featureHandler(true))).Methods("PUT")
+	muxRouter.HandleFunc("/{id}/feature", router.requireModerator(router.featureHandler(false))).Methods("DELETE")
+	muxRouter.HandleFunc("/{id}/reactions/{kind}", router.reactionHandler(true)).Methods("POST")
+	muxRouter.HandleFunc("/{id}/reactions/{kind}", router.reactionHandler(false)).Methods("DELETE")
 	return muxRouter
 }
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,7 +20,11 @@ import (
 
 type mockCommentStore struct{}
 
-func (c mockCommentStore) GetComments(url string) ([]*model.Comment, error) {
+func (c mockCommentStore) GetComments(url string, sort string) ([]*model.Comment, error) {
+
+	if sort != "" && sort != model.SortOldest && sort != model.SortNewest && sort != model.SortTop {
+		return nil, model.ErrInvalidSort
+	}
 
 	id1 := uint(1)
 	id2 := uint(2)
@@ -87,6 +93,7 @@ func (c mockCommentStore) GetComment(id uint) (*model.Comment, error) {
 			Upvotes:   0,
 			Downvotes: 0,
 			URL:       "http://example.com/posts/1",
+			Version:   3,
 		}, nil
 
 	}
@@ -98,6 +105,10 @@ func (c mockCommentStore) GetComment(id uint) (*model.Comment, error) {
 func (c mockCommentStore) CreateComment(comment *model.Comment) (*model.Comment, error) {
 	if comment.Content == "Error" {
 		return nil, errors.New("Failed to create comment")
+	} else if comment.ParentID > 1 {
+		return nil, model.ValidationError{{Field: "parentId", Code: model.CodeNotFound, Message: "Parent comment does not exist"}}
+	} else if comment.ParentID == 1 && comment.URL != "http://example.com/posts/1" {
+		return nil, model.ValidationError{{Field: "parentId", Code: model.CodeInvalid, Message: "Parent comment is on another url"}}
 	}
 	id := uint(1)
 	createdAt := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
@@ -111,15 +122,28 @@ func (c mockCommentStore) CreateComment(comment *model.Comment) (*model.Comment,
 }
 
 func (c mockCommentStore) UpdateComment(comment *model.Comment) (*model.Comment, error) {
-	if *comment.ID == uint(1) {
+	if *comment.ID == uint(1) && comment.Version != 0 && comment.Version != 3 {
+		return nil, model.ErrVersionConflict
+	} else if *comment.ID == uint(1) {
 		originalComment, err := c.GetComment(*comment.ID)
 		if err != nil {
 			return nil, err
+		} else if comment.ParentID != originalComment.ParentID {
+			return nil, model.ValidationError{{Field: "parentId", Code: model.CodeReadOnly, Message: "Replies cannot be moved to another parent"}}
 		}
 
 		updatedAt := time.Date(1970, time.January, 2, 0, 0, 0, 0, time.UTC)
 
 		originalComment.UpdatedAt = &updatedAt
+		originalComment.ParentID = comment.ParentID
+		originalComment.Username = comment.Username
+		originalComment.Email = comment.Email
+		originalComment.Content = comment.Content
+		originalComment.Upvotes = comment.Upvotes
+		originalComment.Downvotes = comment.Downvotes
+		originalComment.Status = comment.Status
+		originalComment.URL = comment.URL
+		originalComment.Version++
 
 		return originalComment, nil
 	}
@@ -129,7 +153,9 @@ func (c mockCommentStore) UpdateComment(comment *model.Comment) (*model.Comment,
 }
 
 func (c mockCommentStore) DeleteComment(comment *model.Comment) (*model.Comment, error) {
-	if *comment.ID == uint(1) {
+	if *comment.ID == uint(1) && comment.Version != 0 && comment.Version != 3 {
+		return nil, model.ErrVersionConflict
+	} else if *comment.ID == uint(1) {
 
 		someID := uint(1)
 		createdAt := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
@@ -153,12 +179,166 @@ func (c mockCommentStore) DeleteComment(comment *model.Comment) (*model.Comment,
 	return nil, gorm.ErrRecordNotFound
 }
 
+func (c mockCommentStore) PurgeComment(id uint) error {
+	if id != uint(1) {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (c mockCommentStore) GetClientInfo(id uint) (*model.ClientInfo, error) {
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &model.ClientInfo{
+		CommentID:    *comment.ID,
+		CreatedAt:    comment.CreatedAt,
+		ClientIP:     "192.0.2.0",
+		ClientIPHash: "hash",
+		UserAgent:    "Mozilla/5.0",
+	}, nil
+}
+
+func (c mockCommentStore) SearchComments(query model.CommentQuery) ([]*model.Comment, error) {
+	comment, _ := c.GetComment(1)
+	if query.Status != "" && query.Status != comment.Status {
+		return []*model.Comment{}, nil
+	} else if !strings.Contains(strings.ToLower(comment.Content), strings.ToLower(query.Text)) {
+		return []*model.Comment{}, nil
+	}
+	return []*model.Comment{comment}, nil
+}
+
+func (c mockCommentStore) FlagComment(id uint, reporter string, reason string) (*model.Comment, error) {
+	if reason != model.FlagSpam && reason != model.FlagAbuse && reason != model.FlagOffTopic && reason != model.FlagOther {
+		return nil, model.ErrInvalidFlagReason
+	}
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	comment.FlagCount = 1
+	return comment, nil
+}
+
+func (c mockCommentStore) GetFlaggedComments() ([]*model.Comment, error) {
+	comment, _ := c.GetComment(1)
+	comment.FlagCount = 2
+	comment.FlagReasons = map[string]int{model.FlagSpam: 2}
+	return []*model.Comment{comment}, nil
+}
+
+func (c mockCommentStore) ClearFlags(id uint) (*model.Comment, error) {
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	comment.Version++
+	return comment, nil
+}
+
+func (c mockCommentStore) PinComment(id uint, pinned bool) (*model.Comment, error) {
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	comment.Pinned = pinned
+	return comment, nil
+}
+
+func (c mockCommentStore) FeatureComment(id uint, featured bool) (*model.Comment, error) {
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	comment.Featured = featured
+	return comment, nil
+}
+
+func (c mockCommentStore) AddReaction(id uint, voter string, kind string) error {
+	if id != uint(1) {
+		return gorm.ErrRecordNotFound
+	} else if kind != strings.ToLower(kind) {
+		return errors.New("Reaction kind is not canonical")
+	}
+	return nil
+}
+
+func (c mockCommentStore) RemoveReaction(id uint, voter string, kind string) error {
+	return c.AddReaction(id, voter, kind)
+}
+
+// mockVoter identifies the reader reacting from 192.0.2.1
+var mockVoter = "ip:" + model.ClientPolicy{}.HashIP(net.ParseIP("192.0.2.1"))
+
+func (c mockCommentStore) LoadReactions(comments []*model.Comment, voter string) error {
+	for _, comment := range comments {
+		comment.Reactions = map[string]int{"like": 2}
+		comment.MyReactions = []string{}
+		if voter == mockVoter {
+			comment.MyReactions = []string{"like"}
+		}
+	}
+	return nil
+}
+
+func (c mockCommentStore) CountComments(urls []string) (map[string]int, error) {
+	counts := make(map[string]int, len(urls))
+	for _, url := range urls {
+		if url == "not-in-database" {
+			return nil, errors.New("some error")
+		} else if url == "http://example.com/posts/1" {
+			counts[url] = 3
+		} else {
+			counts[url] = 0
+		}
+	}
+	return counts, nil
+}
+
+func (c mockCommentStore) ForSite(siteID uint) model.CommentStore {
+	return c
+}
+
+func (c mockCommentStore) GetThread(url string) (*model.Thread, error) {
+	switch url {
+	case "http://example.com/posts/closed":
+		return &model.Thread{ID: 2, Key: url, State: model.ThreadClosed}, nil
+	case "http://example.com/posts/readonly":
+		return &model.Thread{ID: 3, Key: url, State: model.ThreadReadOnly}, nil
+	case "http://example.com/posts/moderated":
+		return &model.Thread{ID: 4, Key: url, State: model.ThreadOpen, RequireModeration: true}, nil
+	case "not-in-database":
+		return nil, errors.New("some error")
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (c mockCommentStore) GetOrCreateThread(url string) (*model.Thread, error) {
+	if thread, err := c.GetThread(url); err != gorm.ErrRecordNotFound {
+		return thread, err
+	}
+	return &model.Thread{ID: 1, Key: url, State: model.ThreadOpen}, nil
+}
+
+func (c mockCommentStore) UpdateThread(thread *model.Thread) (*model.Thread, error) {
+	if thread.State != model.ThreadOpen && thread.State != model.ThreadClosed && thread.State != model.ThreadReadOnly {
+		return nil, model.ErrInvalidThreadState
+	}
+	return thread, nil
+}
+
+func (c mockCommentStore) MergeThreads(thread *model.Thread, from ...*model.Thread) error {
+	return nil
+}
+
 func Test_validateComment(t *testing.T) {
 
 	comment := &model.Comment{
-		Content:  "",
+		Content:  "Some content",
 		ParentID: 1,
-		URL:      "Foo",
+		URL:      "http://example.com/posts/1",
 	}
 	payload, _ := json.Marshal(comment)
 	validRequest, _ := http.NewRequest("POST", "/?url=http://example.com", bytes.NewBuffer(payload))
@@ -171,6 +351,15 @@ func Test_validateComment(t *testing.T) {
 	illegalPayload, _ := json.Marshal(illegalPropsComment)
 	illegalPropsRequest, _ := http.NewRequest("POST", "/?url=http://example.com", bytes.NewBuffer(illegalPayload))
 
+	invalidFieldsComment := &model.Comment{
+		Username: "<script>",
+		Email:    "not an email",
+		Content:  strings.Repeat("a", model.MaxContentLength+1),
+		URL:      "/posts/1",
+	}
+	invalidPayload, _ := json.Marshal(invalidFieldsComment)
+	invalidFieldsRequest, _ := http.NewRequest("POST", "/?url=http://example.com", bytes.NewBuffer(invalidPayload))
+
 	badFormatPayload := bytes.NewBufferString("Not json")
 	badlyFormattedJSONRequest, _ := http.NewRequest("POST", "/?url=http://example.com", badFormatPayload)
 
@@ -193,6 +382,27 @@ func Test_validateComment(t *testing.T) {
 				StatusCode:  http.StatusBadRequest,
 				Message:     http.StatusText(http.StatusBadRequest),
 				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "id", Code: model.CodeReadOnly, Message: "ID is set by the server"},
+					{Field: "content", Code: model.CodeRequired, Message: "Content is required"},
+					{Field: "url", Code: model.CodeRequired, Message: "URL is required"},
+				},
+			},
+			comment: nil,
+		},
+		{
+			name: "Comment with invalid fields returns each field",
+			r:    invalidFieldsRequest,
+			httpError: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "content", Code: model.CodeTooLong, Message: "Content must be at most 10000 characters"},
+					{Field: "username", Code: model.CodeInvalid, Message: "Username may only contain letters, digits, spaces, dots, dashes, apostrophes or underscores"},
+					{Field: "email", Code: model.CodeInvalid, Message: "Email address is not valid"},
+					{Field: "url", Code: model.CodeInvalid, Message: "URL must be an absolute http or https url"},
+				},
 			},
 			comment: nil,
 		},
@@ -314,6 +524,7 @@ func Test_server_commentHandlerPost(t *testing.T) {
 		Content:   "Some content",
 		Upvotes:   0,
 		Downvotes: 0,
+		URL:       "http://example.com/posts/1",
 	})
 
 	if err != nil {
@@ -358,6 +569,43 @@ func Test_server_commentHandlerPost(t *testing.T) {
 				StatusCode:  http.StatusBadRequest,
 				Message:     http.StatusText(http.StatusBadRequest),
 				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "id", Code: model.CodeReadOnly, Message: "ID is set by the server"},
+				},
+			},
+		},
+		{
+			name: "Post reply to missing parent",
+			comment: &model.Comment{
+				ParentID: 2,
+				Content:  "Some content",
+			},
+			url:        "http://example.com/posts/1",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "parentId", Code: model.CodeNotFound, Message: "Parent comment does not exist"},
+				},
+			},
+		},
+		{
+			name: "Post reply to parent on another url",
+			comment: &model.Comment{
+				ParentID: 1,
+				Content:  "Some content",
+			},
+			url:        "http://example.com/posts/2",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "parentId", Code: model.CodeInvalid, Message: "Parent comment is on another url"},
+				},
 			},
 		},
 		{
@@ -509,7 +757,7 @@ func Test_server_commentHandlerGetAll(t *testing.T) {
 		Commenter: &mockCommentStore{},
 	}
 
-	comments, _ := router.Commenter.GetComments("http://example.com/posts/1")
+	comments, _ := router.Commenter.GetComments("http://example.com/posts/1", "")
 
 	tests := []struct {
 		name       string
@@ -535,6 +783,16 @@ func Test_server_commentHandlerGetAll(t *testing.T) {
 				Description: "Could not get comments for url not-in-database.",
 			},
 		},
+		{
+			name:       "Get comments with unknown sort order",
+			url:        "http://example.com/posts/1&sort=random",
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Sort must be oldest, newest or top.",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -575,6 +833,7 @@ func Test_server_commentHandlerGetAll(t *testing.T) {
 func Test_server_commentHandlerPut(t *testing.T) {
 	router := &Router{
 		Commenter: &mockCommentStore{},
+		AdminKey:  "admin-secret",
 	}
 
 	inputComment := &model.Comment{
@@ -601,10 +860,23 @@ func Test_server_commentHandlerPut(t *testing.T) {
 		t.Errorf("Could not update comment %v, got error %v", updatedComment, err)
 	}
 
+	approvedComment := *inputComment
+	approvedComment.Status = model.StatusApproved
+
+	approved := *updatedComment
+	approved.Status = model.StatusApproved
+
+	votedComment := *inputComment
+	votedComment.Upvotes = 100
+
+	voted := *updatedComment
+	voted.Upvotes = 100
+
 	tests := []struct {
 		name        string
 		id          uint
 		comment     *model.Comment
+		token       string
 		statusCode  int
 		commentBody *model.Comment
 		errorBody   *httpResponse
@@ -617,6 +889,36 @@ func Test_server_commentHandlerPut(t *testing.T) {
 			commentBody: updatedComment,
 			errorBody:   nil,
 		},
+		{
+			name:        "Put status without moderator credentials",
+			id:          uint(1),
+			comment:     &approvedComment,
+			statusCode:  200,
+			commentBody: updatedComment,
+		},
+		{
+			name:        "Put status as moderator",
+			id:          uint(1),
+			comment:     &approvedComment,
+			token:       "admin-secret",
+			statusCode:  200,
+			commentBody: &approved,
+		},
+		{
+			name:        "Put votes without moderator credentials",
+			id:          uint(1),
+			comment:     &votedComment,
+			statusCode:  200,
+			commentBody: updatedComment,
+		},
+		{
+			name:        "Put votes as moderator",
+			id:          uint(1),
+			comment:     &votedComment,
+			token:       "admin-secret",
+			statusCode:  200,
+			commentBody: &voted,
+		},
 		{
 			name:       "Put comment no in db",
 			id:         uint(1000),
@@ -634,6 +936,9 @@ func Test_server_commentHandlerPut(t *testing.T) {
 
 			payload, _ := json.Marshal(tt.comment)
 			request, _ := http.NewRequest("PUT", fmt.Sprintf("/%v", tt.id), bytes.NewBuffer(payload))
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
 			recorder := httptest.NewRecorder()
 			muxRouter := router.Router()
 			muxRouter.ServeHTTP(recorder, request)
@@ -652,6 +957,14 @@ func Test_server_commentHandlerPut(t *testing.T) {
 					t.Errorf("Expected ID %v, but was %v", tt.commentBody.ID, comment.ID)
 				}
 
+				if comment.Status != tt.commentBody.Status {
+					t.Errorf("Expected Status %q, but was %q", tt.commentBody.Status, comment.Status)
+				}
+
+				if comment.Upvotes != tt.commentBody.Upvotes {
+					t.Errorf("Expected Upvotes %v, but was %v", tt.commentBody.Upvotes, comment.Upvotes)
+				}
+
 				if !comment.UpdatedAt.After(*tt.commentBody.CreatedAt) {
 					t.Errorf("Expected UpdatedAt %v, but was %v", tt.commentBody.UpdatedAt, comment.UpdatedAt)
 				}
@@ -0,0 +1,113 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SiteKeyHeader is the request header carrying a site api key
+const SiteKeyHeader = "X-Site-Key"
+
+type contextKey int
+
+const (
+	siteContextKey contextKey = iota
+	// moderatorContextKey holds the moderator logged in to the dashboard
+	moderatorContextKey
+)
+
+// resolveSite finds the site of a request from its site key or origin. A nil
+// site means the request belongs to the default site. Rendered threads are
+// fetched by host servers and iframes without the origin of the host site,
+// so they belong to the site of the thread url.
+func (router *Router) resolveSite(r *http.Request) (*model.Site, *httpResponse) {
+	if key := r.Header.Get(SiteKeyHeader); key != "" {
+		site, err := router.Sites.GetSiteByAPIKey(key)
+		if err != nil {
+			return nil, &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Invalid site key.",
+			}
+		}
+		return site, nil
+	}
+
+	origin := r.Header.Get("Origin")
+	if r.URL.Path == RenderPath {
+		origin = threadOrigin(r.URL.Query().Get("url"))
+	}
+	if origin == "" {
+		return nil, nil
+	}
+
+	site, err := router.Sites.GetSiteByOrigin(origin)
+	if err == nil {
+		return site, nil
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not resolve site.",
+		}
+	}
+
+	// Origins only fall back to the default site while no sites are set up
+	if sites, err := router.Sites.GetSites(); err == nil && len(sites) == 0 {
+		return nil, nil
+	}
+
+	return nil, &httpResponse{
+		StatusCode:  http.StatusForbidden,
+		Message:     http.StatusText(http.StatusForbidden),
+		Description: "Origin is not allowed to use any site.",
+	}
+}
+
+// siteMiddleware resolves the site of each request and adds it to the
+// request context
+func (router *Router) siteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The dashboard resolves the site from the moderator session instead,
+		// and widget assets are shared by all sites
+		if router.Sites == nil || r.Method == http.MethodOptions ||
+			strings.HasPrefix(r.URL.Path, DashboardPath) || strings.HasPrefix(r.URL.Path, EmbedPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		site, httpErr := router.resolveSite(r)
+		if httpErr != nil {
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), siteContextKey, site)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// siteFromRequest returns the site resolved for the request, nil for the
+// default site
+func siteFromRequest(r *http.Request) *model.Site {
+	site, _ := r.Context().Value(siteContextKey).(*model.Site)
+	return site
+}
+
+// siteID returns the id of the site resolved for the request
+func siteID(r *http.Request) uint {
+	if site := siteFromRequest(r); site != nil {
+		return site.ID
+	}
+	return 0
+}
+
+// commenter returns the comment store scoped to the site of the request
+func (router *Router) commenter(r *http.Request) model.CommentStore {
+	return router.Commenter.ForSite(siteID(r))
+}
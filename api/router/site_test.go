@@ -0,0 +1,193 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+type mockSiteStore struct {
+	sites []*model.Site
+}
+
+func (s mockSiteStore) GetSites() ([]*model.Site, error) {
+	return s.sites, nil
+}
+
+func (s mockSiteStore) GetSite(name string) (*model.Site, error) {
+	for _, site := range s.sites {
+		if site.Name == name {
+			return site, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockSiteStore) GetSiteByOrigin(origin string) (*model.Site, error) {
+	for _, site := range s.sites {
+		if site.AllowsOrigin(origin) {
+			return site, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockSiteStore) GetSiteByAPIKey(key string) (*model.Site, error) {
+	for _, site := range s.sites {
+		if site.APIKey == key {
+			return site, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockSiteStore) CreateSite(site *model.Site) (*model.Site, error) {
+	return site, nil
+}
+
+func (s mockSiteStore) DeleteSite(site *model.Site) (*model.Site, error) {
+	return site, nil
+}
+
+func Test_server_siteMiddleware(t *testing.T) {
+	sites := mockSiteStore{
+		sites: []*model.Site{
+			{
+				ID:         1,
+				Name:       "example",
+				Origins:    "https://example.com",
+				Moderation: model.ModerationPre,
+				APIKey:     "secret",
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		sites      mockSiteStore
+		url        string
+		headers    map[string]string
+		statusCode int
+		status     string
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Request from allowed origin uses site moderation",
+			sites:      sites,
+			headers:    map[string]string{"Origin": "https://example.com"},
+			statusCode: http.StatusOK,
+			status:     model.StatusPending,
+		},
+		{
+			name:       "Request with site key uses site moderation",
+			sites:      sites,
+			headers:    map[string]string{SiteKeyHeader: "secret"},
+			statusCode: http.StatusOK,
+			status:     model.StatusPending,
+		},
+		{
+			name:       "Request without origin uses default site",
+			sites:      sites,
+			statusCode: http.StatusOK,
+			status:     model.StatusApproved,
+		},
+		{
+			name:       "Request from any origin uses default site without sites",
+			sites:      mockSiteStore{},
+			headers:    map[string]string{"Origin": "https://example.org"},
+			statusCode: http.StatusOK,
+			status:     model.StatusApproved,
+		},
+		{
+			name:       "Request posting to url of another site is rejected",
+			sites:      sites,
+			url:        "https://example.org/posts/1",
+			headers:    map[string]string{SiteKeyHeader: "secret"},
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Comment contains illegal fields.",
+				Errors: []model.FieldError{
+					{Field: "url", Code: model.CodeNotAllowed, Message: "URL does not belong to the site"},
+				},
+			},
+		},
+		{
+			name:       "Request from unknown origin is forbidden",
+			sites:      sites,
+			headers:    map[string]string{"Origin": "https://example.org"},
+			statusCode: http.StatusForbidden,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusForbidden,
+				Message:     http.StatusText(http.StatusForbidden),
+				Description: "Origin is not allowed to use any site.",
+			},
+		},
+		{
+			name:       "Request with invalid site key is unauthorized",
+			sites:      sites,
+			headers:    map[string]string{SiteKeyHeader: "wrong"},
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Invalid site key.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &Router{
+				Commenter: &mockCommentStore{},
+				Sites:     tt.sites,
+			}
+
+			url := "https://example.com/posts/1"
+			if tt.url != "" {
+				url = tt.url
+			}
+
+			payload, _ := json.Marshal(&model.Comment{Content: "Some content"})
+			request, _ := http.NewRequest("POST", "/?url="+url, bytes.NewBuffer(payload))
+			for header, value := range tt.headers {
+				request.Header.Set(header, value)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody == nil { // Expect regular body
+				comment := &model.Comment{}
+				if err := json.NewDecoder(recorder.Body).Decode(comment); err != nil {
+					t.Errorf("Could not decode comment body %v because of error %v", recorder.Body, err)
+				}
+
+				if comment.Status != tt.status {
+					t.Errorf("Expected status %v, but was %v", tt.status, comment.Status)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
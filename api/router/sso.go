@@ -0,0 +1,92 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/snorremd/gocomment/api/auth"
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// SSOTokenHeader is the request header carrying single sign-on tokens minted
+// by the host site
+const SSOTokenHeader = "X-SSO-Token"
+
+// ssoVerifier returns the verifier of single sign-on tokens for the site of
+// the request. Each site signs tokens with its own secret, so a site cannot
+// sign in readers of other sites. The configured secret is only used for the
+// default site. Sites without a secret accept tokens signed with the
+// configured RS256 keys instead, which are held by an identity provider
+// rather than by a site, and no tokens if there are none.
+func (router *Router) ssoVerifier(r *http.Request) *auth.SSOVerifier {
+	site := siteFromRequest(r)
+	if site == nil {
+		return router.SSO
+	}
+
+	verifier := &auth.SSOVerifier{Secret: []byte(site.SSOSecret)}
+	if router.SSO != nil {
+		verifier.Issuer = router.SSO.Issuer
+		verifier.Audience = router.SSO.Audience
+		verifier.MaxLifetime = router.SSO.MaxLifetime
+		if site.SSOSecret == "" {
+			verifier.Keys = router.SSO.Keys
+		}
+	}
+
+	if len(verifier.Secret) == 0 && len(verifier.Keys) == 0 {
+		return nil
+	}
+	return verifier
+}
+
+// ssoClaims verifies the single sign-on token of the request, returning nil
+// claims if there is none
+func (router *Router) ssoClaims(r *http.Request) (*auth.Claims, *httpResponse) {
+	token := r.Header.Get(SSOTokenHeader)
+	verifier := router.ssoVerifier(r)
+	if token == "" || verifier == nil {
+		return nil, nil
+	}
+
+	claims, err := verifier.Verify(token)
+	if err != nil {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusUnauthorized,
+			Message:     http.StatusText(http.StatusUnauthorized),
+			Description: "Single sign-on token is not valid.",
+		}
+	}
+	return claims, nil
+}
+
+// ssoUser links the reader of a single sign-on token to a user, so their
+// comments are verified. Nil is returned if user accounts are disabled.
+// Identities are kept apart per site, and are never linked to other users by
+// email address, as whoever holds the secret of a site can claim any address.
+func (router *Router) ssoUser(r *http.Request, claims *auth.Claims) (*model.User, *httpResponse) {
+	if router.Users == nil {
+		return nil, nil
+	}
+
+	provider := "sso"
+	if id := siteID(r); id != 0 {
+		provider += fmt.Sprintf(":site-%d", id)
+	}
+	if claims.Issuer != "" {
+		provider += ":" + claims.Issuer
+	}
+
+	user, err := router.Users.LinkIdentity(
+		&model.Identity{Provider: provider, Subject: claims.Subject},
+		&model.User{Username: profileUsername(claims)},
+	)
+	if err != nil {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to link identity.",
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,251 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/auth"
+	"github.com/snorremd/gocomment/api/model"
+)
+
+func ssoToken(secret string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func Test_server_commentHandlerPost_sso(t *testing.T) {
+	exp := time.Now().Add(5 * time.Minute).Unix()
+	claims := map[string]interface{}{
+		"sub":     "alice-id",
+		"exp":     exp,
+		"name":    "Alice Anderson",
+		"email":   "alice@example.com",
+		"picture": "https://example.com/alice.png",
+	}
+
+	siteWithSSO := mockSiteStore{sites: []*model.Site{
+		{ID: 1, Name: "example", Origins: "http://example.com", SSOSecret: "site-secret"},
+	}}
+
+	tests := []struct {
+		name       string
+		users      model.UserStore
+		sites      model.SiteStore
+		token      string
+		statusCode int
+		username   string
+		email      string
+		avatar     string
+		userID     uint
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Post comment with sso token",
+			token:      ssoToken("secret", claims),
+			statusCode: http.StatusOK,
+			username:   "Alice Anderson",
+			email:      "alice@example.com",
+			avatar:     "https://example.com/alice.png",
+		},
+		{
+			name:       "Post comment with sso token linked to user",
+			users:      mockUserStore{},
+			token:      ssoToken("secret", claims),
+			statusCode: http.StatusOK,
+			username:   "Alice Anderson",
+			email:      "alice@example.com",
+			avatar:     "https://example.com/alice.png",
+			userID:     7,
+		},
+		{
+			name:       "Post comment without sso token",
+			statusCode: http.StatusOK,
+			username:   "mallory",
+			email:      "mallory@example.com",
+		},
+		{
+			name:       "Post comment with forged sso token",
+			token:      ssoToken("guess", claims),
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Single sign-on token is not valid.",
+			},
+		},
+		{
+			name:       "Post comment with sso token of site",
+			sites:      siteWithSSO,
+			token:      ssoToken("site-secret", claims),
+			statusCode: http.StatusOK,
+			username:   "Alice Anderson",
+			email:      "alice@example.com",
+			avatar:     "https://example.com/alice.png",
+		},
+		{
+			name:       "Post comment to site with sso token of default site",
+			sites:      siteWithSSO,
+			token:      ssoToken("secret", claims),
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Single sign-on token is not valid.",
+			},
+		},
+		{
+			name: "Post comment to site without sso secret",
+			sites: mockSiteStore{sites: []*model.Site{
+				{ID: 1, Name: "example", Origins: "http://example.com"},
+			}},
+			token:      ssoToken("secret", claims),
+			statusCode: http.StatusOK,
+			username:   "mallory",
+			email:      "mallory@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			router := &Router{
+				Commenter: &mockCommentStore{},
+				Users:     tt.users,
+				Sites:     tt.sites,
+				SSO:       &auth.SSOVerifier{Secret: []byte("secret")},
+			}
+
+			url := "http://example.com/posts/1"
+			payload, _ := json.Marshal(&model.Comment{
+				Content:  "Some content",
+				URL:      url,
+				Username: "mallory",
+				Email:    "mallory@example.com",
+				Avatar:   "https://evil.example.com/tracker.png",
+			})
+			request, _ := http.NewRequest("POST", "/?url="+url, bytes.NewBuffer(payload))
+			if tt.token != "" {
+				request.Header.Set(SSOTokenHeader, tt.token)
+			}
+			if tt.sites != nil {
+				request.Header.Set("Origin", "http://example.com")
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody == nil { // Expect regular body
+				comment := &model.Comment{}
+				if err := json.NewDecoder(recorder.Body).Decode(comment); err != nil {
+					t.Errorf("Could not decode comment body %v because of error %v", recorder.Body, err)
+				}
+
+				if comment.Username != tt.username || comment.Email != tt.email || comment.Avatar != tt.avatar || comment.UserID != tt.userID {
+					t.Errorf("Expected comment by %v <%v> %v (%v), but was %+v", tt.username, tt.email, tt.avatar, tt.userID, comment)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
+
+// linkingUserStore records the identities and profiles it is asked to link
+type linkingUserStore struct {
+	mockUserStore
+	identities *[]*model.Identity
+	profiles   *[]*model.User
+}
+
+func (s linkingUserStore) LinkIdentity(identity *model.Identity, profile *model.User) (*model.User, error) {
+	*s.identities = append(*s.identities, identity)
+	*s.profiles = append(*s.profiles, profile)
+	return s.mockUserStore.LinkIdentity(identity, profile)
+}
+
+func Test_router_ssoUser(t *testing.T) {
+	identities, profiles := []*model.Identity{}, []*model.User{}
+	router := &Router{Users: linkingUserStore{identities: &identities, profiles: &profiles}}
+	claims := &auth.Claims{Issuer: "https://example.com", Subject: "alice-id", Email: "alice@example.com", EmailVerified: true}
+
+	request, _ := http.NewRequest("POST", "/", nil)
+	site := &model.Site{ID: 3, Name: "example"}
+	request = request.WithContext(context.WithValue(request.Context(), siteContextKey, site))
+
+	if _, httpErr := router.ssoUser(request, claims); httpErr != nil {
+		t.Fatalf("ssoUser() error = %v", httpErr)
+	}
+
+	if provider := identities[0].Provider; provider != "sso:site-3:https://example.com" {
+		t.Errorf("ssoUser() expected identities to be kept apart per site, got provider %v", provider)
+	}
+	if profile := profiles[0]; profile.Email != "" || profile.EmailVerified {
+		t.Errorf("ssoUser() expected identities to not be linked by email, got profile %+v", profile)
+	}
+}
+
+func Test_router_ssoVerifier(t *testing.T) {
+	keys := map[string]*rsa.PublicKey{"key-1": {}}
+
+	tests := []struct {
+		name   string
+		sso    *auth.SSOVerifier
+		site   *model.Site
+		secret string
+		keys   map[string]*rsa.PublicKey
+		none   bool
+	}{
+		{name: "Default site uses the configured verifier", sso: &auth.SSOVerifier{Secret: []byte("secret"), Keys: keys}, secret: "secret", keys: keys},
+		{name: "Site with secret uses its secret", sso: &auth.SSOVerifier{Secret: []byte("secret"), Keys: keys}, site: &model.Site{ID: 1, SSOSecret: "site-secret"}, secret: "site-secret"},
+		{name: "Site without secret uses the configured keys", sso: &auth.SSOVerifier{Secret: []byte("secret"), Keys: keys}, site: &model.Site{ID: 1}, keys: keys},
+		{name: "Site without secret or configured keys accepts no tokens", sso: &auth.SSOVerifier{Secret: []byte("secret")}, site: &model.Site{ID: 1}, none: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &Router{SSO: tt.sso}
+
+			request, _ := http.NewRequest("POST", "/", nil)
+			if tt.site != nil {
+				request = request.WithContext(context.WithValue(request.Context(), siteContextKey, tt.site))
+			}
+
+			verifier := router.ssoVerifier(request)
+			if tt.none {
+				if verifier != nil {
+					t.Errorf("ssoVerifier() = %+v, expected no verifier", verifier)
+				}
+				return
+			}
+
+			if verifier == nil || string(verifier.Secret) != tt.secret || !reflect.DeepEqual(verifier.Keys, tt.keys) {
+				t.Errorf("ssoVerifier() = %+v, expected secret %q and keys %v", verifier, tt.secret, tt.keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// Formats threads are rendered to for static sites
+const (
+	StaticHTML = "html"
+	StaticJSON = "json"
+)
+
+// StaticComment is an approved comment rendered for static sites, without
+// the email address and client of its poster
+type StaticComment struct {
+	ID        uint             `json:"id"`
+	ParentID  uint             `json:"parentId"`
+	Username  string           `json:"username"`
+	Avatar    string           `json:"avatar"`
+	Content   string           `json:"content"`
+	CreatedAt *time.Time       `json:"createdAt"`
+	UpdatedAt *time.Time       `json:"updatedAt"`
+	Upvotes   int              `json:"upvotes"`
+	Downvotes int              `json:"downvotes"`
+	Pinned    bool             `json:"pinned"`
+	Featured  bool             `json:"featured"`
+	Deleted   bool             `json:"deleted"`
+	Replies   []*StaticComment `json:"replies"`
+}
+
+// StaticThread is the tree of approved comments of a thread rendered for
+// static sites
+type StaticThread struct {
+	URL string `json:"url"`
+	// Count is the number of comments in the thread, tombstones excluded
+	Count    int              `json:"count"`
+	Comments []*StaticComment `json:"comments"`
+}
+
+// staticComments converts rendered comments and their replies
+func staticComments(comments []*fragmentComment) []*StaticComment {
+	static := make([]*StaticComment, 0, len(comments))
+	for _, comment := range comments {
+		static = append(static, &StaticComment{
+			ID:        *comment.ID,
+			ParentID:  comment.ParentID,
+			Username:  comment.Username,
+			Avatar:    comment.Avatar,
+			Content:   comment.Content,
+			CreatedAt: comment.CreatedAt,
+			UpdatedAt: comment.UpdatedAt,
+			Upvotes:   comment.Upvotes,
+			Downvotes: comment.Downvotes,
+			Pinned:    comment.Pinned,
+			Featured:  comment.Featured,
+			Deleted:   comment.Deleted,
+			Replies:   staticComments(comment.Replies),
+		})
+	}
+	return static
+}
+
+// WriteStaticThread writes the comments of the thread of url to w as an html
+// fragment or json document, threaded like rendered threads. Comments held
+// for moderation or posted under a shadow ban are left out, and the html
+// fragment has neither pages nor a form.
+func WriteStaticThread(w io.Writer, url string, comments []*model.Comment, format string) error {
+	approved := make([]*model.Comment, 0, len(comments))
+	for _, comment := range comments {
+		if comment.Status != model.StatusPending && !comment.Shadowed {
+			approved = append(approved, comment)
+		}
+	}
+
+	topLevel := threadTree(approved, nil)
+
+	switch format {
+	case StaticHTML:
+		return renderTemplate.Execute(w, &fragmentPage{
+			URL:      url,
+			Count:    countFragment(topLevel),
+			Comments: topLevel,
+			Page:     1,
+			Pages:    1,
+		})
+	case StaticJSON:
+		return json.NewEncoder(w).Encode(&StaticThread{
+			URL:      url,
+			Count:    countFragment(topLevel),
+			Comments: staticComments(topLevel),
+		})
+	}
+
+	return fmt.Errorf("Unknown format %v, must be html or json", format)
+}
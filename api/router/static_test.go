@@ -0,0 +1,77 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteStaticThread(t *testing.T) {
+	url := "http://example.com/posts/1"
+	comments, _ := mockRenderStore{}.GetComments(url, "")
+	comments[1].Email = "reader@example.com"
+	comments[0].Shadowed = true
+
+	tests := []struct {
+		name     string
+		format   string
+		wantErr  bool
+		contains []string
+		excludes []string
+	}{
+		{
+			name:   "Write html",
+			format: StaticHTML,
+			contains: []string{
+				"2 comments",
+				`<li class="gocomment-comment" id="gocomment-1">`,
+				"Second &lt;script&gt;alert(1)&lt;/script&gt;",
+				"This comment was deleted.",
+			},
+			excludes: []string{"<form", "gocomment-reply", "gocomment-pages", "Held comment", "Reply &lt;em&gt;to", "reader@example.com", `id="gocomment-7"`},
+		},
+		{
+			name:     "Write json",
+			format:   StaticJSON,
+			contains: []string{`"count":2`, `"url":"http://example.com/posts/1"`, `\u003cscript\u003e`},
+			excludes: []string{"Held comment", `Reply \u003cem\u003eto`, "<script>", "reader@example.com", `"email"`, `"id":7`},
+		},
+		{
+			name:    "Write unknown format",
+			format:  "xml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			if err := WriteStaticThread(w, url, comments, tt.format); (err != nil) != tt.wantErr {
+				t.Fatalf("WriteStaticThread() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for _, s := range tt.contains {
+				if !strings.Contains(w.String(), s) {
+					t.Errorf("WriteStaticThread() wrote %v without %q", w.String(), s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(w.String(), s) {
+					t.Errorf("WriteStaticThread() wrote %v with %q", w.String(), s)
+				}
+			}
+		})
+	}
+
+	// Replies are nested under their parent, or the tombstone of their parent
+	w := &bytes.Buffer{}
+	WriteStaticThread(w, url, comments, StaticJSON)
+	thread := StaticThread{}
+	if err := json.NewDecoder(w).Decode(&thread); err != nil {
+		t.Fatalf("WriteStaticThread() wrote invalid json: %v", err)
+	}
+	if len(thread.Comments) != 2 || thread.Comments[0].ID != 1 || len(thread.Comments[0].Replies) != 0 ||
+		!thread.Comments[1].Deleted || len(thread.Comments[1].Replies) != 1 || thread.Comments[1].Replies[0].ID != 4 {
+		t.Errorf("WriteStaticThread() wrote comments %+v, want comment 1 and reply 4 under tombstone 3", thread.Comments)
+	}
+}
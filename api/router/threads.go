@@ -0,0 +1,188 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// threadState returns the effective state of the thread url belongs to.
+// Urls without a thread are open.
+func (router *Router) threadState(r *http.Request, url string) (*model.Thread, *httpResponse) {
+	thread, err := router.commenter(r).GetThread(url)
+
+	if err == gorm.ErrRecordNotFound {
+		return &model.Thread{State: model.ThreadOpen}, nil
+	} else if err != nil {
+		return nil, &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not get thread settings.",
+		}
+	}
+
+	return thread, nil
+}
+
+// threadAcceptsComments checks that new comments can be posted to url
+func (router *Router) threadAcceptsComments(r *http.Request, url string) (*model.Thread, *httpResponse) {
+	thread, httpErr := router.threadState(r, url)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	switch thread.EffectiveState(time.Now()) {
+	case model.ThreadClosed:
+		return nil, &httpResponse{
+			StatusCode:  http.StatusForbidden,
+			Message:     http.StatusText(http.StatusForbidden),
+			Description: "Thread is closed for new comments.",
+		}
+	case model.ThreadReadOnly:
+		return nil, &httpResponse{
+			StatusCode:  http.StatusForbidden,
+			Message:     http.StatusText(http.StatusForbidden),
+			Description: "Thread is read-only.",
+		}
+	}
+
+	return thread, nil
+}
+
+// threadAcceptsEdits checks that comments posted to url can be edited
+func (router *Router) threadAcceptsEdits(r *http.Request, url string) *httpResponse {
+	thread, httpErr := router.threadState(r, url)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	if thread.EffectiveState(time.Now()) == model.ThreadReadOnly {
+		return &httpResponse{
+			StatusCode:  http.StatusForbidden,
+			Message:     http.StatusText(http.StatusForbidden),
+			Description: "Thread is read-only.",
+		}
+	}
+
+	return nil
+}
+
+// threadAcceptsMove checks that existing can be moved to url. Comments moving
+// to another thread are new to it, so only moderators move comments into
+// closed or read-only threads.
+func (router *Router) threadAcceptsMove(r *http.Request, existing *model.Comment, url string) *httpResponse {
+	if url == existing.URL || router.moderator(r) != "" {
+		return nil
+	}
+
+	thread, httpErr := router.threadState(r, url)
+	if httpErr != nil {
+		return httpErr
+	} else if thread.ID != 0 && thread.ID == existing.ThreadID {
+		return nil
+	}
+
+	_, httpErr = router.threadAcceptsComments(r, url)
+	return httpErr
+}
+
+// threadAcceptsDelete checks that comments posted to url can be deleted,
+// only moderators delete comments of read-only threads
+func (router *Router) threadAcceptsDelete(r *http.Request, url string) *httpResponse {
+	if router.moderator(r) != "" {
+		return nil
+	}
+	return router.threadAcceptsEdits(r, url)
+}
+
+func (router *Router) threadHandlerGet(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["url"]
+
+	thread, err := router.commenter(r).GetThread(url)
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: fmt.Sprintf("Could not find thread for url %v.", url),
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not get thread settings.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, thread, http.StatusOK)
+}
+
+func (router *Router) threadHandlerPut(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["url"]
+
+	settings := model.Thread{}
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode thread settings in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	thread, err := router.saveThreadSettings(r, url, &settings)
+
+	if err == model.ErrInvalidThreadState {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to update thread settings.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, thread, http.StatusOK)
+}
+
+// saveThreadSettings applies the state and moderation settings of settings
+// to the thread url belongs to, creating the thread if needed
+func (router *Router) saveThreadSettings(r *http.Request, url string, settings *model.Thread) (*model.Thread, error) {
+	commenter := router.commenter(r)
+
+	thread, err := commenter.GetOrCreateThread(url)
+	if err != nil {
+		return nil, err
+	}
+
+	before := *thread
+	thread.State = settings.State
+	thread.AutoCloseDays = settings.AutoCloseDays
+	thread.RequireModeration = settings.RequireModeration
+
+	if thread, err = commenter.UpdateThread(thread); err != nil {
+		return nil, err
+	}
+
+	router.audit(r, model.AuditThread, model.AuditTargetThread, thread.ID, before, thread)
+	return thread, nil
+}
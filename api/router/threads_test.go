@@ -0,0 +1,273 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+func Test_server_commentHandlerPost_threadSettings(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		statusCode int
+		status     string
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Post comment to open thread",
+			url:        "http://example.com/posts/1",
+			statusCode: http.StatusOK,
+			status:     model.StatusApproved,
+		},
+		{
+			name:       "Post comment to thread requiring moderation",
+			url:        "http://example.com/posts/moderated",
+			statusCode: http.StatusOK,
+			status:     model.StatusPending,
+		},
+		{
+			name:       "Post comment to closed thread",
+			url:        "http://example.com/posts/closed",
+			statusCode: http.StatusForbidden,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusForbidden,
+				Message:     http.StatusText(http.StatusForbidden),
+				Description: "Thread is closed for new comments.",
+			},
+		},
+		{
+			name:       "Post comment to read-only thread",
+			url:        "http://example.com/posts/readonly",
+			statusCode: http.StatusForbidden,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusForbidden,
+				Message:     http.StatusText(http.StatusForbidden),
+				Description: "Thread is read-only.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			payload, _ := json.Marshal(&model.Comment{Content: "Some content", URL: tt.url})
+			request, _ := http.NewRequest("POST", "/?url="+tt.url, bytes.NewBuffer(payload))
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody == nil { // Expect regular body
+				comment := &model.Comment{}
+				if err := json.NewDecoder(recorder.Body).Decode(comment); err != nil {
+					t.Errorf("Could not decode comment body %v because of error %v", recorder.Body, err)
+				}
+
+				if comment.Status != tt.status {
+					t.Errorf("Expected status %v, but was %v", tt.status, comment.Status)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
+
+// mockReadOnlyCommentStore returns comment 1 posted to a read-only thread
+type mockReadOnlyCommentStore struct {
+	mockCommentStore
+}
+
+func (c mockReadOnlyCommentStore) GetComment(id uint) (*model.Comment, error) {
+	comment, err := c.mockCommentStore.GetComment(id)
+	if err == nil {
+		comment.URL = "http://example.com/posts/readonly"
+		comment.ThreadID = 3
+	}
+	return comment, err
+}
+
+func (c mockReadOnlyCommentStore) ForSite(siteID uint) model.CommentStore {
+	return c
+}
+
+func Test_server_commentHandlers_threadSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		commenter  model.CommentStore
+		method     string
+		url        string
+		token      string
+		statusCode int
+	}{
+		{name: "Move comment to open thread", commenter: mockCommentStore{}, method: "PUT", url: "http://example.com/posts/2", statusCode: http.StatusOK},
+		{name: "Move comment to closed thread", commenter: mockCommentStore{}, method: "PUT", url: "http://example.com/posts/closed", statusCode: http.StatusForbidden},
+		{name: "Move comment to read-only thread", commenter: mockCommentStore{}, method: "PATCH", url: "http://example.com/posts/readonly", statusCode: http.StatusForbidden},
+		{name: "Move comment to closed thread as moderator", commenter: mockCommentStore{}, method: "PUT", url: "http://example.com/posts/closed", token: "admin-secret", statusCode: http.StatusOK},
+		{name: "Delete comment in read-only thread", commenter: mockReadOnlyCommentStore{}, method: "DELETE", statusCode: http.StatusForbidden},
+		{name: "Delete comment in read-only thread as moderator", commenter: mockReadOnlyCommentStore{}, method: "DELETE", token: "admin-secret", statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &Router{
+				Commenter: tt.commenter,
+				AdminKey:  "admin-secret",
+			}
+
+			payload, _ := json.Marshal(map[string]string{"content": "Edited content", "url": tt.url})
+			request, _ := http.NewRequest(tt.method, "/1", bytes.NewBuffer(payload))
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v: %v", tt.statusCode, recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
+
+func Test_server_threadHandlers(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		AdminKey:  "secret",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		token      string
+		body       string
+		statusCode int
+		thread     *model.Thread
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Get thread settings",
+			method:     "GET",
+			url:        "http://example.com/posts/closed",
+			token:      "secret",
+			statusCode: http.StatusOK,
+			thread:     &model.Thread{ID: 2, Key: "http://example.com/posts/closed", State: model.ThreadClosed},
+		},
+		{
+			name:       "Get thread settings without credentials",
+			method:     "GET",
+			url:        "http://example.com/posts/closed",
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+		{
+			name:       "Get settings of thread that does not exist",
+			method:     "GET",
+			url:        "http://example.com/posts/2",
+			token:      "secret",
+			statusCode: http.StatusNotFound,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Could not find thread for url http://example.com/posts/2.",
+			},
+		},
+		{
+			name:       "Close thread",
+			method:     "PUT",
+			url:        "http://example.com/posts/2",
+			token:      "secret",
+			body:       `{"state": "closed", "autoCloseDays": 30}`,
+			statusCode: http.StatusOK,
+			thread:     &model.Thread{ID: 1, Key: "http://example.com/posts/2", State: model.ThreadClosed, AutoCloseDays: 30},
+		},
+		{
+			name:       "Set unknown thread state",
+			method:     "PUT",
+			url:        "http://example.com/posts/2",
+			token:      "secret",
+			body:       `{"state": "locked"}`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Thread state must be open, closed or read-only.",
+			},
+		},
+		{
+			name:       "Set thread settings with wrong credentials",
+			method:     "PUT",
+			url:        "http://example.com/posts/2",
+			token:      "wrong",
+			body:       `{"state": "closed"}`,
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Moderator credentials are required.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, "/admin/threads?url="+tt.url, bytes.NewBufferString(tt.body))
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.thread != nil { // Expect regular body
+				thread := &model.Thread{}
+				if err := json.NewDecoder(recorder.Body).Decode(thread); err != nil {
+					t.Errorf("Could not decode thread body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(thread, tt.thread) {
+					t.Errorf("Expected thread %v, but was %v", tt.thread, thread)
+				}
+
+			} else if tt.errorBody != nil { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
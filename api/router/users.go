@@ -0,0 +1,404 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/snorremd/gocomment/api/auth"
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SessionCookie is the name of the cookie holding session tokens
+const SessionCookie = "gocomment_session"
+
+// defaultSessionTTL is used when Router.SessionTTL is not set
+const defaultSessionTTL = 30 * 24 * time.Hour
+
+// VerificationSender delivers email verification tokens to newly registered
+// users
+type VerificationSender interface {
+	SendVerification(user *model.User) error
+}
+
+type registration struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// sessionUser returns the user logged in with the session cookie of the
+// request, or nil if there is none
+func (router *Router) sessionUser(r *http.Request) *model.User {
+	if router.Users == nil {
+		return nil
+	}
+
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	user, err := router.Users.GetSessionUser(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// readerUser returns the user the reader is identified as by a single sign-on
+// token or their session, nil for guests, along with the verified claims of
+// the token
+func (router *Router) readerUser(r *http.Request) (*model.User, *auth.Claims, *httpResponse) {
+	claims, httpErr := router.ssoClaims(r)
+	if httpErr != nil {
+		return nil, nil, httpErr
+	} else if claims == nil {
+		return router.sessionUser(r), nil, nil
+	}
+
+	user, httpErr := router.ssoUser(r, claims)
+	return user, claims, httpErr
+}
+
+// commentOwner stops user from changing existing unless they posted it, or
+// both were posted without an account. Moderators may change any comment.
+func (router *Router) commentOwner(r *http.Request, user *model.User, existing *model.Comment) *httpResponse {
+	if router.moderator(r) != "" {
+		return nil
+	} else if existing.UserID == 0 && user == nil {
+		return nil
+	} else if user != nil && user.ID == existing.UserID {
+		return nil
+	}
+
+	return &httpResponse{
+		StatusCode:  http.StatusForbidden,
+		Message:     http.StatusText(http.StatusForbidden),
+		Description: "Comment can only be changed by the user who posted it.",
+	}
+}
+
+// commentAuthor ties comment to the reader identified by a single sign-on
+// token or to the logged in user, and stops anyone else from posting under a
+// registered username or editing comments posted by a registered user. Users
+// cannot edit comments posted without an account, which would make them the
+// poster. Moderators edit comments on behalf of their poster. Existing is the
+// comment being edited, nil for new comments.
+func (router *Router) commentAuthor(r *http.Request, comment *model.Comment, existing *model.Comment) *httpResponse {
+	comment.UserID = 0
+	comment.Avatar = ""
+
+	if existing != nil && router.moderator(r) != "" {
+		comment.UserID = existing.UserID
+		comment.Avatar = existing.Avatar
+		return nil
+	}
+
+	user, claims, httpErr := router.readerUser(r)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	if existing != nil {
+		if httpErr := router.commentOwner(r, user, existing); httpErr != nil {
+			return httpErr
+		}
+	}
+
+	if user != nil {
+		comment.UserID = user.ID
+		comment.Username = user.Username
+		comment.Email = user.Email
+	}
+
+	if claims != nil {
+		// The host site vouches for the reader, so its claims override
+		// anything the client sent
+		comment.Username = profileUsername(claims)
+		comment.Email = claims.Email
+		comment.Avatar = claims.Picture
+		return nil
+	} else if user != nil || router.Users == nil || comment.Username == "" {
+		return nil
+	}
+
+	if _, err := router.Users.GetUserByUsername(comment.Username); err == nil {
+		return &httpResponse{
+			StatusCode:  http.StatusForbidden,
+			Message:     http.StatusText(http.StatusForbidden),
+			Description: fmt.Sprintf("Username %v is reserved by a registered user.", comment.Username),
+		}
+	} else if err != gorm.ErrRecordNotFound {
+		return &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Could not check username.",
+		}
+	}
+
+	return nil
+}
+
+func (router *Router) userHandlerPost(w http.ResponseWriter, r *http.Request) {
+	body := registration{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode user in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	user, err := router.Users.CreateUser(&model.User{Username: body.Username, Email: body.Email}, body.Password)
+
+	switch err {
+	case nil:
+	case model.ErrInvalidUsername, model.ErrInvalidEmail, model.ErrInvalidPassword:
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	case model.ErrUsernameTaken, model.ErrEmailTaken:
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusConflict,
+			Message:     http.StatusText(http.StatusConflict),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	default:
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to create user.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if router.Verifier != nil {
+		if err := router.Verifier.SendVerification(user); err != nil {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Failed to send email verification.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+	}
+
+	jsonResponse(w, user, http.StatusCreated)
+}
+
+func (router *Router) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := router.Users.VerifyEmail(r.URL.Query().Get("token"))
+
+	if err == gorm.ErrRecordNotFound {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: "Verification token is invalid or already used.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to verify email address.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, user, http.StatusOK)
+}
+
+// setCookie sets a http only cookie, removing it if maxAge is negative
+func (router *Router) setCookie(w http.ResponseWriter, name string, value string, maxAge int) {
+	sameSite := http.SameSiteLaxMode
+	if router.CrossSiteCookies && !router.InsecureCookies {
+		sameSite = http.SameSiteNoneMode
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !router.InsecureCookies,
+		SameSite: sameSite,
+	})
+}
+
+// sessionMiddleware stops other sites from making readers post, edit or
+// delete comments with their session cookie. Writes sending the cookie must
+// send json, which browsers only allow cross origin if the cors policy does,
+// or come from the server itself or an origin of the site. The dashboard
+// checks its own csrf tokens.
+func (router *Router) sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := r.Cookie(SessionCookie)
+		switch {
+		case err != nil, strings.HasPrefix(r.URL.Path, DashboardPath):
+		case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+		case jsonContent(r), router.sameSite(r):
+		default:
+			jsonErrorResponse(w, &httpResponse{
+				StatusCode:  http.StatusForbidden,
+				Message:     http.StatusText(http.StatusForbidden),
+				Description: "Requests from other sites sending a session must send json.",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// jsonContent checks if the body of r is json
+func jsonContent(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// sameSite checks if r comes from the server itself or an origin of the site
+// of the request. Requests without origin do not come from browsers posting
+// for other sites.
+func (router *Router) sameSite(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	} else if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	site := siteFromRequest(r)
+	return site != nil && site.AllowsOrigin(origin)
+}
+
+func (router *Router) sessionHandlerPost(w http.ResponseWriter, r *http.Request) {
+	body := credentials{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusBadRequest,
+			Message:     http.StatusText(http.StatusBadRequest),
+			Description: "Could not decode credentials in payload.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	user, err := router.Users.Authenticate(body.Login, body.Password)
+
+	if err == model.ErrInvalidCredentials {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusUnauthorized,
+			Message:     http.StatusText(http.StatusUnauthorized),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err == model.ErrEmailNotVerified {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusForbidden,
+			Message:     http.StatusText(http.StatusForbidden),
+			Description: err.Error() + ".",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	} else if err != nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to log in.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	if httpErr := router.startSession(w, user); httpErr != nil {
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, user, http.StatusOK)
+}
+
+// startSession logs user in, setting the session cookie
+func (router *Router) startSession(w http.ResponseWriter, user *model.User) *httpResponse {
+	ttl := router.SessionTTL
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+
+	token, err := router.Users.CreateSession(user, ttl)
+	if err != nil {
+		return &httpResponse{
+			StatusCode:  http.StatusInternalServerError,
+			Message:     http.StatusText(http.StatusInternalServerError),
+			Description: "Failed to create session.",
+		}
+	}
+
+	router.setCookie(w, SessionCookie, token, int(ttl.Seconds()))
+	return nil
+}
+
+func (router *Router) sessionHandlerGet(w http.ResponseWriter, r *http.Request) {
+	user := router.sessionUser(r)
+
+	if user == nil {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusUnauthorized,
+			Message:     http.StatusText(http.StatusUnauthorized),
+			Description: "Not logged in.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	jsonResponse(w, user, http.StatusOK)
+}
+
+func (router *Router) sessionHandlerDelete(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		if err := router.Users.DeleteSession(cookie.Value); err != nil {
+			httpErr := &httpResponse{
+				StatusCode:  http.StatusInternalServerError,
+				Message:     http.StatusText(http.StatusInternalServerError),
+				Description: "Failed to log out.",
+			}
+			jsonErrorResponse(w, httpErr)
+			return
+		}
+	}
+
+	router.setCookie(w, SessionCookie, "", -1)
+
+	response := httpResponse{
+		StatusCode:  http.StatusOK,
+		Message:     http.StatusText(http.StatusOK),
+		Description: "Successfully logged out.",
+	}
+	jsonResponse(w, response, response.StatusCode)
+}
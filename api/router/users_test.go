@@ -0,0 +1,561 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snorremd/gocomment/api/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+type mockUserStore struct{}
+
+var mockUsers = []*model.User{
+	{ID: 7, Username: "alice", Email: "alice@example.com", EmailVerified: true},
+	{ID: 8, Username: "bob", Email: "bob@example.com"},
+}
+
+func (s mockUserStore) CreateUser(user *model.User, password string) (*model.User, error) {
+	if len(password) < 8 {
+		return nil, model.ErrInvalidPassword
+	} else if _, err := s.GetUserByUsername(user.Username); err == nil {
+		return nil, model.ErrUsernameTaken
+	}
+	user.ID = 9
+	user.VerifyToken = "token"
+	return user, nil
+}
+
+func (s mockUserStore) GetUser(id uint) (*model.User, error) {
+	for _, user := range mockUsers {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockUserStore) GetUserByUsername(username string) (*model.User, error) {
+	for _, user := range mockUsers {
+		if strings.EqualFold(user.Username, username) {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockUserStore) Authenticate(login string, password string) (*model.User, error) {
+	user, err := s.GetUserByUsername(login)
+	if err != nil || password != "correct horse" {
+		return nil, model.ErrInvalidCredentials
+	} else if !user.EmailVerified {
+		return nil, model.ErrEmailNotVerified
+	}
+	return user, nil
+}
+
+func (s mockUserStore) VerifyEmail(token string) (*model.User, error) {
+	if token != "token" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &model.User{ID: 9, Username: "carol", Email: "carol@example.com", EmailVerified: true}, nil
+}
+
+func (s mockUserStore) CreateSession(user *model.User, ttl time.Duration) (string, error) {
+	return user.Username + "-session", nil
+}
+
+func (s mockUserStore) GetSessionUser(token string) (*model.User, error) {
+	if token == "alice-session" {
+		return mockUsers[0], nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s mockUserStore) DeleteSession(token string) error {
+	return nil
+}
+
+func (s mockUserStore) LinkIdentity(identity *model.Identity, profile *model.User) (*model.User, error) {
+	if identity.Subject == "alice-id" {
+		return mockUsers[0], nil
+	}
+	return nil, errors.New("Failed to link identity")
+}
+
+type mockVerifier struct {
+	sent []string
+}
+
+func (v *mockVerifier) SendVerification(user *model.User) error {
+	v.sent = append(v.sent, user.Username)
+	return nil
+}
+
+func Test_server_userHandlers(t *testing.T) {
+	verifier := &mockVerifier{}
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Users:     mockUserStore{},
+		Verifier:  verifier,
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		session    string
+		body       string
+		statusCode int
+		user       *model.User
+		cookie     string
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Register user",
+			method:     "POST",
+			path:       "/users",
+			body:       `{"username": "carol", "email": "carol@example.com", "password": "correct horse"}`,
+			statusCode: http.StatusCreated,
+			user:       &model.User{ID: 9, Username: "carol", Email: "carol@example.com"},
+		},
+		{
+			name:       "Register user with taken username",
+			method:     "POST",
+			path:       "/users",
+			body:       `{"username": "Alice", "email": "carol@example.com", "password": "correct horse"}`,
+			statusCode: http.StatusConflict,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusConflict,
+				Message:     http.StatusText(http.StatusConflict),
+				Description: "Username is already registered.",
+			},
+		},
+		{
+			name:       "Register user with short password",
+			method:     "POST",
+			path:       "/users",
+			body:       `{"username": "carol", "email": "carol@example.com", "password": "short"}`,
+			statusCode: http.StatusBadRequest,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusBadRequest,
+				Message:     http.StatusText(http.StatusBadRequest),
+				Description: "Password must be 8 to 72 characters.",
+			},
+		},
+		{
+			name:       "Verify email address",
+			method:     "GET",
+			path:       "/users/verify?token=token",
+			statusCode: http.StatusOK,
+			user:       &model.User{ID: 9, Username: "carol", Email: "carol@example.com", EmailVerified: true},
+		},
+		{
+			name:       "Verify email address with invalid token",
+			method:     "GET",
+			path:       "/users/verify?token=wrong",
+			statusCode: http.StatusNotFound,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusNotFound,
+				Message:     http.StatusText(http.StatusNotFound),
+				Description: "Verification token is invalid or already used.",
+			},
+		},
+		{
+			name:       "Log in",
+			method:     "POST",
+			path:       "/session",
+			body:       `{"login": "alice", "password": "correct horse"}`,
+			statusCode: http.StatusOK,
+			user:       mockUsers[0],
+			cookie:     "alice-session",
+		},
+		{
+			name:       "Log in with wrong password",
+			method:     "POST",
+			path:       "/session",
+			body:       `{"login": "alice", "password": "wrong horse"}`,
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Username or password is incorrect.",
+			},
+		},
+		{
+			name:       "Log in before verifying email address",
+			method:     "POST",
+			path:       "/session",
+			body:       `{"login": "bob", "password": "correct horse"}`,
+			statusCode: http.StatusForbidden,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusForbidden,
+				Message:     http.StatusText(http.StatusForbidden),
+				Description: "Email address is not verified.",
+			},
+		},
+		{
+			name:       "Get logged in user",
+			method:     "GET",
+			path:       "/session",
+			session:    "alice-session",
+			statusCode: http.StatusOK,
+			user:       mockUsers[0],
+		},
+		{
+			name:       "Get logged in user without session",
+			method:     "GET",
+			path:       "/session",
+			session:    "expired-session",
+			statusCode: http.StatusUnauthorized,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusUnauthorized,
+				Message:     http.StatusText(http.StatusUnauthorized),
+				Description: "Not logged in.",
+			},
+		},
+		{
+			name:       "Log out",
+			method:     "DELETE",
+			path:       "/session",
+			session:    "alice-session",
+			statusCode: http.StatusOK,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusOK,
+				Message:     http.StatusText(http.StatusOK),
+				Description: "Successfully logged out.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if tt.session != "" {
+				request.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.session})
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.cookie != "" {
+				cookies := recorder.Result().Cookies()
+				if len(cookies) != 1 || cookies[0].Value != tt.cookie || !cookies[0].HttpOnly || !cookies[0].Secure {
+					t.Errorf("Expected secure session cookie %v, but got %v", tt.cookie, cookies)
+				}
+			}
+
+			if tt.user != nil { // Expect regular body
+				user := &model.User{}
+				if err := json.NewDecoder(recorder.Body).Decode(user); err != nil {
+					t.Errorf("Could not decode user body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(user, tt.user) {
+					t.Errorf("Expected user %v, but was %v", tt.user, user)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(verifier.sent, []string{"carol"}) {
+		t.Errorf("Expected verification to be sent to carol, but was sent to %v", verifier.sent)
+	}
+}
+
+func Test_server_commentHandlerPost_users(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Users:     mockUserStore{},
+	}
+
+	tests := []struct {
+		name       string
+		username   string
+		userID     uint
+		session    string
+		statusCode int
+		errorBody  *httpResponse
+	}{
+		{
+			name:       "Post comment as logged in user",
+			username:   "someone",
+			session:    "alice-session",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Post comment as guest",
+			username:   "carol",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Post comment as guest with reserved username",
+			username:   "Alice",
+			statusCode: http.StatusForbidden,
+			errorBody: &httpResponse{
+				StatusCode:  http.StatusForbidden,
+				Message:     http.StatusText(http.StatusForbidden),
+				Description: "Username Alice is reserved by a registered user.",
+			},
+		},
+		{
+			name:       "Post comment as guest claiming a user id",
+			username:   "carol",
+			userID:     7,
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			url := "http://example.com/posts/1"
+			payload, _ := json.Marshal(&model.Comment{Content: "Some content", URL: url, Username: tt.username, UserID: tt.userID})
+			request, _ := http.NewRequest("POST", "/?url="+url, bytes.NewBuffer(payload))
+			if tt.session != "" {
+				request.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.session})
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			if tt.errorBody == nil { // Expect regular body
+				comment := &model.Comment{}
+				if err := json.NewDecoder(recorder.Body).Decode(comment); err != nil {
+					t.Errorf("Could not decode comment body %v because of error %v", recorder.Body, err)
+				}
+
+				if tt.session != "" && (comment.UserID != 7 || comment.Username != "alice") {
+					t.Errorf("Expected comment by alice, but was by %v (%v)", comment.Username, comment.UserID)
+				} else if tt.session == "" && comment.UserID != 0 {
+					t.Errorf("Expected guest comment, but was by user %v", comment.UserID)
+				}
+
+			} else { // Expect error body
+				httpError := &httpResponse{}
+				if err := json.NewDecoder(recorder.Body).Decode(httpError); err != nil {
+					t.Errorf("Could not decode httpError body %v because of error %v", recorder.Body, err)
+				}
+
+				if !reflect.DeepEqual(httpError, tt.errorBody) {
+					t.Errorf("Expected json error to be %v, but got %v", tt.errorBody, httpError)
+				}
+			}
+		})
+	}
+}
+
+func Test_server_commentHandlerPut_users(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Users:     mockUserStore{},
+	}
+
+	tests := []struct {
+		name       string
+		session    string
+		statusCode int
+	}{
+		{
+			name:       "Edit guest comment as guest",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Edit guest comment as logged in user",
+			session:    "alice-session",
+			statusCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			payload, _ := json.Marshal(&model.Comment{Content: "Edited content", URL: "http://example.com/posts/1"})
+			request, _ := http.NewRequest("PUT", "/1", bytes.NewBuffer(payload))
+			if tt.session != "" {
+				request.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.session})
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Fatalf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+
+			comment := &model.Comment{}
+			json.NewDecoder(recorder.Body).Decode(comment)
+			if tt.statusCode == http.StatusOK && comment.UserID != 0 {
+				t.Errorf("Expected guest comment to stay a guest comment, but was by user %v", comment.UserID)
+			}
+		})
+	}
+}
+
+// mockUserCommentStore returns comment 1 posted by alice
+type mockUserCommentStore struct {
+	mockCommentStore
+}
+
+func (c mockUserCommentStore) GetComment(id uint) (*model.Comment, error) {
+	comment, err := c.mockCommentStore.GetComment(id)
+	if err == nil {
+		comment.UserID = mockUsers[0].ID
+		comment.Username = mockUsers[0].Username
+	}
+	return comment, err
+}
+
+func (c mockUserCommentStore) ForSite(siteID uint) model.CommentStore {
+	return c
+}
+
+func Test_server_commentHandlers_userComment(t *testing.T) {
+	router := &Router{
+		Commenter: mockUserCommentStore{},
+		Users:     mockUserStore{},
+		AdminKey:  "admin-secret",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		session    string
+		token      string
+		statusCode int
+	}{
+		{name: "Edit comment of user as guest", method: "PUT", statusCode: http.StatusForbidden},
+		{name: "Edit comment of user as the user", method: "PUT", session: "alice-session", statusCode: http.StatusOK},
+		{name: "Approve comment of user as moderator", method: "PUT", token: "admin-secret", statusCode: http.StatusOK},
+		{name: "Delete comment of user as guest", method: "DELETE", statusCode: http.StatusForbidden},
+		{name: "Delete comment of user as the user", method: "DELETE", session: "alice-session", statusCode: http.StatusOK},
+		{name: "Delete comment of user as moderator", method: "DELETE", token: "admin-secret", statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			payload, _ := json.Marshal(&model.Comment{Content: "Edited content", Username: "alice", URL: "http://example.com/posts/1", Status: model.StatusApproved})
+			request, _ := http.NewRequest(tt.method, "/1", bytes.NewBuffer(payload))
+			if tt.session != "" {
+				request.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.session})
+			}
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+		})
+	}
+}
+
+func Test_Router_sessionMiddleware(t *testing.T) {
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Users:     mockUserStore{},
+	}
+
+	tests := []struct {
+		name        string
+		session     string
+		contentType string
+		origin      string
+		statusCode  int
+	}{
+		{name: "Post json with session from other site", session: "alice-session", contentType: "application/json", origin: "https://example.org", statusCode: http.StatusOK},
+		{name: "Post form with session from other site", session: "alice-session", contentType: "text/plain", origin: "https://example.org", statusCode: http.StatusForbidden},
+		{name: "Post form with session from the server", session: "alice-session", contentType: "text/plain", origin: "https://comments.example.com", statusCode: http.StatusOK},
+		{name: "Post form with session without origin", session: "alice-session", contentType: "text/plain", statusCode: http.StatusOK},
+		{name: "Post form without session from other site", contentType: "text/plain", origin: "https://example.org", statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			url := "http://example.com/posts/1"
+			payload, _ := json.Marshal(&model.Comment{Content: "Some content", URL: url})
+			request, _ := http.NewRequest("POST", "/?url="+url, bytes.NewBuffer(payload))
+			request.Host = "comments.example.com"
+			request.Header.Set("Content-Type", tt.contentType)
+			if tt.origin != "" {
+				request.Header.Set("Origin", tt.origin)
+			}
+			if tt.session != "" {
+				request.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.session})
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter := router.Router()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Errorf("Expected handler to respond with code %v, but got %v", tt.statusCode, recorder.Code)
+			}
+		})
+	}
+}
+
+func Test_Router_setCookie(t *testing.T) {
+	tests := []struct {
+		name     string
+		router   *Router
+		secure   bool
+		sameSite http.SameSite
+	}{
+		{
+			name:     "Cookies are same site by default",
+			router:   &Router{},
+			secure:   true,
+			sameSite: http.SameSiteLaxMode,
+		},
+		{
+			name:     "Cross site cookies are sent from widgets on other sites",
+			router:   &Router{CrossSiteCookies: true},
+			secure:   true,
+			sameSite: http.SameSiteNoneMode,
+		},
+		{
+			name:     "Insecure cookies cannot be cross site",
+			router:   &Router{CrossSiteCookies: true, InsecureCookies: true},
+			secure:   false,
+			sameSite: http.SameSiteLaxMode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			tt.router.setCookie(recorder, SessionCookie, "alice-session", 60)
+
+			cookies := recorder.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Secure != tt.secure || cookies[0].SameSite != tt.sameSite {
+				t.Errorf("Expected cookie with secure %v and same site %v, but got %v", tt.secure, tt.sameSite, cookies)
+			}
+		})
+	}
+}
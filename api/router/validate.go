@@ -0,0 +1,38 @@
+package router
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/snorremd/gocomment/api/model"
+)
+
+// invalidFields is the response to comments failing validation, listing
+// each invalid field when err is a model.ValidationError
+func invalidFields(err error) *httpResponse {
+	httpErr := &httpResponse{
+		StatusCode:  http.StatusBadRequest,
+		Message:     http.StatusText(http.StatusBadRequest),
+		Description: "Comment contains illegal fields.",
+	}
+	if fieldErrs, ok := err.(model.ValidationError); ok {
+		httpErr.Errors = fieldErrs
+	}
+	return httpErr
+}
+
+// validateSiteURL checks that the url of comment belongs to the site of
+// the request. The store checks that the parent is on the same url.
+func (router *Router) validateSiteURL(r *http.Request, comment *model.Comment) *httpResponse {
+	site := siteFromRequest(r)
+	if site == nil || len(site.AllowedOrigins()) == 0 {
+		return nil
+	}
+
+	if u, err := url.Parse(comment.URL); err == nil && !site.AllowsOrigin(u.Scheme+"://"+u.Host) {
+		return invalidFields(model.ValidationError{
+			{Field: "url", Code: model.CodeNotAllowed, Message: "URL does not belong to the site"},
+		})
+	}
+	return nil
+}
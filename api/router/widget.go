@@ -0,0 +1,267 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// EmbedPath is the path the comment widget is served under
+const EmbedPath = "/embed/"
+
+// LoaderName is the name of the script host pages include to mount the
+// widget, served under EmbedPath
+const LoaderName = "embed.js"
+
+// The compiled client application and its stylesheets in the public
+// directory
+const (
+	widgetScript = "js/compiled/app.js"
+	widgetStyles = "css/*.css"
+)
+
+// Fingerprinted assets never change, while the loader changes with every
+// build of the widget
+const (
+	assetCacheControl  = "public, max-age=31536000, immutable"
+	loaderCacheControl = "public, max-age=300"
+)
+
+// ErrWidgetNotBuilt is returned when the public directory lacks the compiled
+// client
+var ErrWidgetNotBuilt = errors.New("Widget is not compiled, build it with lein build before building gocomment")
+
+//go:embed widget/embed.js
+var loaderSource string
+
+var loaderTemplate = template.Must(template.New(LoaderName).Parse(loaderSource))
+
+// widgetAsset is a file of the widget with its content by content coding,
+// the empty coding holding the uncompressed content
+type widgetAsset struct {
+	contentType  string
+	cacheControl string
+	hash         string
+	encodings    map[string][]byte
+}
+
+// Widget serves the compiled comment widget under EmbedPath. Assets are
+// served under fingerprinted names with long lived caching, compressed with
+// brotli or gzip for clients accepting it, and the loader script mounts the
+// widget on host pages.
+type Widget struct {
+	assets map[string]*widgetAsset
+}
+
+// NewWidget collects the widget assets of the public directory fsys and
+// compresses them ahead of serving. Brotli and gzip compressed copies of an
+// asset with the .br and .gz extensions are served when present, and must be
+// recreated with every build of the client. Gzip compressed copies are
+// created otherwise.
+func NewWidget(fsys fs.FS) (*Widget, error) {
+	if _, err := fs.Stat(fsys, widgetScript); errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrWidgetNotBuilt
+	} else if err != nil {
+		return nil, err
+	}
+
+	styles, err := fs.Glob(fsys, widgetStyles)
+	if err != nil {
+		return nil, err
+	}
+
+	widget := &Widget{assets: map[string]*widgetAsset{}}
+
+	script, err := widget.addFile(fsys, widgetScript)
+	if err != nil {
+		return nil, err
+	}
+
+	styleNames := []string{}
+	for _, style := range styles {
+		name, err := widget.addFile(fsys, style)
+		if err != nil {
+			return nil, err
+		}
+		styleNames = append(styleNames, name)
+	}
+
+	loader, err := renderLoader(script, styleNames)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := newWidgetAsset(LoaderName, loader, loaderCacheControl)
+	if err != nil {
+		return nil, err
+	}
+	widget.assets[LoaderName] = asset
+
+	return widget, nil
+}
+
+// renderLoader renders the loader script for the fingerprinted names of the
+// client script and stylesheets
+func renderLoader(script string, styles []string) ([]byte, error) {
+	data := map[string]interface{}{"EmbedPath": EmbedPath, "Script": script, "Styles": styles}
+	for key, value := range data {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = string(encoded)
+	}
+
+	loader := &bytes.Buffer{}
+	if err := loaderTemplate.Execute(loader, data); err != nil {
+		return nil, err
+	}
+	return loader.Bytes(), nil
+}
+
+// addFile adds file of fsys to the widget, returning its fingerprinted name
+func (widget *Widget) addFile(fsys fs.FS, file string) (string, error) {
+	content, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return "", err
+	}
+
+	ext := path.Ext(file)
+	asset, err := newWidgetAsset(file, content, assetCacheControl)
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(path.Base(file), ext) + "." + asset.hash + ext
+
+	if _, ok := widget.assets[name]; ok {
+		return "", fmt.Errorf("Widget asset %v has the same name as another asset", file)
+	}
+
+	if compressed, err := fs.ReadFile(fsys, file+".br"); err == nil {
+		asset.encodings["br"] = compressed
+	}
+	if compressed, err := fs.ReadFile(fsys, file+".gz"); err == nil && gunzips(compressed, content) {
+		asset.encodings["gzip"] = compressed
+	}
+
+	widget.assets[name] = asset
+	return name, nil
+}
+
+// newWidgetAsset fingerprints and gzip compresses content of file
+func newWidgetAsset(file string, content []byte, cacheControl string) (*widgetAsset, error) {
+	sum := sha256.Sum256(content)
+
+	compressed := &bytes.Buffer{}
+	writer, _ := gzip.NewWriterLevel(compressed, gzip.BestCompression)
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	} else if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(file))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &widgetAsset{
+		contentType:  contentType,
+		cacheControl: cacheControl,
+		hash:         hex.EncodeToString(sum[:])[:16],
+		encodings:    map[string][]byte{"": content, "gzip": compressed.Bytes()},
+	}, nil
+}
+
+// gunzips reports if compressed decompresses to content, to skip stale
+// compressed copies
+func gunzips(compressed []byte, content []byte) bool {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return false
+	}
+
+	decompressed, err := io.ReadAll(reader)
+	return err == nil && bytes.Equal(decompressed, content)
+}
+
+// acceptsEncoding reports if the value of an Accept-Encoding header accepts
+// the content coding
+func acceptsEncoding(header string, coding string) bool {
+	for _, value := range strings.Split(header, ",") {
+		params := strings.Split(value, ";")
+		if name := strings.TrimSpace(params[0]); !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ServeHTTP serves the widget asset named by the path of the request
+func (widget *Widget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	asset, ok := widget.assets[strings.TrimPrefix(r.URL.Path, EmbedPath)]
+	if !ok {
+		httpErr := &httpResponse{
+			StatusCode:  http.StatusNotFound,
+			Message:     http.StatusText(http.StatusNotFound),
+			Description: "Could not find widget asset.",
+		}
+		jsonErrorResponse(w, httpErr)
+		return
+	}
+
+	coding := ""
+	for _, c := range []string{"br", "gzip"} {
+		if asset.encodings[c] != nil && acceptsEncoding(r.Header.Get("Accept-Encoding"), c) {
+			coding = c
+			break
+		}
+	}
+
+	etag := `"` + asset.hash + `"`
+	if coding != "" {
+		etag = `"` + asset.hash + "-" + coding + `"`
+		w.Header().Set("Content-Encoding", coding)
+	}
+
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("Cache-Control", asset.cacheControl)
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if header := r.Header.Get("If-None-Match"); header != "" && matchesETag(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	content := asset.encodings[coding]
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(content)
+	}
+}
@@ -0,0 +1,48 @@
+// Mounts the gocomment widget on a host page. Include the loader where the
+// comments should appear:
+//
+//   <div id="gocomment"></div>
+//   <script src="https://comments.example.com/embed/embed.js" async></script>
+//
+// The data-element, data-url and data-api attributes of the script tag
+// override the id of the element to mount the widget on, the url of the
+// thread, and the url of the gocomment api.
+(function () {
+  var script = document.currentScript;
+  if (!script) {
+    return;
+  }
+
+  function canonicalURL() {
+    var link = document.querySelector("link[rel=canonical]");
+    return link ? link.href : location.href.split("#")[0];
+  }
+
+  var base = script.src.replace(/[^\/]*$/, "");
+  var options = {
+    api: script.getAttribute("data-api") || base.slice(0, base.length - {{.EmbedPath}}.length),
+    url: script.getAttribute("data-url") || canonicalURL(),
+    element: script.getAttribute("data-element") || "gocomment"
+  };
+
+  if (!document.getElementById(options.element)) {
+    var element = document.createElement("div");
+    element.id = options.element;
+    script.parentNode.insertBefore(element, script);
+  }
+
+  {{.Styles}}.forEach(function (name) {
+    var link = document.createElement("link");
+    link.rel = "stylesheet";
+    link.href = base + name;
+    document.head.appendChild(link);
+  });
+
+  var app = document.createElement("script");
+  app.src = base + {{.Script}};
+  app.async = true;
+  app.onload = function () {
+    gocomment.core.init(options);
+  };
+  document.head.appendChild(app);
+})();
@@ -0,0 +1,235 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func gzipped(content string) []byte {
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write([]byte(content))
+	writer.Close()
+	return compressed.Bytes()
+}
+
+func fingerprint(name string, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	ext := name[strings.LastIndex(name, "."):]
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:16] + ext
+}
+
+func Test_NewWidget(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		err  error
+	}{
+		{
+			name: "Compiled widget",
+			fsys: fstest.MapFS{
+				"index.html":         {Data: []byte("<html></html>")},
+				"js/compiled/app.js": {Data: []byte("app")},
+			},
+		},
+		{
+			name: "Widget that is not compiled",
+			fsys: fstest.MapFS{
+				"index.html":        {Data: []byte("<html></html>")},
+				"css/gocomment.css": {Data: []byte("css")},
+			},
+			err: ErrWidgetNotBuilt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			widget, err := NewWidget(tt.fsys)
+			if err != tt.err {
+				t.Fatalf("NewWidget() error = %v, want %v", err, tt.err)
+			} else if err == nil && widget.assets[LoaderName] == nil {
+				t.Errorf("NewWidget() has no loader")
+			}
+		})
+	}
+}
+
+func Test_server_widget(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":            {Data: []byte("<html></html>")},
+		"js/compiled/app.js":    {Data: []byte("app")},
+		"js/compiled/app.js.br": {Data: []byte("brotli app")},
+		"css/gocomment.css":     {Data: []byte("comments")},
+		"css/gocomment.css.gz":  {Data: gzipped("stale comments")},
+		"css/pacman.min.css":    {Data: []byte("pacman")},
+		"css/pacman.min.css.gz": {Data: gzipped("pacman")},
+	}
+	widget, err := NewWidget(fsys)
+	if err != nil {
+		t.Fatalf("NewWidget() error = %v", err)
+	}
+
+	router := &Router{
+		Commenter: &mockCommentStore{},
+		Widget:    widget,
+	}
+	muxRouter := router.Router()
+
+	script := fingerprint("app.js", "app")
+	style := fingerprint("gocomment.css", "comments")
+	pacman := fingerprint("pacman.min.css", "pacman")
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		headers      map[string]string
+		statusCode   int
+		encoding     string
+		cacheControl string
+		body         []byte
+	}{
+		{
+			name:         "Get loader",
+			method:       "GET",
+			path:         EmbedPath + LoaderName,
+			statusCode:   http.StatusOK,
+			cacheControl: loaderCacheControl,
+		},
+		{
+			name:         "Get script",
+			method:       "GET",
+			path:         EmbedPath + script,
+			statusCode:   http.StatusOK,
+			cacheControl: assetCacheControl,
+			body:         []byte("app"),
+		},
+		{
+			name:         "Get script with brotli",
+			method:       "GET",
+			path:         EmbedPath + script,
+			headers:      map[string]string{"Accept-Encoding": "gzip, deflate, br"},
+			statusCode:   http.StatusOK,
+			encoding:     "br",
+			cacheControl: assetCacheControl,
+			body:         []byte("brotli app"),
+		},
+		{
+			name:         "Get script refusing brotli",
+			method:       "GET",
+			path:         EmbedPath + script,
+			headers:      map[string]string{"Accept-Encoding": "gzip, br;q=0"},
+			statusCode:   http.StatusOK,
+			encoding:     "gzip",
+			cacheControl: assetCacheControl,
+			body:         []byte("app"),
+		},
+		{
+			name:         "Get stylesheet with stale gzip copy",
+			method:       "GET",
+			path:         EmbedPath + style,
+			headers:      map[string]string{"Accept-Encoding": "gzip"},
+			statusCode:   http.StatusOK,
+			encoding:     "gzip",
+			cacheControl: assetCacheControl,
+			body:         []byte("comments"),
+		},
+		{
+			name:         "Get stylesheet with gzip copy",
+			method:       "GET",
+			path:         EmbedPath + pacman,
+			headers:      map[string]string{"Accept-Encoding": "*"},
+			statusCode:   http.StatusOK,
+			encoding:     "gzip",
+			cacheControl: assetCacheControl,
+			body:         []byte("pacman"),
+		},
+		{
+			name:         "Head script",
+			method:       "HEAD",
+			path:         EmbedPath + script,
+			statusCode:   http.StatusOK,
+			cacheControl: assetCacheControl,
+			body:         []byte{},
+		},
+		{
+			name:         "Get script that is not modified",
+			method:       "GET",
+			path:         EmbedPath + script,
+			headers:      map[string]string{"If-None-Match": `"` + strings.Split(script, ".")[1] + `"`},
+			statusCode:   http.StatusNotModified,
+			cacheControl: assetCacheControl,
+			body:         []byte{},
+		},
+		{
+			name:       "Get script by its unfingerprinted name",
+			method:     "GET",
+			path:       EmbedPath + "app.js",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "Get file outside the widget",
+			method:     "GET",
+			path:       EmbedPath + "index.html",
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(tt.method, tt.path, nil)
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+			recorder := httptest.NewRecorder()
+			muxRouter.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.statusCode {
+				t.Fatalf("Widget returned status code %v, want %v", recorder.Code, tt.statusCode)
+			} else if tt.statusCode == http.StatusNotFound {
+				return
+			}
+
+			if encoding := recorder.Header().Get("Content-Encoding"); encoding != tt.encoding {
+				t.Errorf("Widget returned encoding %q, want %q", encoding, tt.encoding)
+			}
+			if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != tt.cacheControl {
+				t.Errorf("Widget returned Cache-Control %q, want %q", cacheControl, tt.cacheControl)
+			}
+			if vary := recorder.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("Widget returned Vary %q, want Accept-Encoding", vary)
+			}
+
+			body := recorder.Body.Bytes()
+			if tt.encoding == "gzip" {
+				reader, err := gzip.NewReader(recorder.Body)
+				if err != nil {
+					t.Fatalf("Widget returned invalid gzip content: %v", err)
+				}
+				body, _ = io.ReadAll(reader)
+			}
+			if tt.body != nil && !bytes.Equal(body, tt.body) {
+				t.Errorf("Widget returned body %q, want %q", body, tt.body)
+			}
+		})
+	}
+
+	// The loader references the fingerprinted names of the assets
+	request, _ := http.NewRequest("GET", EmbedPath+LoaderName, nil)
+	recorder := httptest.NewRecorder()
+	muxRouter.ServeHTTP(recorder, request)
+	for _, name := range []string{script, style, pacman} {
+		if !strings.Contains(recorder.Body.String(), `"`+name+`"`) {
+			t.Errorf("Loader does not reference %v", name)
+		}
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/javascript") {
+		t.Errorf("Loader has Content-Type %q, want text/javascript", contentType)
+	}
+}
@@ -0,0 +1,12 @@
+// Package resources embeds the client side application into the gocomment
+// binary. Build the client with lein build before building the binary, the
+// compiled widget is missing from the binary otherwise.
+package resources
+
+import "embed"
+
+// Public holds the files of the public directory, including the compiled
+// client under js/compiled once built
+//
+//go:embed public
+var Public embed.FS